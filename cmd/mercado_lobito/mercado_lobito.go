@@ -1,7 +1,9 @@
 package main
 
 import (
-	"github.com/calmestend/mercado_lobito/internal/auth"
+	"os"
+
+	"github.com/calmestend/mercado_lobito/internal/app"
 	"github.com/calmestend/mercado_lobito/internal/db"
 	"github.com/calmestend/mercado_lobito/internal/router"
 	"github.com/calmestend/mercado_lobito/pkg/env"
@@ -9,7 +11,16 @@ import (
 
 func main() {
 	env.Init()
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(migrate(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "uploads" {
+		os.Exit(uploadsCommand(os.Args[2:]))
+	}
+
 	dbConn := db.Init()
-	auth.SetDBConnection(dbConn)
-	router.Init()
+	defer dbConn.Close()
+
+	router.Init(app.New(db.NewMySQLStore(dbConn)))
 }
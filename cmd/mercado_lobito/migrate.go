@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/calmestend/mercado_lobito/internal/db"
+)
+
+const migrateUsage = `Usage: mercado_lobito migrate <command>
+
+Commands:
+  up           Apply every pending migration
+  down [n]     Revert the last n applied migrations (default 1)
+  status       List migrations and whether they are applied`
+
+// Run the migrate subcommand, returns the process exit code
+func migrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	dbConn := db.Open()
+	defer dbConn.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := db.MigrateUp(dbConn)
+		for _, m := range applied {
+			fmt.Printf("Applied  %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("Nothing to migrate")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				fmt.Fprintln(os.Stderr, "Error: steps must be a positive number")
+				return 2
+			}
+			steps = n
+		}
+
+		reverted, err := db.MigrateDown(dbConn, steps)
+		for _, m := range reverted {
+			fmt.Printf("Reverted %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+	case "status":
+		status, err := db.GetMigrationStatus(dbConn)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		for _, s := range status {
+			if s.Applied {
+				fmt.Printf("[x] %04d_%s (applied %s)\n", s.Version, s.Name, s.AppliedAt.Format("2006-01-02 15:04:05"))
+			} else {
+				fmt.Printf("[ ] %04d_%s\n", s.Version, s.Name)
+			}
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	return 0
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/calmestend/mercado_lobito/internal/app"
+	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/calmestend/mercado_lobito/internal/storage"
+	"github.com/calmestend/mercado_lobito/internal/uploads"
+)
+
+const uploadsUsage = `Usage: mercado_lobito uploads <command>
+
+Commands:
+  migrate      Move profile photos stored under student IDs to random keys,
+               making thumbnails for those that have none`
+
+// Run the uploads subcommand, returns the process exit code
+func uploadsCommand(args []string) int {
+	if len(args) == 0 || args[0] != "migrate" {
+		fmt.Fprintln(os.Stderr, uploadsUsage)
+		return 2
+	}
+
+	dbConn := db.Open()
+	defer dbConn.Close()
+
+	moved, missing, rejected, err := migrateUploads(context.Background(), app.New(db.NewMySQLStore(dbConn)))
+	fmt.Printf("Moved %d photos, %d students had none, %d invalid ones were deleted\n", moved, missing, rejected)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+// Photos used to be stored under the student ID, which anyone could guess.
+// Safe to run again, users that already have a key are left alone. Photos
+// the upload checks refuse are logged and deleted, nothing could show them.
+func migrateUploads(ctx context.Context, a *app.App) (moved, missing, rejected int, err error) {
+	students, err := a.Store.Students.List(ctx)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	for _, s := range students {
+		u := db.User{ID: s.UserID}
+		if err := a.Store.Users.GetByID(ctx, &u); err != nil {
+			return moved, missing, rejected, err
+		}
+		if u.PhotoKey != "" {
+			continue
+		}
+
+		key := uploads.NewKey("photos")
+		err := a.Uploads.Move(ctx, s.ID, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			missing++
+			continue
+		}
+		if uploads.IsInvalid(err) {
+			log.Printf("Deleting photo of student %s: %v", s.ID, err)
+			if err := a.Uploads.Remove(ctx, s.ID); err != nil {
+				return moved, missing, rejected, fmt.Errorf("student %s: %w", s.ID, err)
+			}
+			rejected++
+			continue
+		}
+		if err != nil {
+			return moved, missing, rejected, fmt.Errorf("student %s: %w", s.ID, err)
+		}
+
+		u.PhotoKey = key
+		if err := a.Store.Users.SetPhotoKey(ctx, &u); err != nil {
+			return moved, missing, rejected, fmt.Errorf("student %s: %w", s.ID, err)
+		}
+		moved++
+	}
+	return moved, missing, rejected, nil
+}
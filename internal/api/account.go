@@ -0,0 +1,310 @@
+package api
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/internal/auth"
+	"github.com/calmestend/mercado_lobito/internal/components"
+	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/calmestend/mercado_lobito/internal/storage"
+	"github.com/calmestend/mercado_lobito/internal/views"
+)
+
+// Shapes written to the export, secrets like password hashes and session
+// cookies are left out
+type (
+	exportUser struct {
+		ID              int        `json:"id"`
+		MiddleNames     string     `json:"middle_names"`
+		PaternalSurname string     `json:"paternal_surname"`
+		MaternalSurname string     `json:"maternal_surname"`
+		PersonalID      string     `json:"personal_id,omitempty"`
+		Email           string     `json:"email"`
+		EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	}
+	exportStudent struct {
+		ID         string `json:"id"`
+		Grade      string `json:"grade"`
+		ClassGroup string `json:"class_group"`
+	}
+	exportBusiness struct {
+		ID          int    `json:"id"`
+		Name        string `json:"name"`
+		Type        string `json:"type"`
+		Description string `json:"description"`
+	}
+	exportProduct struct {
+		ID         int     `json:"id"`
+		BusinessID int     `json:"business_id"`
+		Title      string  `json:"title"`
+		Price      float64 `json:"price"`
+		Stock      int     `json:"stock"`
+	}
+	// Team of the owned business and the businesses the user collaborates in
+	exportCollaborators struct {
+		Team     []exportTeamMember `json:"team"`
+		MemberOf []exportMembership `json:"member_of"`
+	}
+	exportTeamMember struct {
+		UserID          int           `json:"user_id"`
+		MiddleNames     string        `json:"middle_names"`
+		PaternalSurname string        `json:"paternal_surname"`
+		MaternalSurname string        `json:"maternal_surname"`
+		Email           string        `json:"email"`
+		Permission      db.Permission `json:"permission"`
+	}
+	exportMembership struct {
+		BusinessID int           `json:"business_id"`
+		Permission db.Permission `json:"permission"`
+	}
+	exportSession struct {
+		CreatedAt  time.Time `json:"created_at"`
+		LastSeenAt time.Time `json:"last_seen_at"`
+		ExpiresAt  time.Time `json:"expires_at"`
+		IP         string    `json:"ip"`
+		UserAgent  string    `json:"user_agent"`
+	}
+)
+
+// ZIP with a JSON file per kind of data held about the user plus their
+// profile photo
+func (a *API) ProfileExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	p, _ := auth.PrincipalFromContext(r.Context())
+
+	files, photoKey, err := a.exportFiles(r.Context(), p)
+	if err != nil {
+		http.Error(w, "Error exporting data", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="mercado-lobito-`+strconv.Itoa(p.UserID)+`.zip"`)
+
+	// Headers are gone once the first byte is out, so failures past this
+	// point can only cut the archive short
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			log.Printf("Error writing export for user %d: %v", p.UserID, err)
+			return
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.data); err != nil {
+			log.Printf("Error writing export for user %d: %v", p.UserID, err)
+			return
+		}
+	}
+
+	if photoKey != "" {
+		if err := a.exportPhoto(r.Context(), zw, photoKey); err != nil {
+			log.Printf("Error adding profile photo to export for user %d: %v", p.UserID, err)
+			return
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		log.Printf("Error writing export for user %d: %v", p.UserID, err)
+	}
+}
+
+type exportFile struct {
+	name string
+	data any
+}
+
+// Everything is read before the response starts, so a failing query still
+// gets a proper error status. The user's photo key comes along with the files.
+func (a *API) exportFiles(ctx context.Context, p *auth.Principal) ([]exportFile, string, error) {
+	u := db.User{ID: p.UserID}
+	if err := a.Store.Users.GetByID(ctx, &u); err != nil {
+		return nil, "", err
+	}
+	files := []exportFile{{"user.json", exportUser{
+		ID:              u.ID,
+		MiddleNames:     u.MiddleNames,
+		PaternalSurname: u.PaternalSurname,
+		MaternalSurname: u.MaternalSurname,
+		PersonalID:      u.PersonalID,
+		Email:           u.Email,
+		EmailVerifiedAt: u.EmailVerifiedAt,
+	}}}
+
+	if p.StudentID != "" {
+		s := db.Student{UserID: p.UserID}
+		if err := a.Store.Students.GetByUserID(ctx, &s); err != nil {
+			return nil, "", err
+		}
+		files = append(files, exportFile{"student.json", exportStudent{ID: s.ID, Grade: s.Grade, ClassGroup: s.ClassGroup}})
+	}
+
+	collaborators := exportCollaborators{
+		Team:     []exportTeamMember{},
+		MemberOf: []exportMembership{},
+	}
+	for _, m := range p.MemberOf {
+		collaborators.MemberOf = append(collaborators.MemberOf, exportMembership{BusinessID: m.BusinessID, Permission: m.Permission})
+	}
+
+	if p.OwnedBusinessID != 0 {
+		b := db.Business{ID: p.OwnedBusinessID}
+		if err := a.Store.Businesses.Get(ctx, &b); err != nil {
+			return nil, "", err
+		}
+		files = append(files, exportFile{"business.json", exportBusiness{ID: b.ID, Name: b.Name, Type: b.Type, Description: b.Description}})
+
+		products, err := a.Store.Businesses.GetProductsByOwnerID(ctx, &b)
+		if err != nil {
+			return nil, "", err
+		}
+		exported := []exportProduct{}
+		for _, product := range products {
+			exported = append(exported, exportProduct{
+				ID:         product.ID,
+				BusinessID: product.BusinessID,
+				Title:      product.Title,
+				Price:      product.Price,
+				Stock:      product.Stock,
+			})
+		}
+		files = append(files, exportFile{"products.json", exported})
+
+		team, err := a.Store.Businesses.GetCollaboratorsByBusinessID(ctx, &b)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, c := range team {
+			collaborators.Team = append(collaborators.Team, exportTeamMember{
+				UserID:          c.ID,
+				MiddleNames:     c.MiddleNames,
+				PaternalSurname: c.PaternalSurname,
+				MaternalSurname: c.MaternalSurname,
+				Email:           c.Email,
+				Permission:      c.Permission,
+			})
+		}
+	}
+	files = append(files, exportFile{"collaborators.json", collaborators})
+
+	sessions, err := a.Auth.ListSessions(ctx, p.UserID)
+	if err != nil {
+		return nil, "", err
+	}
+	exported := []exportSession{}
+	for _, s := range sessions {
+		exported = append(exported, exportSession{
+			CreatedAt:  s.CreatedAt,
+			LastSeenAt: s.LastSeenAt,
+			ExpiresAt:  s.ExpiresAt,
+			IP:         s.IP,
+			UserAgent:  s.UserAgent,
+		})
+	}
+	files = append(files, exportFile{"sessions.json", exported})
+
+	return files, u.PhotoKey, nil
+}
+
+func (a *API) exportPhoto(ctx context.Context, zw *zip.Writer, photoKey string) error {
+	photo, err := a.Uploads.Open(ctx, photoKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer photo.Close()
+
+	fw, err := zw.Create("photo.jpg")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, photo)
+	return err
+}
+
+// Delete the signed in user's account after they confirm it with their
+// email, their password or a recent sign in when they have none, and a code
+// when they use two-factor. Owners may name a collaborator in
+// new_owner_id to hand their business to.
+func (a *API) ProfileDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad form", http.StatusBadRequest)
+		return
+	}
+
+	p, _ := auth.PrincipalFromContext(r.Context())
+
+	u := db.User{ID: p.UserID}
+	if err := a.Store.Users.GetByID(r.Context(), &u); err != nil {
+		http.Error(w, "Error loading account", http.StatusInternalServerError)
+		return
+	}
+
+	if r.FormValue("confirm_email") != u.Email {
+		views.AccountResponse("El correo no coincide con el de tu cuenta").Render(r.Context(), w)
+		return
+	}
+	ok, wait, locked, err := a.Auth.Reauthenticate(r, &u, r.FormValue("password"), r.FormValue("code"))
+	if errors.Is(err, auth.ErrSignInAgain) {
+		components.SignInAgain().Render(r.Context(), w)
+		return
+	}
+	if err != nil {
+		views.AccountResponse("Error deleting account").Render(r.Context(), w)
+		return
+	}
+	if wait > 0 {
+		a.Auth.RenderLoginThrottled(w, r, wait, locked)
+		return
+	}
+	if !ok {
+		views.AccountResponse("Invalid Credentials").Render(r.Context(), w)
+		return
+	}
+
+	newOwnerID := 0
+	if idVal := r.FormValue("new_owner_id"); idVal != "" {
+		id, err := strconv.Atoi(idVal)
+		if err != nil {
+			http.Error(w, "Bad new owner ID", http.StatusBadRequest)
+			return
+		}
+		newOwnerID = id
+	}
+
+	err = a.Auth.DeleteAccount(r.Context(), p, newOwnerID)
+	if errors.Is(err, auth.ErrInvalidNewOwner) {
+		views.AccountResponse("Esa persona no puede recibir tu negocio").Render(r.Context(), w)
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting user %d: %v", p.UserID, err)
+		views.AccountResponse("Error deleting account").Render(r.Context(), w)
+		return
+	}
+
+	// The session went with the account
+	http.SetCookie(w, a.Auth.Cookies.Expired("session"))
+	w.Header().Set("HX-Redirect", "/")
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/calmestend/mercado_lobito/internal/storage"
+	"github.com/calmestend/mercado_lobito/pkg/totp"
+)
+
+const testPassword = "correct horse battery"
+
+// Give u testPassword and, when twoFactor is set, an enabled enrollment
+// whose secret is returned
+func (f *fixture) secure(t *testing.T, u *db.User, twoFactor bool) string {
+	t.Helper()
+	ctx := context.Background()
+
+	hash, err := f.api.Auth.HashPassword(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Hash = hash
+	if err := f.api.Store.Users.Update(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	if !twoFactor {
+		return ""
+	}
+
+	now := time.Now()
+	enrollment := db.TOTP{UserID: u.ID, Secret: totp.NewSecret()}
+	if err := f.api.Store.TOTP.SetPending(ctx, &enrollment); err != nil {
+		t.Fatal(err)
+	}
+	enrollment.EnabledAt = &now
+	if err := f.api.Store.TOTP.Enable(ctx, &enrollment); err != nil {
+		t.Fatal(err)
+	}
+	return enrollment.Secret
+}
+
+func (f *fixture) deleteAccount(t *testing.T, u *db.User, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+
+	session, err := f.api.Auth.CreateSession(httptest.NewRequest(http.MethodGet, "/", nil), u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form.Set("confirm_email", u.Email)
+	req := httptest.NewRequest(http.MethodPost, "/api/profile/delete", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "session", Value: session.UUID})
+
+	rec := httptest.NewRecorder()
+	f.api.Auth.AuthMiddleware(f.api.ProfileDelete)(rec, req)
+	return rec
+}
+
+func (f *fixture) exists(t *testing.T, u *db.User) bool {
+	t.Helper()
+
+	err := f.api.Store.Users.GetByID(context.Background(), &db.User{ID: u.ID})
+	if err != nil && !errors.Is(err, db.ErrUserNotFound) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func TestProfileDeleteTwoFactor(t *testing.T) {
+	f := newFixture(t)
+	secret := f.secure(t, f.owner, true)
+
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong, err := totp.Code(secret, totp.Step(time.Now())+5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		form url.Values
+	}{
+		{"no code", url.Values{"password": {testPassword}}},
+		{"wrong code", url.Values{"password": {testPassword}, "code": {wrong}}},
+		{"wrong password", url.Values{"password": {"wrong password!"}, "code": {code}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := f.deleteAccount(t, f.owner, tt.form)
+			if !strings.Contains(rec.Body.String(), "Invalid Credentials") {
+				t.Errorf("response = %d %s", rec.Code, rec.Body)
+			}
+			if !f.exists(t, f.owner) {
+				t.Fatal("account deleted")
+			}
+		})
+	}
+
+	rec := f.deleteAccount(t, f.owner, url.Values{"password": {testPassword}, "code": {code}})
+	if rec.Header().Get("HX-Redirect") != "/" || f.exists(t, f.owner) {
+		t.Errorf("not deleted with password and code: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestProfileDeleteRemovesProductImages(t *testing.T) {
+	f := newFixture(t)
+	f.secure(t, f.owner, false)
+	f.upload(t, testJPEG(t), testJPEG(t))
+	images := f.images(t)
+	if len(images) != 2 {
+		t.Fatalf("%d images, want 2", len(images))
+	}
+
+	rec := f.deleteAccount(t, f.owner, url.Values{"password": {testPassword}})
+	if f.exists(t, f.owner) {
+		t.Fatalf("not deleted: %d %s", rec.Code, rec.Body)
+	}
+
+	for _, img := range images {
+		if _, err := f.api.Uploads.Open(context.Background(), img.Key); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("image %s: %v, want it removed", img.Key, err)
+		}
+	}
+}
+
+func TestProfileDeleteKeepsInvitations(t *testing.T) {
+	f := newFixture(t)
+	f.secure(t, f.manager, false)
+	ctx := context.Background()
+
+	inv := db.Invitation{
+		BusinessID: f.business.ID,
+		Email:      "new@example.com",
+		Permission: db.PermissionReadOnly,
+		Nonce:      strconv.Itoa(f.manager.ID),
+		Status:     db.InvitationPending,
+		InvitedBy:  f.manager.ID,
+		ExpiresAt:  time.Now().Add(time.Hour),
+	}
+	if err := f.api.Store.Invitations.Set(ctx, &inv); err != nil {
+		t.Fatal(err)
+	}
+
+	f.deleteAccount(t, f.manager, url.Values{"password": {testPassword}})
+	if f.exists(t, f.manager) {
+		t.Fatal("not deleted")
+	}
+
+	got := db.Invitation{ID: inv.ID}
+	if err := f.api.Store.Invitations.Get(ctx, &got); err != nil {
+		t.Fatalf("invitation went with its inviter: %v", err)
+	}
+	if got.InvitedBy != 0 || got.Status != db.InvitationPending {
+		t.Errorf("invitation = %+v, want it pending with no inviter", got)
+	}
+}
@@ -5,11 +5,32 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/calmestend/mercado_lobito/internal/app"
 	"github.com/calmestend/mercado_lobito/internal/auth"
 	"github.com/calmestend/mercado_lobito/internal/db"
 )
 
-func ProfileConfig(w http.ResponseWriter, r *http.Request) {
+type API struct {
+	*app.App
+	Auth *auth.Auth
+}
+
+func New(a *app.App, au *auth.Auth) *API {
+	return &API{App: a, Auth: au}
+}
+
+// Business the request acts on. Writes the error response and returns false
+// when the principal doesn't hold the required permission.
+func (a *API) business(w http.ResponseWriter, r *http.Request, required db.Permission) (*auth.Membership, bool) {
+	m, err := a.Auth.ResolveBusiness(r, required)
+	if err != nil {
+		auth.BusinessError(w, err)
+		return nil, false
+	}
+	return m, true
+}
+
+func (a *API) ProfileConfig(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
@@ -20,17 +41,14 @@ func ProfileConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dbConn := db.Init()
-	defer dbConn.Close()
-
-	sess, err := auth.GetSessionFromRequest(r)
+	sess, err := a.Auth.GetSessionFromRequest(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
 	student := db.Student{UserID: sess.UserID}
-	if err := student.GetByUserID(dbConn); err != nil {
+	if err := a.Store.Students.GetByUserID(r.Context(), &student); err != nil {
 		http.Error(w, "Student not found", http.StatusNotFound)
 		return
 	}
@@ -40,15 +58,21 @@ func ProfileConfig(w http.ResponseWriter, r *http.Request) {
 	description := r.FormValue("description")
 
 	business := db.Business{OwnerID: student.ID}
-	if err := business.GetByOwnerID(dbConn); err == nil {
+	if err := a.Store.Businesses.GetByOwnerID(r.Context(), &business); err == nil {
 		business.Name = name
 		business.Type = businessType
 		business.Description = description
-		if err := business.Update(dbConn); err != nil {
+		if err := a.Store.Businesses.Update(r.Context(), &business); err != nil {
 			http.Error(w, "Error updating business", http.StatusInternalServerError)
 			return
 		}
 	} else {
+		if p, ok := auth.PrincipalFromContext(r.Context()); !ok || !p.EmailVerified {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, `<p style="color:red;">Verifica tu correo antes de crear un negocio.</p>`)
+			return
+		}
+
 		business = db.Business{
 			Name:        name,
 			Type:        businessType,
@@ -57,7 +81,7 @@ func ProfileConfig(w http.ResponseWriter, r *http.Request) {
 		}
 
 		log.Print(business)
-		if err := business.Set(dbConn); err != nil {
+		if err := a.Store.Businesses.Set(r.Context(), &business); err != nil {
 			http.Error(w, "Error creating business", http.StatusInternalServerError)
 			return
 		}
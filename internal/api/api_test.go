@@ -0,0 +1,168 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/calmestend/mercado_lobito/internal/app"
+	"github.com/calmestend/mercado_lobito/internal/auth"
+	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/calmestend/mercado_lobito/internal/db/memory"
+)
+
+// API over an in-memory store with a business owned by owner, where
+// readOnly and manager collaborate and outsider doesn't. There's no backoff
+// so tests can fail passwords back to back.
+type fixture struct {
+	api      *API
+	business db.Business
+	product  db.Product
+
+	owner, readOnly, manager, outsider *db.User
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+
+	t.Setenv("BCRYPT_COST", "4")
+	t.Setenv("UPLOAD_DIR", t.TempDir())
+	t.Setenv("APP_SECRET", "test secret")
+
+	a := app.New(memory.New())
+	authentication := auth.New(a)
+	authentication.Login.BackoffBase = 0
+	authentication.Login.BackoffMax = 0
+	f := &fixture{api: New(a, authentication)}
+	ctx := context.Background()
+
+	user := func(email string) *db.User {
+		u := db.User{Email: email, MiddleNames: email}
+		if err := a.Store.Users.Set(ctx, &u); err != nil {
+			t.Fatal(err)
+		}
+		return &u
+	}
+	f.owner = user("owner@example.com")
+	f.readOnly = user("reader@example.com")
+	f.manager = user("manager@example.com")
+	f.outsider = user("outsider@example.com")
+
+	if err := a.Store.Students.Set(ctx, &db.Student{ID: "1001", UserID: f.owner.ID}); err != nil {
+		t.Fatal(err)
+	}
+	f.business = db.Business{Name: "Tacos", OwnerID: "1001"}
+	if err := a.Store.Businesses.Set(ctx, &f.business); err != nil {
+		t.Fatal(err)
+	}
+	for u, permission := range map[*db.User]db.Permission{f.readOnly: db.PermissionReadOnly, f.manager: db.PermissionManageProducts} {
+		bc := db.BusinessCollaborator{BusinessID: f.business.ID, CollaboratorID: u.ID, Permission: permission}
+		if err := a.Store.BusinessCollaborators.Set(ctx, &bc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f.product = db.Product{Title: "Taco", Price: 20, Stock: 5, BusinessID: f.business.ID}
+	if err := a.Store.Products.Set(ctx, &f.product); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// Send a request as u through the same middleware the router uses for
+// /api/products
+func (f *fixture) do(t *testing.T, u *db.User, method, target string, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+
+	session, err := f.api.Auth.CreateSession(httptest.NewRequest(http.MethodGet, "/", nil), u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "session", Value: session.UUID})
+
+	h := f.api.Auth.AuthMiddleware(f.api.Auth.RequireBusinessMember(f.api.Products))
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+func TestProductsPermissions(t *testing.T) {
+	f := newFixture(t)
+	businessID := strconv.Itoa(f.business.ID)
+	productID := strconv.Itoa(f.product.ID)
+
+	list := func(t *testing.T, u *db.User) int {
+		return f.do(t, u, http.MethodGet, "/api/products?business_id="+businessID, nil).Code
+	}
+	create := func(t *testing.T, u *db.User) int {
+		form := url.Values{"business_id": {businessID}, "title": {"Agua"}, "price": {"10"}, "stock": {"3"}}
+		return f.do(t, u, http.MethodPost, "/api/products", form).Code
+	}
+	update := func(t *testing.T, u *db.User) int {
+		form := url.Values{"business_id": {businessID}, "id": {productID}, "title": {"Taco"}, "price": {"25"}, "stock": {"5"}}
+		return f.do(t, u, http.MethodPatch, "/api/products", form).Code
+	}
+	remove := func(t *testing.T, u *db.User) int {
+		return f.do(t, u, http.MethodDelete, "/api/products?business_id="+businessID+"&id="+productID, nil).Code
+	}
+
+	tests := []struct {
+		name   string
+		action func(*testing.T, *db.User) int
+		user   *db.User
+		want   int
+	}{
+		{"owner lists", list, f.owner, http.StatusOK},
+		{"read only lists", list, f.readOnly, http.StatusOK},
+		{"outsider lists", list, f.outsider, http.StatusForbidden},
+		{"read only creates", create, f.readOnly, http.StatusForbidden},
+		{"outsider creates", create, f.outsider, http.StatusForbidden},
+		{"manager creates", create, f.manager, http.StatusOK},
+		{"owner creates", create, f.owner, http.StatusOK},
+		{"read only updates", update, f.readOnly, http.StatusForbidden},
+		{"manager updates", update, f.manager, http.StatusOK},
+		{"read only deletes", remove, f.readOnly, http.StatusForbidden},
+		{"outsider deletes", remove, f.outsider, http.StatusForbidden},
+		{"manager deletes", remove, f.manager, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.action(t, tt.user); got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProductOfAnotherBusiness(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+
+	other := db.Business{Name: "Jugos", OwnerID: "2002"}
+	if err := f.api.Store.Businesses.Set(ctx, &other); err != nil {
+		t.Fatal(err)
+	}
+	foreign := db.Product{Title: "Jugo", BusinessID: other.ID}
+	if err := f.api.Store.Products.Set(ctx, &foreign); err != nil {
+		t.Fatal(err)
+	}
+
+	// Managing products in one business doesn't reach another's products
+	target := "/api/products/edit/" + strconv.Itoa(foreign.ID) + "?business_id=" + strconv.Itoa(f.business.ID)
+	if rec := f.do(t, f.manager, http.MethodGet, target, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/internal/auth"
+	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/calmestend/mercado_lobito/internal/views"
+)
+
+func (a *API) ProfileTokens(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.renderTokens(w, r, "", "")
+	case http.MethodPost:
+		a.createToken(w, r)
+	case http.MethodDelete:
+		a.revokeToken(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Render the token list, with the one just created when token isn't empty
+func (a *API) renderTokens(w http.ResponseWriter, r *http.Request, token, message string) {
+	p, _ := auth.PrincipalFromContext(r.Context())
+
+	tokens, err := a.Store.APITokens.ListByUserID(r.Context(), p.UserID)
+	if err != nil {
+		http.Error(w, "Error retrieving tokens", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	views.TokensList(tokens, token, message).Render(r.Context(), w)
+}
+
+func (a *API) createToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad form", http.StatusBadRequest)
+		return
+	}
+
+	p, _ := auth.PrincipalFromContext(r.Context())
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" || len(name) > 100 {
+		a.renderTokens(w, r, "", "El nombre es obligatorio y de máximo 100 caracteres")
+		return
+	}
+
+	var scopes []db.Scope
+	for _, value := range r.Form["scopes"] {
+		scope := db.Scope(value)
+		if !scope.Valid() {
+			http.Error(w, "Invalid scope", http.StatusBadRequest)
+			return
+		}
+		scopes = append(scopes, scope)
+	}
+	if len(scopes) == 0 {
+		a.renderTokens(w, r, "", "Elige al menos un permiso")
+		return
+	}
+
+	// Days until it expires, empty for never
+	var expiresAt *time.Time
+	if days := r.FormValue("expires_in_days"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid expiry", http.StatusBadRequest)
+			return
+		}
+		t := time.Now().AddDate(0, 0, n)
+		expiresAt = &t
+	}
+
+	token, _, err := a.Auth.CreateAPIToken(r.Context(), p.UserID, name, scopes, expiresAt)
+	if err != nil {
+		http.Error(w, "Error creating token", http.StatusInternalServerError)
+		return
+	}
+
+	a.renderTokens(w, r, token, "")
+}
+
+func (a *API) revokeToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad form", http.StatusBadRequest)
+		return
+	}
+
+	p, _ := auth.PrincipalFromContext(r.Context())
+
+	id, err := strconv.Atoi(r.FormValue("token_id"))
+	if err != nil {
+		http.Error(w, "Bad token ID", http.StatusBadRequest)
+		return
+	}
+
+	t := db.APIToken{ID: id, UserID: p.UserID}
+	err = a.Store.APITokens.DeleteForUser(r.Context(), &t)
+	if errors.Is(err, db.ErrAPITokenNotFound) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error revoking token", http.StatusInternalServerError)
+		return
+	}
+
+	a.renderTokens(w, r, "", "Token revocado")
+}
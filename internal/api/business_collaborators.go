@@ -1,147 +1,72 @@
 package api
 
 import (
-	"log"
 	"net/http"
 	"strconv"
 
 	"github.com/calmestend/mercado_lobito/internal/auth"
 	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/calmestend/mercado_lobito/internal/uploads"
 	"github.com/calmestend/mercado_lobito/internal/views"
 )
 
-func BusinessCollaborators(w http.ResponseWriter, r *http.Request) {
+func (a *API) BusinessCollaborators(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		getAllCollaboratorsByBusiness(w, r)
-	case http.MethodPost:
-		createCollaborator(w, r)
+		a.getAllCollaboratorsByBusiness(w, r)
 	case http.MethodPatch:
-		updateCollaborator(w, r)
+		a.updateCollaborator(w, r)
 	case http.MethodDelete:
-		deleteCollaborator(w, r)
+		a.deleteCollaborator(w, r)
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
-func getAllCollaboratorsByBusiness(w http.ResponseWriter, r *http.Request) {
-	dbConn := db.Init()
-	defer dbConn.Close()
-	sess, err := auth.GetSessionFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-	student := db.Student{UserID: sess.UserID}
-	if err := student.GetByUserID(dbConn); err != nil {
-		http.Error(w, "Student not found", http.StatusNotFound)
-		return
-	}
-	business := db.Business{OwnerID: student.ID}
-	if err := business.GetByOwnerID(dbConn); err != nil {
-		http.Error(w, "Business not found", http.StatusNotFound)
-		return
-	}
-
-	collabs, err := business.GetCollaboratorsByBusinessID(dbConn)
-	log.Print(collabs)
-	if err != nil {
-		http.Error(w, "Error retrieving collaborators", http.StatusInternalServerError)
+func (a *API) getAllCollaboratorsByBusiness(w http.ResponseWriter, r *http.Request) {
+	m, ok := a.business(w, r, db.PermissionReadOnly)
+	if !ok {
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/html")
-	views.CollaboratorsList(collabs).Render(r.Context(), w)
+	a.renderCollaborators(w, r, m)
 }
 
-func createCollaborator(w http.ResponseWriter, r *http.Request) {
-	if err := r.ParseMultipartForm(10 << 20); err != nil {
-		http.Error(w, "Error parsing form", http.StatusBadRequest)
-		return
-	}
-	dbConn := db.Init()
-	defer dbConn.Close()
-	sess, err := auth.GetSessionFromRequest(r)
+func (a *API) renderCollaborators(w http.ResponseWriter, r *http.Request, m *auth.Membership) {
+	collabs, err := a.Store.Businesses.GetCollaboratorsByBusinessID(r.Context(), &m.Business)
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-	student := db.Student{UserID: sess.UserID}
-	if err := student.GetByUserID(dbConn); err != nil {
-		http.Error(w, "Student not found", http.StatusNotFound)
-		return
-	}
-	business := db.Business{OwnerID: student.ID}
-	if err := business.GetByOwnerID(dbConn); err != nil {
-		http.Error(w, "Business not found", http.StatusNotFound)
-		return
-	}
-
-	file, _, err := r.FormFile("file")
-	if err == nil {
-		_ = file.Close()
-	}
-
-	isIntern := r.FormValue("isIntern")
-	middleNames := r.FormValue("middle_names")
-	paternal := r.FormValue("paternal_surname")
-	maternal := r.FormValue("maternal_surname")
-	email := r.FormValue("email")
-
-	u := db.User{
-		MiddleNames:     middleNames,
-		PaternalSurname: paternal,
-		MaternalSurname: maternal,
-		Email:           email,
-	}
-
-	if isIntern == "false" {
-		u.PersonalID = r.FormValue("personal_id")
-	}
-
-	if err := u.Set(dbConn); err != nil {
-		http.Error(w, "Error creating user", http.StatusInternalServerError)
+		http.Error(w, "Error retrieving collaborators", http.StatusInternalServerError)
 		return
 	}
 
-	if isIntern == "true" {
-		s := db.Student{Grade: r.FormValue("grade"), ClassGroup: r.FormValue("class_group"), UserID: u.ID, ID: r.FormValue("student_id")}
-		if err := s.Set(dbConn); err != nil {
-			http.Error(w, "Error creating student", http.StatusInternalServerError)
-			return
+	photos := map[int]string{}
+	for _, c := range collabs {
+		if c.PhotoKey != "" {
+			photos[c.ID] = a.Uploads.URL(c.PhotoKey, uploads.ThumbnailSmall)
 		}
 	}
 
-	bc := db.BusinessCollaborator{BusinessID: business.ID, CollaboratorID: u.ID}
-	if err := bc.Set(dbConn); err != nil {
-		http.Error(w, "Error linking collaborator", http.StatusInternalServerError)
-		return
-	}
+	w.Header().Set("Content-Type", "text/html")
+	views.CollaboratorsList(collabs, photos, m.Business.ID, m.Permission.Allows(db.PermissionManageTeam)).Render(r.Context(), w)
+}
 
-	getAllCollaboratorsByBusiness(w, r)
+// Permission from the form, read-only when left empty
+func formPermission(r *http.Request) (db.Permission, bool) {
+	permission := db.Permission(r.FormValue("permission"))
+	if permission == "" {
+		return db.PermissionReadOnly, true
+	}
+	return permission, permission.Valid()
 }
 
-func updateCollaborator(w http.ResponseWriter, r *http.Request) {
+func (a *API) updateCollaborator(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Bad form", http.StatusBadRequest)
 		return
 	}
-	dbConn := db.Init()
-	defer dbConn.Close()
-	sess, err := auth.GetSessionFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-	student := db.Student{UserID: sess.UserID}
-	if err := student.GetByUserID(dbConn); err != nil {
-		http.Error(w, "Student not found", http.StatusNotFound)
-		return
-	}
-	business := db.Business{OwnerID: student.ID}
-	if err := business.GetByOwnerID(dbConn); err != nil {
-		http.Error(w, "Business not found", http.StatusNotFound)
+
+	m, ok := a.business(w, r, db.PermissionManageTeam)
+	if !ok {
 		return
 	}
 
@@ -152,64 +77,74 @@ func updateCollaborator(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	bc := db.BusinessCollaborator{BusinessID: business.ID, CollaboratorID: idInt}
-	if err := bc.GetByBusinessAndCollaborator(dbConn); err != nil {
+	bc := db.BusinessCollaborator{BusinessID: m.Business.ID, CollaboratorID: idInt}
+	if err := a.Store.BusinessCollaborators.GetByBusinessAndCollaborator(r.Context(), &bc); err != nil {
 		http.Error(w, "Not found", http.StatusNotFound)
 		return
 	}
 
-	user := db.User{ID: idInt,
-		MiddleNames:     r.FormValue("middle_names"),
-		PaternalSurname: r.FormValue("paternal_surname"),
-		MaternalSurname: r.FormValue("maternal_surname"),
-		Email:           r.FormValue("email"),
+	if r.FormValue("permission") != "" {
+		permission, ok := formPermission(r)
+		if !ok {
+			http.Error(w, "Invalid permission", http.StatusBadRequest)
+			return
+		}
+
+		bc.Permission = permission
+		if err := a.Store.BusinessCollaborators.UpdatePermission(r.Context(), &bc); err != nil {
+			http.Error(w, "Error updating permission", http.StatusInternalServerError)
+			return
+		}
 	}
-	if err := user.Update(dbConn); err != nil {
-		http.Error(w, "Error updating user", http.StatusInternalServerError)
+
+	// Only a request carrying the profile fields edits the user
+	if r.FormValue("middle_names") == "" {
+		a.renderCollaborators(w, r, m)
 		return
 	}
 
+	user := db.User{ID: idInt}
+	if err := a.Store.Users.GetByID(r.Context(), &user); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	user.MiddleNames = r.FormValue("middle_names")
+	user.PaternalSurname = r.FormValue("paternal_surname")
+	user.MaternalSurname = r.FormValue("maternal_surname")
+	// The email stays as the user verified it, otherwise whoever manages the
+	// team could point it at themselves and reset the password
+
 	if r.FormValue("isIntern") == "true" {
 		s := db.Student{UserID: idInt}
-		if err := s.GetByUserID(dbConn); err == nil {
+		if err := a.Store.Students.GetByUserID(r.Context(), &s); err == nil {
 			s.Grade = r.FormValue("grade")
 			s.ClassGroup = r.FormValue("class_group")
-			if err := s.Update(dbConn); err != nil {
+			if err := a.Store.Students.Update(r.Context(), &s); err != nil {
 				http.Error(w, "Error updating student", http.StatusInternalServerError)
 				return
 			}
 		}
 	} else {
 		user.PersonalID = r.FormValue("personal_id")
-		if err := user.Update(dbConn); err != nil {
-			http.Error(w, "Error updating external data", http.StatusInternalServerError)
-			return
-		}
 	}
 
-	getAllCollaboratorsByBusiness(w, r)
+	if err := a.Store.Users.Update(r.Context(), &user); err != nil {
+		http.Error(w, "Error updating user", http.StatusInternalServerError)
+		return
+	}
+
+	a.renderCollaborators(w, r, m)
 }
 
-func deleteCollaborator(w http.ResponseWriter, r *http.Request) {
+func (a *API) deleteCollaborator(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Bad form", http.StatusBadRequest)
 		return
 	}
-	dbConn := db.Init()
-	defer dbConn.Close()
-	sess, err := auth.GetSessionFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-	student := db.Student{UserID: sess.UserID}
-	if err := student.GetByUserID(dbConn); err != nil {
-		http.Error(w, "Student not found", http.StatusNotFound)
-		return
-	}
-	business := db.Business{OwnerID: student.ID}
-	if err := business.GetByOwnerID(dbConn); err != nil {
-		http.Error(w, "Business not found", http.StatusNotFound)
+
+	m, ok := a.business(w, r, db.PermissionManageTeam)
+	if !ok {
 		return
 	}
 
@@ -220,20 +155,20 @@ func deleteCollaborator(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	bc := db.BusinessCollaborator{BusinessID: business.ID, CollaboratorID: idInt}
-	if err := bc.GetByBusinessAndCollaborator(dbConn); err != nil {
+	bc := db.BusinessCollaborator{BusinessID: m.Business.ID, CollaboratorID: idInt}
+	if err := a.Store.BusinessCollaborators.GetByBusinessAndCollaborator(r.Context(), &bc); err != nil {
 		http.Error(w, "Not found", http.StatusNotFound)
 		return
 	}
-	if err := bc.Delete(dbConn); err != nil {
+	if err := a.Store.BusinessCollaborators.Delete(r.Context(), &bc); err != nil {
 		http.Error(w, "Error deleting collaborator", http.StatusInternalServerError)
 		return
 	}
 
-	getAllCollaboratorsByBusiness(w, r)
+	a.renderCollaborators(w, r, m)
 }
 
-func CollaboratorForm(w http.ResponseWriter, r *http.Request) {
+func (a *API) CollaboratorForm(w http.ResponseWriter, r *http.Request) {
 	typ := r.URL.Query().Get("type")
 	w.Header().Set("Content-Type", "text/html")
 	switch typ {
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"github.com/calmestend/mercado_lobito/internal/auth"
+	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/calmestend/mercado_lobito/internal/views"
+)
+
+func (a *API) BusinessInvitations(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.getPendingInvitations(w, r)
+	case http.MethodPost:
+		a.createInvitation(w, r)
+	case http.MethodPatch:
+		a.resendInvitation(w, r)
+	case http.MethodDelete:
+		a.revokeInvitation(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *API) getPendingInvitations(w http.ResponseWriter, r *http.Request) {
+	m, ok := a.business(w, r, db.PermissionManageTeam)
+	if !ok {
+		return
+	}
+
+	a.renderInvitations(w, r, m, "")
+}
+
+func (a *API) renderInvitations(w http.ResponseWriter, r *http.Request, m *auth.Membership, message string) {
+	invitations, err := a.Store.Invitations.ListPendingByBusinessID(r.Context(), m.Business.ID)
+	if err != nil {
+		http.Error(w, "Error retrieving invitations", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	views.InvitationsList(invitations, m.Business.ID, message).Render(r.Context(), w)
+}
+
+func (a *API) createInvitation(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	m, ok := a.business(w, r, db.PermissionManageTeam)
+	if !ok {
+		return
+	}
+
+	email := strings.TrimSpace(r.FormValue("email"))
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		http.Error(w, "Invalid email", http.StatusBadRequest)
+		return
+	}
+
+	permission, ok := formPermission(r)
+	if !ok {
+		http.Error(w, "Invalid permission", http.StatusBadRequest)
+		return
+	}
+
+	p, _ := auth.PrincipalFromContext(r.Context())
+
+	_, err = a.Auth.Invite(r.Context(), &m.Business, email, permission, p.UserID)
+	switch {
+	case errors.Is(err, auth.ErrAlreadyInvited):
+		a.renderInvitations(w, r, m, "Ya hay una invitación pendiente para ese correo")
+	case err != nil:
+		log.Printf("Error sending invitation to %s: %v", email, err)
+		a.renderInvitations(w, r, m, "No se pudo enviar la invitación, intenta reenviarla")
+	default:
+		a.renderInvitations(w, r, m, "Invitación enviada")
+	}
+}
+
+// Pending invitation of the request's business named by invitation_id
+func (a *API) formInvitation(w http.ResponseWriter, r *http.Request, m *auth.Membership) (*db.Invitation, bool) {
+	id, err := strconv.Atoi(r.FormValue("invitation_id"))
+	if err != nil {
+		http.Error(w, "Bad invitation ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	inv := db.Invitation{ID: id}
+	if err := a.Store.Invitations.Get(r.Context(), &inv); err != nil || inv.BusinessID != m.Business.ID {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return &inv, true
+}
+
+func (a *API) resendInvitation(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad form", http.StatusBadRequest)
+		return
+	}
+
+	m, ok := a.business(w, r, db.PermissionManageTeam)
+	if !ok {
+		return
+	}
+
+	inv, ok := a.formInvitation(w, r, m)
+	if !ok {
+		return
+	}
+
+	if err := a.Auth.ResendInvitation(r.Context(), inv, &m.Business); err != nil {
+		log.Printf("Error resending invitation %d: %v", inv.ID, err)
+		a.renderInvitations(w, r, m, "No se pudo reenviar la invitación")
+		return
+	}
+
+	a.renderInvitations(w, r, m, "Invitación reenviada")
+}
+
+func (a *API) revokeInvitation(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad form", http.StatusBadRequest)
+		return
+	}
+
+	m, ok := a.business(w, r, db.PermissionManageTeam)
+	if !ok {
+		return
+	}
+
+	inv, ok := a.formInvitation(w, r, m)
+	if !ok {
+		return
+	}
+
+	if err := a.Auth.RevokeInvitation(r.Context(), inv); err != nil {
+		http.Error(w, "Error revoking invitation", http.StatusInternalServerError)
+		return
+	}
+
+	a.renderInvitations(w, r, m, "Invitación revocada")
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/calmestend/mercado_lobito/internal/auth"
+	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/calmestend/mercado_lobito/internal/uploads"
+	"github.com/calmestend/mercado_lobito/internal/views"
+)
+
+// Replace the signed in user's profile photo, students and external
+// collaborators alike
+func (a *API) ProfilePhoto(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	p, _ := auth.PrincipalFromContext(r.Context())
+
+	u := db.User{ID: p.UserID}
+	if err := a.Store.Users.GetByID(r.Context(), &u); err != nil {
+		http.Error(w, "Error loading account", http.StatusInternalServerError)
+		return
+	}
+	imgSrc := a.Uploads.URL(u.PhotoKey, uploads.ThumbnailLarge)
+
+	a.Uploads.LimitBody(w, r, 1)
+	err := r.ParseMultipartForm(a.Uploads.Limits.MaxBytes)
+	if uploads.BodyTooLarge(err) {
+		views.ProfilePhoto(imgSrc, "Photo is too large").Render(r.Context(), w)
+		return
+	}
+	if err != nil {
+		views.ProfilePhoto(imgSrc, "Error processing form").Render(r.Context(), w)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		views.ProfilePhoto(imgSrc, "Photo is required").Render(r.Context(), w)
+		return
+	}
+	defer file.Close()
+
+	staged, err := a.Uploads.Stage(file)
+	if uploads.IsInvalid(err) {
+		views.ProfilePhoto(imgSrc, "Invalid photo: "+err.Error()).Render(r.Context(), w)
+		return
+	}
+	if err == nil {
+		err = a.Auth.ReplacePhoto(r.Context(), &u, staged)
+	}
+	if err != nil {
+		log.Printf("Error saving profile photo of user %d: %v", u.ID, err)
+		views.ProfilePhoto(imgSrc, "Error saving photo").Render(r.Context(), w)
+		return
+	}
+
+	// The new photo has a key, and so a URL, of its own
+	views.ProfilePhoto(a.Uploads.URL(u.PhotoKey, uploads.ThumbnailLarge), "Foto actualizada").Render(r.Context(), w)
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProfilePhotoBodyCap(t *testing.T) {
+	f := newFixture(t)
+	f.api.Uploads.Limits.MaxBytes = 1 << 10
+
+	big := make([]byte, 2<<20)
+	rand.Read(big)
+	body, contentType := multipartBody(t, f.business.ID, big)
+
+	session, err := f.api.Auth.CreateSession(httptest.NewRequest(http.MethodGet, "/", nil), f.owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/profile/photo", body)
+	req.Header.Set("Content-Type", contentType)
+	req.AddCookie(&http.Cookie{Name: "session", Value: session.UUID})
+
+	rec := httptest.NewRecorder()
+	f.api.Auth.AuthMiddleware(f.api.ProfilePhoto)(rec, req)
+	if !strings.Contains(rec.Body.String(), "Photo is too large") {
+		t.Errorf("body = %s, want the photo refused as too large", rec.Body)
+	}
+}
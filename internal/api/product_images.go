@@ -0,0 +1,343 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/calmestend/mercado_lobito/internal/uploads"
+	"github.com/calmestend/mercado_lobito/internal/views"
+)
+
+const maxProductImages = 8
+
+var (
+	errTooManyImages   = errors.New("too many images")
+	errIncompleteOrder = errors.New("order doesn't list every image once")
+)
+
+// Routes /api/products/{id}/images, to upload (POST) and reorder (PATCH),
+// and /api/products/{id}/images/{imageID}, to delete (DELETE) or make the
+// cover (POST .../cover)
+func (a *API) ProductImages(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 4 || len(parts) > 6 || parts[3] != "images" || (len(parts) == 6 && parts[5] != "cover") {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Uploads are multipart, the business the product belongs to is read
+	// from the form
+	var err error
+	if r.Method == http.MethodPost && len(parts) == 4 {
+		err = r.ParseMultipartForm(a.Uploads.Limits.MaxBytes)
+	} else {
+		err = r.ParseForm()
+	}
+	if uploads.BodyTooLarge(err) {
+		http.Error(w, "Upload is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+	if r.MultipartForm != nil {
+		defer r.MultipartForm.RemoveAll()
+	}
+
+	product, ok := a.managedProduct(w, r, parts[2])
+	if !ok {
+		return
+	}
+
+	if len(parts) == 4 {
+		switch r.Method {
+		case http.MethodPost:
+			a.uploadProductImages(w, r, product)
+		case http.MethodPatch:
+			a.reorderProductImages(w, r, product)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	imageID, err := strconv.Atoi(parts[4])
+	if err != nil {
+		http.Error(w, "Invalid image ID", http.StatusBadRequest)
+		return
+	}
+	img := db.ProductImage{ID: imageID}
+	err = a.Store.ProductImages.GetByID(r.Context(), &img)
+	if err != nil || img.ProductID != product.ID {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case len(parts) == 5 && r.Method == http.MethodDelete:
+		a.deleteProductImage(w, r, product, &img)
+	case len(parts) == 6 && r.Method == http.MethodPost:
+		a.setProductCover(w, r, product, &img)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Cap upload bodies to maxProductImages images. Goes in front of
+// RequireBusinessMember, which parses the form looking for the business.
+func (a *API) LimitProductImageUploads(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			a.Uploads.LimitBody(w, r, maxProductImages)
+		}
+		next(w, r)
+	}
+}
+
+func (a *API) renderProductImages(w http.ResponseWriter, r *http.Request, product *db.Product, message string) {
+	images, err := a.Store.ProductImages.GetByProductID(r.Context(), product.ID)
+	if err != nil {
+		http.Error(w, "Error retrieving images", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	views.ProductImages(product.ID, a.productImageThumbs(images), message).Render(r.Context(), w)
+}
+
+func (a *API) productImageThumbs(images []db.ProductImage) []views.ProductImageThumb {
+	thumbs := make([]views.ProductImageThumb, 0, len(images))
+	for _, img := range images {
+		thumbs = append(thumbs, views.ProductImageThumb{
+			ID:      img.ID,
+			Src:     a.Uploads.URL(img.Key, uploads.ThumbnailSmall),
+			IsCover: img.IsCover,
+		})
+	}
+	return thumbs
+}
+
+// Cover thumbnail URL of the product, empty when it has no images
+func (a *API) productCover(ctx context.Context, productID int) (string, error) {
+	images, err := a.Store.ProductImages.GetByProductID(ctx, productID)
+	if err != nil {
+		return "", err
+	}
+	for _, img := range images {
+		if img.IsCover {
+			return a.Uploads.URL(img.Key, uploads.ThumbnailSmall), nil
+		}
+	}
+	return "", nil
+}
+
+// Every file of the upload is checked before any is stored, so a bad one
+// doesn't leave the product with half of them
+func (a *API) uploadProductImages(w http.ResponseWriter, r *http.Request, product *db.Product) {
+	files := r.MultipartForm.File["file"]
+	if len(files) == 0 {
+		a.renderProductImages(w, r, product, "Image is required")
+		return
+	}
+
+	tooMany := "A product can have up to " + strconv.Itoa(maxProductImages) + " images"
+
+	// Spares processing an upload that can't fit, the limit itself is
+	// enforced when the rows are inserted
+	existing, err := a.Store.ProductImages.GetByProductID(r.Context(), product.ID)
+	if err != nil {
+		http.Error(w, "Error retrieving images", http.StatusInternalServerError)
+		return
+	}
+	if len(existing)+len(files) > maxProductImages {
+		a.renderProductImages(w, r, product, tooMany)
+		return
+	}
+
+	var staged []*uploads.Staged
+	for _, fh := range files {
+		f, err := fh.Open()
+		if err != nil {
+			http.Error(w, "Error reading upload", http.StatusBadRequest)
+			return
+		}
+		s, err := a.Uploads.Stage(f)
+		f.Close()
+		if uploads.IsInvalid(err) {
+			a.renderProductImages(w, r, product, "Invalid image "+fh.Filename+": "+err.Error())
+			return
+		}
+		if err != nil {
+			log.Printf("Error processing image for product %d: %v", product.ID, err)
+			a.renderProductImages(w, r, product, "Error saving images")
+			return
+		}
+		staged = append(staged, s)
+	}
+
+	var keys []string
+	removeAll := func() {
+		ctx := context.WithoutCancel(r.Context())
+		for _, key := range keys {
+			a.Uploads.Remove(ctx, key)
+		}
+	}
+
+	for _, s := range staged {
+		key := uploads.NewKey("products")
+		keys = append(keys, key)
+		if err := a.Uploads.Commit(r.Context(), s, key); err != nil {
+			log.Printf("Error saving image for product %d: %v", product.ID, err)
+			removeAll()
+			a.renderProductImages(w, r, product, "Error saving images")
+			return
+		}
+	}
+
+	now := time.Now()
+	err = a.Store.WithTx(r.Context(), func(tx *db.Store) error {
+		// Counted again under the product's lock, other uploads may have
+		// landed since
+		count, err := tx.ProductImages.CountForUpdate(r.Context(), product.ID)
+		if err != nil {
+			return err
+		}
+		if count+len(keys) > maxProductImages {
+			return errTooManyImages
+		}
+
+		for i, key := range keys {
+			img := db.ProductImage{
+				ProductID: product.ID,
+				Key:       key,
+				Position:  count + i,
+				// The first image a product gets is its cover
+				IsCover:   count == 0 && i == 0,
+				CreatedAt: now,
+			}
+			if err := tx.ProductImages.Set(r.Context(), &img); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errTooManyImages) {
+		removeAll()
+		a.renderProductImages(w, r, product, tooMany)
+		return
+	}
+	if err != nil {
+		log.Printf("Error saving images of product %d: %v", product.ID, err)
+		removeAll()
+		a.renderProductImages(w, r, product, "Error saving images")
+		return
+	}
+
+	a.renderProductImages(w, r, product, "")
+}
+
+// The form's order lists the IDs of all the product's images, comma
+// separated, in their new order
+func (a *API) reorderProductImages(w http.ResponseWriter, r *http.Request, product *db.Product) {
+	var ids []int
+	for _, idVal := range strings.Split(r.FormValue("order"), ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(idVal))
+		if err != nil {
+			http.Error(w, "Bad image order", http.StatusBadRequest)
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	// Every position changes or none does, and the product stays locked
+	// against uploads between checking the order and applying it
+	err := a.Store.WithTx(r.Context(), func(tx *db.Store) error {
+		if _, err := tx.ProductImages.CountForUpdate(r.Context(), product.ID); err != nil {
+			return err
+		}
+		images, err := tx.ProductImages.GetByProductID(r.Context(), product.ID)
+		if err != nil {
+			return err
+		}
+
+		current := make([]int, 0, len(images))
+		for _, img := range images {
+			current = append(current, img.ID)
+		}
+		sorted := slices.Clone(ids)
+		slices.Sort(sorted)
+		slices.Sort(current)
+		if !slices.Equal(sorted, current) {
+			return errIncompleteOrder
+		}
+
+		return tx.ProductImages.Reorder(r.Context(), product.ID, ids)
+	})
+	if errors.Is(err, errIncompleteOrder) {
+		http.Error(w, "Order must list every image of the product once", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error reordering images", http.StatusInternalServerError)
+		return
+	}
+
+	a.renderProductImages(w, r, product, "")
+}
+
+func (a *API) deleteProductImage(w http.ResponseWriter, r *http.Request, product *db.Product, img *db.ProductImage) {
+	err := a.Store.WithTx(r.Context(), func(tx *db.Store) error {
+		if err := tx.ProductImages.Delete(r.Context(), img); err != nil {
+			return err
+		}
+		if !img.IsCover {
+			return nil
+		}
+
+		// The next image in line takes over as cover
+		remaining, err := tx.ProductImages.GetByProductID(r.Context(), product.ID)
+		if err != nil || len(remaining) == 0 {
+			return err
+		}
+		return tx.ProductImages.SetCover(r.Context(), &remaining[0])
+	})
+	if err != nil {
+		http.Error(w, "Error deleting image", http.StatusInternalServerError)
+		return
+	}
+
+	if err := a.Uploads.Remove(r.Context(), img.Key); err != nil {
+		log.Printf("Error removing image %d of product %d: %v", img.ID, product.ID, err)
+	}
+
+	a.renderProductImages(w, r, product, "")
+}
+
+func (a *API) setProductCover(w http.ResponseWriter, r *http.Request, product *db.Product, img *db.ProductImage) {
+	if err := a.Store.ProductImages.SetCover(r.Context(), img); err != nil {
+		http.Error(w, "Error setting cover", http.StatusInternalServerError)
+		return
+	}
+
+	a.renderProductImages(w, r, product, "")
+}
+
+// Remove the stored files of images whose rows are gone, like when their
+// product is deleted
+func (a *API) removeProductImages(ctx context.Context, images []db.ProductImage) {
+	var errs []error
+	for _, img := range images {
+		errs = append(errs, a.Uploads.Remove(ctx, img.Key))
+	}
+	if err := errors.Join(errs...); err != nil {
+		log.Printf("Error removing product images: %v", err)
+	}
+}
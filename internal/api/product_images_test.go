@@ -0,0 +1,178 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/calmestend/mercado_lobito/internal/db"
+)
+
+// Multipart body with business_id and one "file" part per entry of files
+func multipartBody(t *testing.T, businessID int, files ...[]byte) (*bytes.Buffer, string) {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("business_id", strconv.Itoa(businessID))
+	for i, data := range files {
+		part, err := mw.CreateFormFile("file", fmt.Sprintf("%d.jpg", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(data)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &body, mw.FormDataContentType()
+}
+
+func testJPEG(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 100, 100)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Send a request as u through the same middleware the router uses for
+// /api/products/
+func (f *fixture) doImages(t *testing.T, u *db.User, req *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+
+	session, err := f.api.Auth.CreateSession(httptest.NewRequest(http.MethodGet, "/", nil), u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: "session", Value: session.UUID})
+
+	h := f.api.LimitProductImageUploads(f.api.Auth.AuthMiddleware(f.api.Auth.RequireBusinessMember(f.api.ProductImages)))
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+func (f *fixture) upload(t *testing.T, files ...[]byte) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body, contentType := multipartBody(t, f.business.ID, files...)
+	req := httptest.NewRequest(http.MethodPost, "/api/products/"+strconv.Itoa(f.product.ID)+"/images", body)
+	req.Header.Set("Content-Type", contentType)
+	return f.doImages(t, f.manager, req)
+}
+
+func (f *fixture) images(t *testing.T) []db.ProductImage {
+	t.Helper()
+
+	images, err := f.api.Store.ProductImages.GetByProductID(context.Background(), f.product.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return images
+}
+
+func TestUploadProductImages(t *testing.T) {
+	f := newFixture(t)
+
+	if rec := f.upload(t, testJPEG(t), testJPEG(t)); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	images := f.images(t)
+	if len(images) != 2 {
+		t.Fatalf("%d images, want 2", len(images))
+	}
+	if !images[0].IsCover || images[1].IsCover {
+		t.Errorf("cover flags %v, %v, want only the first", images[0].IsCover, images[1].IsCover)
+	}
+}
+
+func TestUploadProductImagesLimit(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+
+	for i := range maxProductImages - 1 {
+		img := db.ProductImage{ProductID: f.product.ID, Key: "products/" + strconv.Itoa(i), Position: i}
+		if err := f.api.Store.ProductImages.Set(ctx, &img); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if rec := f.upload(t, testJPEG(t), testJPEG(t)); !strings.Contains(rec.Body.String(), "up to") {
+		t.Errorf("upload over the limit answered %d: %s", rec.Code, rec.Body)
+	}
+
+	// Uploads that each fit on their own only get one slot between them,
+	// whichever inserts first
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.upload(t, testJPEG(t))
+		}()
+	}
+	wg.Wait()
+
+	if n := len(f.images(t)); n != maxProductImages {
+		t.Errorf("%d images, want %d", n, maxProductImages)
+	}
+}
+
+func TestUploadProductImagesBodyCap(t *testing.T) {
+	f := newFixture(t)
+	f.api.Uploads.Limits.MaxBytes = 1 << 10
+
+	// Well over maxProductImages files of MaxBytes with room for the form
+	big := make([]byte, 2<<20)
+	rand.Read(big)
+
+	if rec := f.upload(t, big); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+	if n := len(f.images(t)); n != 0 {
+		t.Errorf("%d images stored", n)
+	}
+}
+
+func TestReorderProductImages(t *testing.T) {
+	f := newFixture(t)
+	f.upload(t, testJPEG(t), testJPEG(t), testJPEG(t))
+	images := f.images(t)
+
+	reorder := func(order string) int {
+		form := url.Values{"business_id": {strconv.Itoa(f.business.ID)}, "order": {order}}
+		req := httptest.NewRequest(http.MethodPatch, "/api/products/"+strconv.Itoa(f.product.ID)+"/images", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return f.doImages(t, f.manager, req).Code
+	}
+	id := func(i int) string { return strconv.Itoa(images[i].ID) }
+
+	if code := reorder(id(2) + "," + id(0)); code != http.StatusBadRequest {
+		t.Errorf("incomplete order: status = %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := reorder(id(2) + "," + id(0) + "," + id(1)); code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+
+	got := f.images(t)
+	for i, want := range []int{images[2].ID, images[0].ID, images[1].ID} {
+		if got[i].ID != want || got[i].Position != i {
+			t.Errorf("position %d holds image %d at %d, want %d", i, got[i].ID, got[i].Position, want)
+		}
+	}
+}
@@ -7,90 +7,111 @@ import (
 
 	"github.com/calmestend/mercado_lobito/internal/auth"
 	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/calmestend/mercado_lobito/internal/uploads"
 	"github.com/calmestend/mercado_lobito/internal/views"
 )
 
-func Products(w http.ResponseWriter, r *http.Request) {
+func (a *API) Products(w http.ResponseWriter, r *http.Request) {
 	if strings.HasPrefix(r.URL.Path, "/api/products/edit/") {
-		editProduct(w, r)
+		a.editProduct(w, r)
 		return
 	}
 	if strings.HasPrefix(r.URL.Path, "/api/products/cancel/") {
-		cancelEditProduct(w, r)
+		a.cancelEditProduct(w, r)
 		return
 	}
 
 	switch r.Method {
 	case http.MethodGet:
-		getAllProductsByBusiness(w, r)
+		a.getAllProductsByBusiness(w, r)
 	case http.MethodPost:
-		createProduct(w, r)
+		a.createProduct(w, r)
 	case http.MethodPatch:
-		updateProduct(w, r)
+		a.updateProduct(w, r)
 	case http.MethodDelete:
-		deleteProduct(w, r)
+		a.deleteProduct(w, r)
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
-func getAllProductsByBusiness(w http.ResponseWriter, r *http.Request) {
-	dbConn := db.Init()
-	defer dbConn.Close()
-
-	sess, err := auth.GetSessionFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+func (a *API) getAllProductsByBusiness(w http.ResponseWriter, r *http.Request) {
+	m, ok := a.business(w, r, db.PermissionReadOnly)
+	if !ok {
 		return
 	}
 
-	student := db.Student{UserID: sess.UserID}
-	if err := student.GetByUserID(dbConn); err != nil {
-		http.Error(w, "Student not found", http.StatusNotFound)
-		return
-	}
+	a.renderProducts(w, r, m)
+}
 
-	business := db.Business{OwnerID: student.ID}
-	if err := business.GetByOwnerID(dbConn); err != nil {
-		http.Error(w, "Business not found", http.StatusNotFound)
+func (a *API) renderProducts(w http.ResponseWriter, r *http.Request, m *auth.Membership) {
+	products, err := a.Store.Businesses.GetProductsByOwnerID(r.Context(), &m.Business)
+	if err != nil {
+		http.Error(w, "Error retrieving products", http.StatusInternalServerError)
 		return
 	}
 
-	products, err := business.GetProductsByOwnerID(dbConn)
+	images, err := a.Store.ProductImages.GetByBusinessID(r.Context(), m.Business.ID)
 	if err != nil {
-		http.Error(w, "Error retrieving products", http.StatusInternalServerError)
+		http.Error(w, "Error retrieving product images", http.StatusInternalServerError)
 		return
 	}
+	covers := map[int]string{}
+	for _, img := range images {
+		if img.IsCover {
+			covers[img.ProductID] = a.Uploads.URL(img.Key, uploads.ThumbnailSmall)
+		}
+	}
 
 	w.Header().Set("Content-Type", "text/html")
-	component := views.ProductsTable(products)
+	component := views.ProductsTable(products, covers, m.Business.ID, m.Permission.Allows(db.PermissionManageProducts))
 	component.Render(r.Context(), w)
 }
 
-func createProduct(w http.ResponseWriter, r *http.Request) {
-	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Error parsing form", http.StatusBadRequest)
-		return
+// Product from the path of /api/products/{edit,cancel}/{id}, only when it
+// belongs to a business the principal can manage products in
+func (a *API) pathProduct(w http.ResponseWriter, r *http.Request) (*db.Product, bool) {
+	pathParts := strings.Split(r.URL.Path, "/")
+	if len(pathParts) < 5 {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return nil, false
 	}
 
-	dbConn := db.Init()
-	defer dbConn.Close()
+	return a.managedProduct(w, r, pathParts[4])
+}
 
-	sess, err := auth.GetSessionFromRequest(r)
+// Product with the ID in idStr, only when it belongs to a business the
+// principal can manage products in
+func (a *API) managedProduct(w http.ResponseWriter, r *http.Request, idStr string) (*db.Product, bool) {
+	idInt, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return nil, false
 	}
 
-	student := db.Student{UserID: sess.UserID}
-	if err := student.GetByUserID(dbConn); err != nil {
-		http.Error(w, "Student not found", http.StatusNotFound)
+	m, ok := a.business(w, r, db.PermissionManageProducts)
+	if !ok {
+		return nil, false
+	}
+
+	product := db.Product{ID: idInt}
+	err = a.Store.Products.GetByID(r.Context(), &product)
+	if err != nil || product.BusinessID != m.Business.ID {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return &product, true
+}
+
+func (a *API) createProduct(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
 		return
 	}
 
-	business := db.Business{OwnerID: student.ID}
-	if err := business.GetByOwnerID(dbConn); err != nil {
-		http.Error(w, "Business not found", http.StatusNotFound)
+	m, ok := a.business(w, r, db.PermissionManageProducts)
+	if !ok {
 		return
 	}
 
@@ -114,97 +135,60 @@ func createProduct(w http.ResponseWriter, r *http.Request) {
 		Title:      title,
 		Price:      priceFloat,
 		Stock:      stockInt,
-		BusinessID: business.ID,
+		BusinessID: m.Business.ID,
 	}
 
-	err = product.Set(dbConn)
+	err = a.Store.Products.Set(r.Context(), &product)
 	if err != nil {
 		http.Error(w, "Error creating product", http.StatusInternalServerError)
 		return
 	}
 
-	products, err := business.GetProductsByOwnerID(dbConn)
-	if err != nil {
-		http.Error(w, "Error retrieving products", http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "text/html")
-	component := views.ProductsTable(products)
-	component.Render(r.Context(), w)
+	a.renderProducts(w, r, m)
 }
 
-func editProduct(w http.ResponseWriter, r *http.Request) {
-	pathParts := strings.Split(r.URL.Path, "/")
-	if len(pathParts) < 5 {
-		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+func (a *API) editProduct(w http.ResponseWriter, r *http.Request) {
+	product, ok := a.pathProduct(w, r)
+	if !ok {
 		return
 	}
 
-	idStr := pathParts[4]
-	idInt, err := strconv.Atoi(idStr)
+	images, err := a.Store.ProductImages.GetByProductID(r.Context(), product.ID)
 	if err != nil {
-		http.Error(w, "Invalid product ID", http.StatusBadRequest)
-		return
-	}
-
-	dbConn := db.Init()
-	defer dbConn.Close()
-
-	product := db.Product{ID: idInt}
-	err = product.GetByID(dbConn)
-	if err != nil {
-		http.Error(w, "Product not found", http.StatusNotFound)
+		http.Error(w, "Error retrieving product images", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "text/html")
-	component := views.ProductEditRow(product)
+	component := views.ProductEditRow(*product, a.productImageThumbs(images))
 	component.Render(r.Context(), w)
 }
 
-func cancelEditProduct(w http.ResponseWriter, r *http.Request) {
-	pathParts := strings.Split(r.URL.Path, "/")
-	if len(pathParts) < 5 {
-		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+func (a *API) cancelEditProduct(w http.ResponseWriter, r *http.Request) {
+	product, ok := a.pathProduct(w, r)
+	if !ok {
 		return
 	}
 
-	idStr := pathParts[4]
-	idInt, err := strconv.Atoi(idStr)
+	coverSrc, err := a.productCover(r.Context(), product.ID)
 	if err != nil {
-		http.Error(w, "Invalid product ID", http.StatusBadRequest)
-		return
-	}
-
-	dbConn := db.Init()
-	defer dbConn.Close()
-
-	product := db.Product{ID: idInt}
-	err = product.GetByID(dbConn)
-	if err != nil {
-		http.Error(w, "Product not found", http.StatusNotFound)
+		http.Error(w, "Error retrieving product images", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "text/html")
-	component := views.ProductRow(product)
+	component := views.ProductRow(*product, coverSrc)
 	component.Render(r.Context(), w)
 }
 
-func updateProduct(w http.ResponseWriter, r *http.Request) {
+func (a *API) updateProduct(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Error parsing form", http.StatusBadRequest)
 		return
 	}
 
-	dbConn := db.Init()
-	defer dbConn.Close()
-
-	// Get session to verify ownership
-	sess, err := auth.GetSessionFromRequest(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	m, ok := a.business(w, r, db.PermissionManageProducts)
+	if !ok {
 		return
 	}
 
@@ -231,25 +215,13 @@ func updateProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	student := db.Student{UserID: sess.UserID}
-	if err := student.GetByUserID(dbConn); err != nil {
-		http.Error(w, "Student not found", http.StatusNotFound)
-		return
-	}
-
-	business := db.Business{OwnerID: student.ID}
-	if err := business.GetByOwnerID(dbConn); err != nil {
-		http.Error(w, "Business not found", http.StatusNotFound)
-		return
-	}
-
 	existingProduct := db.Product{ID: idInt}
-	if err := existingProduct.GetByID(dbConn); err != nil {
+	if err := a.Store.Products.GetByID(r.Context(), &existingProduct); err != nil {
 		http.Error(w, "Product not found", http.StatusNotFound)
 		return
 	}
 
-	if existingProduct.BusinessID != business.ID {
+	if existingProduct.BusinessID != m.Business.ID {
 		http.Error(w, "Unauthorized to edit this product", http.StatusForbidden)
 		return
 	}
@@ -259,28 +231,36 @@ func updateProduct(w http.ResponseWriter, r *http.Request) {
 		Title:      title,
 		Price:      priceFloat,
 		Stock:      stockInt,
-		BusinessID: business.ID,
+		BusinessID: m.Business.ID,
 	}
 
-	err = product.Update(dbConn)
+	err = a.Store.Products.Update(r.Context(), &product)
 	if err != nil {
 		http.Error(w, "Error updating product", http.StatusInternalServerError)
 		return
 	}
 
+	coverSrc, err := a.productCover(r.Context(), product.ID)
+	if err != nil {
+		http.Error(w, "Error retrieving product images", http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/html")
-	component := views.ProductRow(product)
+	component := views.ProductRow(product, coverSrc)
 	component.Render(r.Context(), w)
 }
 
-func deleteProduct(w http.ResponseWriter, r *http.Request) {
+func (a *API) deleteProduct(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Error parsing form", http.StatusBadRequest)
 		return
 	}
 
-	dbConn := db.Init()
-	defer dbConn.Close()
+	m, ok := a.business(w, r, db.PermissionManageProducts)
+	if !ok {
+		return
+	}
 
 	id := r.FormValue("id")
 
@@ -291,11 +271,29 @@ func deleteProduct(w http.ResponseWriter, r *http.Request) {
 	}
 
 	product := db.Product{ID: idInt}
-	err = product.Delete(dbConn)
+	if err := a.Store.Products.GetByID(r.Context(), &product); err != nil {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+
+	if product.BusinessID != m.Business.ID {
+		http.Error(w, "Unauthorized to delete this product", http.StatusForbidden)
+		return
+	}
+
+	// Deleting the product cascades to its image rows, not to the files
+	images, err := a.Store.ProductImages.GetByProductID(r.Context(), product.ID)
+	if err != nil {
+		http.Error(w, "Error retrieving product images", http.StatusInternalServerError)
+		return
+	}
+
+	err = a.Store.Products.Delete(r.Context(), &product)
 	if err != nil {
 		http.Error(w, "Error deleting product", http.StatusInternalServerError)
 		return
 	}
+	a.removeProductImages(r.Context(), images)
 
 	w.Header().Set("Content-Type", "text/html")
 	component := views.ProductDeleted()
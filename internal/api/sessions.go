@@ -0,0 +1,83 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/calmestend/mercado_lobito/internal/auth"
+	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/calmestend/mercado_lobito/internal/views"
+)
+
+func (a *API) ProfileSessions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.renderSessions(w, r, "")
+	case http.MethodDelete:
+		a.revokeSessions(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *API) renderSessions(w http.ResponseWriter, r *http.Request, message string) {
+	p, _ := auth.PrincipalFromContext(r.Context())
+
+	sessions, err := a.Auth.ListSessions(r.Context(), p.UserID)
+	if err != nil {
+		http.Error(w, "Error retrieving sessions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	views.SessionsList(sessions, p.Session.ID, message).Render(r.Context(), w)
+}
+
+// Revoke one session by session_id, or every other one with others=true
+func (a *API) revokeSessions(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad form", http.StatusBadRequest)
+		return
+	}
+
+	p, _ := auth.PrincipalFromContext(r.Context())
+
+	if r.FormValue("others") == "true" {
+		deleted, err := a.Store.Sessions.DeleteOthers(r.Context(), p.UserID, p.Session.UUID)
+		if err != nil {
+			http.Error(w, "Error revoking sessions", http.StatusInternalServerError)
+			return
+		}
+
+		a.renderSessions(w, r, "Se cerraron "+strconv.FormatInt(deleted, 10)+" sesiones")
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("session_id"))
+	if err != nil {
+		http.Error(w, "Bad session ID", http.StatusBadRequest)
+		return
+	}
+
+	session := db.Session{ID: id, UserID: p.UserID}
+	err = a.Store.Sessions.DeleteForUser(r.Context(), &session)
+	if errors.Is(err, db.ErrSessionNotFound) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error revoking session", http.StatusInternalServerError)
+		return
+	}
+
+	// Revoking this device is the same as logging out
+	if id == p.Session.ID {
+		http.SetCookie(w, a.Auth.Cookies.Expired("session"))
+		w.Header().Set("HX-Redirect", "/auth/login")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	a.renderSessions(w, r, "Sesión cerrada")
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/calmestend/mercado_lobito/internal/db"
+)
+
+func (f *fixture) newSession(t *testing.T, u *db.User) *db.Session {
+	t.Helper()
+
+	session, err := f.api.Auth.CreateSession(httptest.NewRequest(http.MethodGet, "/", nil), u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+// Send a request to /api/profile/sessions from the device holding session
+func (f *fixture) sessions(session *db.Session, method, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/api/profile/sessions?"+query, nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: session.UUID})
+
+	rec := httptest.NewRecorder()
+	f.api.Auth.AuthMiddleware(f.api.ProfileSessions)(rec, req)
+	return rec
+}
+
+// Whether session still signs its user in
+func (f *fixture) sessionValid(t *testing.T, session *db.Session) bool {
+	t.Helper()
+
+	_, err := f.api.Auth.GetSession(context.Background(), session.UUID)
+	return err == nil
+}
+
+func TestRevokeSession(t *testing.T) {
+	f := newFixture(t)
+	current := f.newSession(t, f.owner)
+	laptop := f.newSession(t, f.owner)
+
+	rec := f.sessions(current, http.MethodDelete, "session_id="+strconv.Itoa(laptop.ID))
+	if rec.Code != http.StatusOK || rec.Header().Get("HX-Redirect") != "" {
+		t.Fatalf("revoking another device: %d %q", rec.Code, rec.Header().Get("HX-Redirect"))
+	}
+	if f.sessionValid(t, laptop) {
+		t.Error("revoked session still valid")
+	}
+	if !f.sessionValid(t, current) {
+		t.Error("revoking another device ended this one")
+	}
+}
+
+func TestRevokeOtherUsersSession(t *testing.T) {
+	f := newFixture(t)
+	victim := f.newSession(t, f.owner)
+	attacker := f.newSession(t, f.outsider)
+
+	if rec := f.sessions(attacker, http.MethodDelete, "session_id="+strconv.Itoa(victim.ID)); rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if !f.sessionValid(t, victim) {
+		t.Fatal("another user's session was revoked")
+	}
+
+	// Nor does revoking every other session reach past the user's own
+	if rec := f.sessions(attacker, http.MethodDelete, "others=true"); rec.Code != http.StatusOK {
+		t.Fatalf("revoking others: status = %d", rec.Code)
+	}
+	if !f.sessionValid(t, victim) {
+		t.Error("revoking others ended another user's session")
+	}
+}
+
+func TestRevokeCurrentSession(t *testing.T) {
+	f := newFixture(t)
+	current := f.newSession(t, f.owner)
+
+	rec := f.sessions(current, http.MethodDelete, "session_id="+strconv.Itoa(current.ID))
+	if rec.Header().Get("HX-Redirect") != "/auth/login" {
+		t.Fatalf("not sent to the login: %d %q", rec.Code, rec.Header().Get("HX-Redirect"))
+	}
+	cleared := false
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "session" && c.MaxAge < 0 {
+			cleared = true
+		}
+	}
+	if !cleared {
+		t.Error("session cookie not cleared")
+	}
+
+	if f.sessionValid(t, current) {
+		t.Fatal("current session still valid")
+	}
+	if rec := f.sessions(current, http.MethodGet, ""); rec.Code != http.StatusFound || rec.Header().Get("Location") != "/auth/login" {
+		t.Errorf("old cookie still signs in: %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+}
+
+func TestRevokeOtherSessions(t *testing.T) {
+	f := newFixture(t)
+	current := f.newSession(t, f.owner)
+	phone := f.newSession(t, f.owner)
+	laptop := f.newSession(t, f.owner)
+
+	if rec := f.sessions(current, http.MethodDelete, "others=true"); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	for name, session := range map[string]*db.Session{"phone": phone, "laptop": laptop} {
+		if f.sessionValid(t, session) {
+			t.Errorf("%s session still valid", name)
+		}
+	}
+	if !f.sessionValid(t, current) {
+		t.Error("current session revoked")
+	}
+}
@@ -0,0 +1,44 @@
+package app
+
+import (
+	"log"
+	"strings"
+
+	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/calmestend/mercado_lobito/internal/mail"
+	"github.com/calmestend/mercado_lobito/internal/uploads"
+	"github.com/calmestend/mercado_lobito/pkg/env"
+	"github.com/calmestend/mercado_lobito/pkg/signing"
+)
+
+// Dependencies shared by every handler, built once at startup
+type App struct {
+	Store  *db.Store
+	Mailer mail.Mailer
+	// Validates and stores user images
+	Uploads *uploads.Service
+	// Signs the tokens put in links sent by email
+	Signer *signing.Signer
+	// Where the app is reachable from outside, used to build those links
+	BaseURL string
+}
+
+func New(store *db.Store) *App {
+	signer := signerFromEnv()
+	return &App{
+		Store:   store,
+		Mailer:  mail.FromEnv(),
+		Uploads: uploads.FromEnv(signer),
+		Signer:  signer,
+		BaseURL: strings.TrimSuffix(env.GetEnvDefault("APP_URL", "http://localhost:3030"), "/"),
+	}
+}
+
+func signerFromEnv() *signing.Signer {
+	secret := env.GetEnvDefault("APP_SECRET", "")
+	if secret == "" {
+		log.Print("APP_SECRET is not set, emailed links stop working on restart")
+		return signing.NewRandom()
+	}
+	return signing.New([]byte(secret))
+}
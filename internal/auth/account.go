@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/internal/db"
+)
+
+var (
+	ErrInvalidNewOwner = errors.New("new owner must be a student collaborator without a business of their own")
+	// The account has no password and its session is older than ReauthMaxAge
+	ErrSignInAgain = errors.New("sign in again to confirm")
+)
+
+// Collaborators of the business who can take it over from its owner. Owners
+// are students and a student owns at most one business.
+func (a *Auth) TransferCandidates(ctx context.Context, b *db.Business) ([]db.Collaborator, error) {
+	collaborators, err := a.Store.Businesses.GetCollaboratorsByBusinessID(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []db.Collaborator
+	for _, c := range collaborators {
+		studentID, err := a.ownerStudentID(ctx, a.Store, c.ID)
+		if err != nil {
+			return nil, err
+		}
+		if studentID != "" {
+			candidates = append(candidates, c)
+		}
+	}
+	return candidates, nil
+}
+
+// Student ID of the user when they may own a business, empty otherwise
+func (a *Auth) ownerStudentID(ctx context.Context, store *db.Store, userID int) (string, error) {
+	s := db.Student{UserID: userID}
+	err := store.Students.GetByUserID(ctx, &s)
+	if errors.Is(err, db.ErrStudentNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	owned := db.Business{OwnerID: s.ID}
+	err = store.Businesses.GetByOwnerID(ctx, &owned)
+	if err == nil {
+		return "", nil
+	}
+	if !errors.Is(err, db.ErrBusinessNotFound) {
+		return "", err
+	}
+	return s.ID, nil
+}
+
+// Confirm it's u asking for a change to their account with their password.
+// Accounts that only sign in through the identity provider have none, they
+// must have signed in within ReauthMaxAge on the session making r instead,
+// ErrSignInAgain otherwise. Failed passwords count towards the sign in
+// lockout, a positive wait means the attempt wasn't allowed.
+func (a *Auth) confirmIdentity(r *http.Request, u *db.User, password string) (bool, time.Duration, bool, error) {
+	if u.Hash != "" {
+		return a.checkLogin(r, u, u.Email, password)
+	}
+
+	session, err := a.GetSessionFromRequest(r)
+	if err != nil || session.UserID != u.ID || a.now().Sub(session.CreatedAt) > a.ReauthMaxAge {
+		return false, 0, false, ErrSignInAgain
+	}
+	return true, 0, false, nil
+}
+
+// Confirm a change to u's account like confirmIdentity, along with a current
+// code when they use two-factor
+func (a *Auth) Reauthenticate(r *http.Request, u *db.User, password, code string) (bool, time.Duration, bool, error) {
+	ok, wait, locked, err := a.confirmIdentity(r, u, password)
+	if err != nil || wait > 0 || !ok {
+		return false, wait, locked, err
+	}
+
+	t, err := a.enabledTOTP(r.Context(), u.ID)
+	if err != nil {
+		return false, 0, false, err
+	}
+	if t == nil {
+		return true, 0, false, nil
+	}
+	return a.checkCode(r, u, t, code)
+}
+
+// Delete the user and everything that cascades from them. When they own a
+// business and newOwnerID isn't zero, that collaborator takes it over first,
+// otherwise the business goes with the account.
+func (a *Auth) DeleteAccount(ctx context.Context, p *Principal, newOwnerID int) error {
+	u := db.User{ID: p.UserID}
+	if err := a.Store.Users.GetByID(ctx, &u); err != nil {
+		return err
+	}
+
+	var productImages []db.ProductImage
+	err := a.Store.WithTx(ctx, func(tx *db.Store) error {
+		// A business going with the account takes its product images along,
+		// their files are removed once it's gone. Its products stay locked
+		// so no upload lands in between.
+		if p.OwnedBusinessID != 0 && newOwnerID == 0 {
+			var err error
+			productImages, err = tx.ProductImages.GetByBusinessIDForUpdate(ctx, p.OwnedBusinessID)
+			if err != nil {
+				return err
+			}
+		}
+
+		if p.OwnedBusinessID != 0 && newOwnerID != 0 {
+			membership := db.BusinessCollaborator{BusinessID: p.OwnedBusinessID, CollaboratorID: newOwnerID}
+			err := tx.BusinessCollaborators.GetByBusinessAndCollaborator(ctx, &membership)
+			if errors.Is(err, db.ErrCollaboratorNotFound) {
+				return ErrInvalidNewOwner
+			}
+			if err != nil {
+				return err
+			}
+
+			studentID, err := a.ownerStudentID(ctx, tx, newOwnerID)
+			if err != nil {
+				return err
+			}
+			if studentID == "" {
+				return ErrInvalidNewOwner
+			}
+
+			business := db.Business{ID: p.OwnedBusinessID, OwnerID: studentID}
+			if err := tx.Businesses.TransferOwnership(ctx, &business); err != nil {
+				return err
+			}
+			// Owners aren't listed among their own collaborators
+			if err := tx.BusinessCollaborators.Delete(ctx, &membership); err != nil {
+				return err
+			}
+		}
+
+		return tx.Users.Delete(ctx, &u)
+	})
+	if err != nil {
+		return err
+	}
+
+	if u.PhotoKey != "" {
+		if err := a.Uploads.Remove(ctx, u.PhotoKey); err != nil {
+			log.Printf("Error removing profile photo of deleted user %d: %v", u.ID, err)
+		}
+	}
+	for _, img := range productImages {
+		if err := a.Uploads.Remove(ctx, img.Key); err != nil {
+			log.Printf("Error removing image %d of deleted user %d: %v", img.ID, u.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/internal/db"
+)
+
+// Makes leaked tokens easy to spot in logs and by secret scanners
+const apiTokenPrefix = "mlpat_"
+
+var ErrInvalidAPIToken = errors.New("invalid or expired api token")
+
+type tokenScopesContextKey struct{}
+
+// Scopes a route asks of API tokens
+type tokenScopes struct {
+	read  db.Scope
+	write db.Scope
+}
+
+// Let API tokens through AuthMiddleware on this route when they carry read
+// for GET and HEAD or write for anything else. Routes not wrapped in it only
+// take the session cookie.
+func (a *Auth) AllowTokens(read, write db.Scope) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), tokenScopesContextKey{}, tokenScopes{read: read, write: write})
+			next(w, r.WithContext(ctx))
+		}
+	}
+}
+
+// Create a token for the user. The returned string is the only copy, just
+// its hash is stored.
+func (a *Auth) CreateAPIToken(ctx context.Context, userID int, name string, scopes []db.Scope, expiresAt *time.Time) (string, *db.APIToken, error) {
+	token, _ := newOpaqueToken()
+	token = apiTokenPrefix + token
+
+	t := db.APIToken{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: hashToken(token),
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+	if err := a.Store.APITokens.Set(ctx, &t); err != nil {
+		return "", nil, err
+	}
+	return token, &t, nil
+}
+
+// Token from an Authorization: Bearer header
+func bearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	token = strings.TrimSpace(token)
+	return token, ok && token != ""
+}
+
+func (a *Auth) apiToken(ctx context.Context, token string) (*db.APIToken, error) {
+	t := db.APIToken{TokenHash: hashToken(token)}
+	if err := a.Store.APITokens.GetByHash(ctx, &t); err != nil {
+		if errors.Is(err, db.ErrAPITokenNotFound) {
+			return nil, ErrInvalidAPIToken
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if t.ExpiresAt != nil && !now.Before(*t.ExpiresAt) {
+		return nil, ErrInvalidAPIToken
+	}
+
+	// Like sessions, only write down the last use once per RenewInterval
+	if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) >= a.Sessions.RenewInterval {
+		t.LastUsedAt = &now
+		if err := a.Store.APITokens.Touch(ctx, &t); err != nil {
+			log.Printf("Error %s when recording api token use", err)
+		}
+	}
+
+	return &t, nil
+}
+
+// AuthMiddleware for requests carrying a bearer token. The session cookie is
+// ignored for them.
+func (a *Auth) tokenMiddleware(w http.ResponseWriter, r *http.Request, token string, next http.HandlerFunc) {
+	scopes, ok := r.Context().Value(tokenScopesContextKey{}).(tokenScopes)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_request"`)
+		http.Error(w, "API tokens can't be used here", http.StatusUnauthorized)
+		return
+	}
+
+	t, err := a.apiToken(r.Context(), token)
+	if errors.Is(err, ErrInvalidAPIToken) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error loading token", http.StatusInternalServerError)
+		return
+	}
+
+	required := scopes.write
+	if isSafeMethod(r.Method) {
+		required = scopes.read
+	}
+	if !t.HasScope(required) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+string(required)+`"`)
+		http.Error(w, "Token is missing the "+string(required)+" scope", http.StatusForbidden)
+		return
+	}
+
+	principal, err := a.resolvePrincipal(r.Context(), t.UserID)
+	if err != nil {
+		http.Error(w, "Error loading account", http.StatusInternalServerError)
+		return
+	}
+	principal.Token = t
+
+	ctx := context.WithValue(r.Context(), principalContextKey{}, principal)
+	next(w, r.WithContext(ctx))
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/internal/db"
+)
+
+// Token route answering 204 to whatever gets past the middleware
+func tokenRoute(a *Auth) http.HandlerFunc {
+	return a.AllowTokens(db.ScopeProductsRead, db.ScopeProductsWrite)(a.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+func requestWithToken(h http.HandlerFunc, method, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/api/products", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+func TestAPITokenScopes(t *testing.T) {
+	a := newTestAuth(t)
+	u := newTestUser(t, a, "ana@example.com", "")
+	ctx := context.Background()
+
+	readOnly, _, err := a.CreateAPIToken(ctx, u.ID, "read", []db.Scope{db.ScopeProductsRead}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readWrite, _, err := a.CreateAPIToken(ctx, u.ID, "write", []db.Scope{db.ScopeProductsRead, db.ScopeProductsWrite}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	teamOnly, _, err := a.CreateAPIToken(ctx, u.ID, "team", []db.Scope{db.ScopeTeamRead, db.ScopeTeamWrite}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		want   int
+	}{
+		{"read only reads", readOnly, http.MethodGet, http.StatusNoContent},
+		{"read only heads", readOnly, http.MethodHead, http.StatusNoContent},
+		{"read only creates", readOnly, http.MethodPost, http.StatusForbidden},
+		{"read only updates", readOnly, http.MethodPatch, http.StatusForbidden},
+		{"read only deletes", readOnly, http.MethodDelete, http.StatusForbidden},
+		{"read write creates", readWrite, http.MethodPost, http.StatusNoContent},
+		{"other scopes read", teamOnly, http.MethodGet, http.StatusForbidden},
+		{"other scopes write", teamOnly, http.MethodPost, http.StatusForbidden},
+		{"unknown token", apiTokenPrefix + "nope", http.MethodGet, http.StatusUnauthorized},
+	}
+
+	h := tokenRoute(a)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := requestWithToken(h, tt.method, tt.token); rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+
+	// Routes that don't allow tokens turn even a fitting one away
+	sessionOnly := a.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	if rec := requestWithToken(sessionOnly, http.MethodGet, readWrite); rec.Code != http.StatusUnauthorized {
+		t.Errorf("session only route: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestAPITokenRevokedAndExpired(t *testing.T) {
+	a := newTestAuth(t)
+	u := newTestUser(t, a, "ana@example.com", "")
+	ctx := context.Background()
+	scopes := []db.Scope{db.ScopeProductsRead}
+	h := tokenRoute(a)
+
+	t.Run("revoked", func(t *testing.T) {
+		token, created, err := a.CreateAPIToken(ctx, u.ID, "script", scopes, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec := requestWithToken(h, http.MethodGet, token); rec.Code != http.StatusNoContent {
+			t.Fatalf("before revoking: status = %d", rec.Code)
+		}
+
+		if err := a.Store.APITokens.DeleteForUser(ctx, created); err != nil {
+			t.Fatal(err)
+		}
+		if rec := requestWithToken(h, http.MethodGet, token); rec.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		expiresAt := time.Now().Add(-time.Minute)
+		token, _, err := a.CreateAPIToken(ctx, u.ID, "script", scopes, &expiresAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec := requestWithToken(h, http.MethodGet, token); rec.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("password reset", func(t *testing.T) {
+		mailer := &fakeMailer{}
+		a.Mailer = mailer
+		token, _, err := a.CreateAPIToken(ctx, u.ID, "script", scopes, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := a.sendPasswordReset(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+		password := "a brand new secret"
+		rec := postForm(a.ResetPassword, "/auth/password/reset", url.Values{
+			"token": {resetToken(t, mailer.sent[0])}, "password": {password}, "confirm_password": {password},
+		})
+		if rec.Header().Get("HX-Redirect") != "/auth/login" {
+			t.Fatalf("reset failed: %d %s", rec.Code, rec.Body)
+		}
+
+		if rec := requestWithToken(h, http.MethodGet, token); rec.Code != http.StatusUnauthorized {
+			t.Errorf("token survived the reset: status = %d", rec.Code)
+		}
+	})
+}
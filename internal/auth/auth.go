@@ -1,73 +1,84 @@
 package auth
 
 import (
-	"database/sql"
+	"context"
 	"errors"
-	"io"
+	"log"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/calmestend/mercado_lobito/internal/app"
 	"github.com/calmestend/mercado_lobito/internal/components"
 	"github.com/calmestend/mercado_lobito/internal/db"
-	"github.com/google/uuid"
+	"github.com/calmestend/mercado_lobito/internal/uploads"
+	"github.com/calmestend/mercado_lobito/pkg/env"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var dbConn *sql.DB
-
-const uploadDir = "./uploads"
-
-func SetDBConnection(database *sql.DB) {
-	dbConn = database
+type Auth struct {
+	*app.App
+	Sessions SessionConfig
+	Cookies  CookiePolicy
+	Login    LoginPolicy
+	// Rules for new passwords and the bcrypt cost they're hashed at
+	Passwords PasswordPolicy
+	// How long an emailed invitation link stays valid
+	InvitationTTL time.Duration
+	// How long an emailed password reset link stays valid
+	PasswordResetTTL time.Duration
+	// How long an emailed verification link stays valid
+	EmailVerificationTTL time.Duration
+	// How long after the password step the two-factor code is accepted
+	TwoFactorTTL time.Duration
+	// How recently an account without a password must have signed in to
+	// confirm a sensitive change
+	ReauthMaxAge time.Duration
+
+	verificationResends *throttle
+	// Password reset requests for the same identifier, and from the same
+	// IP, which a whole campus may share behind NAT
+	passwordResetsByIdentifier *throttle
+	passwordResetsByIP         *throttle
+	// Nil unless OIDC_ISSUER is set
+	oidc *oidcClient
+	// Clock sessions are timed with, tests move it forward
+	now func() time.Time
 }
 
-func CreateSession(userID int) (string, error) {
-	uuid := uuid.NewString()
+func New(a *app.App) *Auth {
+	return &Auth{
+		App:       a,
+		Sessions:  SessionConfigFromEnv(),
+		Cookies:   CookiePolicyFromEnv(),
+		Login:     LoginPolicyFromEnv(),
+		Passwords: PasswordPolicyFromEnv(),
 
-	session := db.Session{
-		UUID:   uuid,
-		UserID: userID,
-	}
+		InvitationTTL:    env.GetDuration("INVITATION_TTL", 7*24*time.Hour),
+		PasswordResetTTL: env.GetDuration("PASSWORD_RESET_TTL", time.Hour),
 
-	err := session.Set(dbConn)
-	if err != nil {
-		return "", err
-	}
+		passwordResetsByIdentifier: newThrottle(1, env.GetDuration("PASSWORD_RESET_REQUEST_INTERVAL", time.Minute)),
+		passwordResetsByIP:         newThrottle(env.GetInt("PASSWORD_RESET_IP_LIMIT", 30), env.GetDuration("PASSWORD_RESET_IP_WINDOW", 10*time.Minute)),
 
-	return uuid, nil
-}
+		EmailVerificationTTL: env.GetDuration("EMAIL_VERIFICATION_TTL", 48*time.Hour),
+		verificationResends:  newThrottle(1, env.GetDuration("EMAIL_VERIFICATION_RESEND_INTERVAL", 2*time.Minute)),
 
-func GetSession(uuid string) (*db.Session, error) {
-	session := db.Session{
-		UUID: uuid,
-	}
+		TwoFactorTTL: env.GetDuration("TWO_FACTOR_TTL", 5*time.Minute),
+		ReauthMaxAge: env.GetDuration("REAUTH_MAX_AGE", 10*time.Minute),
 
-	err := session.Get(dbConn)
-	if err != nil {
-		return nil, errors.New("session not found")
+		oidc: newOIDCClient(OIDCConfigFromEnv(a.BaseURL)),
+		now:  time.Now,
 	}
-
-	return &session, nil
 }
 
-func DeleteSession(uuid string) error {
-	session := db.Session{
-		UUID: uuid,
-	}
-
-	return session.Delete(dbConn)
-}
-
-func IsAuthenticated(r *http.Request) bool {
+func (a *Auth) IsAuthenticated(r *http.Request) bool {
 	cookie, err := r.Cookie("session")
 	if err != nil {
 		return false
 	}
 
-	_, err = GetSession(cookie.Value)
+	_, err = a.GetSession(r.Context(), cookie.Value)
 	return err == nil
 }
 
@@ -76,12 +87,39 @@ func VerifyPassword(hashedPassword, password string) bool {
 	return err == nil
 }
 
+// User identified by a student ID or, when it looks like one, an email
+func (a *Auth) lookupUser(ctx context.Context, identifier string) (*db.User, error) {
+	u := db.User{}
+
+	if strings.Contains(identifier, "@") {
+		u.Email = identifier
+		if err := a.Store.Users.GetByEmail(ctx, &u); err != nil {
+			return nil, err
+		}
+		return &u, nil
+	}
+
+	s := db.Student{ID: identifier}
+	if err := a.Store.Students.GetByID(ctx, &s); err != nil {
+		if errors.Is(err, db.ErrStudentNotFound) {
+			return nil, db.ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	u.ID = s.UserID
+	if err := a.Store.Users.GetByID(ctx, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
 type Credentials struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
 
-func Signin(w http.ResponseWriter, r *http.Request) {
+func (a *Auth) Signin(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
@@ -94,73 +132,91 @@ func Signin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	studentID := r.FormValue("student_id")
+	identifier := strings.TrimSpace(r.FormValue("identifier"))
 	password := r.FormValue("password")
 
-	if studentID == "" || password == "" {
-		component := components.LoginResponse(false, "Student ID and password are required")
+	if identifier == "" || password == "" {
+		component := components.LoginResponse(false, "Student ID or email and password are required")
 		component.Render(r.Context(), w)
 		return
 	}
 
-	s := db.Student{
-		ID: studentID,
+	userID := 0
+	u, err := a.lookupUser(r.Context(), identifier)
+	if err == nil {
+		userID = u.ID
+	} else if !errors.Is(err, db.ErrUserNotFound) {
+		component := components.LoginResponse(false, "Error signing in")
+		component.Render(r.Context(), w)
+		return
 	}
 
-	err = s.GetByID(dbConn)
+	ok, wait, locked, err := a.checkLogin(r, u, identifier, password)
 	if err != nil {
+		component := components.LoginResponse(false, "Error signing in")
+		component.Render(r.Context(), w)
+		return
+	}
+	if wait > 0 {
+		a.RenderLoginThrottled(w, r, wait, locked)
+		return
+	}
+	if !ok {
 		component := components.LoginResponse(false, "Invalid Credentials")
 		component.Render(r.Context(), w)
 		return
 	}
 
-	u := db.User{
-		ID: s.UserID,
+	// Only now is the plain password at hand to hash again
+	if a.needsRehash(u.Hash) {
+		a.rehashPassword(r.Context(), u, password)
 	}
 
-	err = u.GetByID(dbConn)
+	t, err := a.enabledTOTP(r.Context(), u.ID)
 	if err != nil {
-		component := components.LoginResponse(false, "Invalid Credentials")
+		component := components.LoginResponse(false, "Error signing in")
 		component.Render(r.Context(), w)
 		return
 	}
-
-	if !VerifyPassword(u.Hash, password) {
-		component := components.LoginResponse(false, "Invalid Credentials")
-		component.Render(r.Context(), w)
+	if t != nil {
+		a.startTwoFactor(w, r, u.ID, identifier)
 		return
 	}
 
-	token, err := CreateSession(u.ID)
+	if err := a.recordLogin(r.Context(), r, userID, identifier, true); err != nil {
+		log.Printf("Error recording sign in: %v", err)
+	}
+
+	session, err := a.CreateSession(r, u.ID)
 	if err != nil {
 		component := components.LoginResponse(false, "Error creating session")
 		component.Render(r.Context(), w)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session",
-		Value:    token,
-		Expires:  time.Now().Add(24 * time.Hour),
-		HttpOnly: false,
-		Secure:   false,
-		SameSite: http.SameSiteStrictMode,
-		Path:     "/",
-	})
+	a.setSessionCookie(w, session)
 
 	w.Header().Set("HX-Redirect", "/")
 	w.WriteHeader(http.StatusOK)
 }
 
-func Signup(w http.ResponseWriter, r *http.Request) {
+// Signup validates everything first, then creates the user and student in
+// one transaction and only publishes the profile photo once it commits
+func (a *Auth) Signup(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 
-	err := r.ParseMultipartForm(5 << 20) // 5 MB
+	a.Uploads.LimitBody(w, r, 1)
+	err := r.ParseMultipartForm(a.Uploads.Limits.MaxBytes)
+	if uploads.BodyTooLarge(err) {
+		component := components.SignupResponse(false, "Profile photo is too large", map[string]string{"file": "Profile photo is too large"})
+		component.Render(r.Context(), w)
+		return
+	}
 	if err != nil {
-		component := components.SignupResponse(false, "Error processing form")
+		component := components.SignupResponse(false, "Error processing form", nil)
 		component.Render(r.Context(), w)
 		return
 	}
@@ -184,184 +240,206 @@ func Signup(w http.ResponseWriter, r *http.Request) {
 	password := r.FormValue("password")
 	confirmPassword := r.FormValue("confirm_password")
 
-	// --- File Upload Logic ---
-	file, _, err := r.FormFile("file") // "file" is the name attribute from your <input type="file">
-	if err != nil {
-		if err == http.ErrMissingFile {
-			component := components.SignupResponse(false, "Profile photo is required")
-			component.Render(r.Context(), w)
-			return
-		} else {
-			component := components.SignupResponse(false, "Error retrieving profile photo: "+err.Error())
-			component.Render(r.Context(), w)
-			return
-		}
-	} else {
-		defer file.Close()
-
-		// Ensure the upload directory exists
-		if _, err := os.Stat(uploadDir); os.IsNotExist(err) {
-			err = os.MkdirAll(uploadDir, 0755) // Create with read/write/execute permissions for owner, read/execute for group/others
-			if err != nil {
-				component := components.SignupResponse(false, "Error creating upload directory: "+err.Error())
-				component.Render(r.Context(), w)
-				return
-			}
+	// Empty values validation
+	missing := map[string]string{}
+	for field, value := range map[string]string{
+		"student_id":       s.ID,
+		"middle_names":     u.MiddleNames,
+		"paternal_surname": u.PaternalSurname,
+		"maternal_surname": u.MaternalSurname,
+		"email":            u.Email,
+		"password":         password,
+		"confirm_password": confirmPassword,
+	} {
+		if value == "" {
+			missing[field] = "Required"
 		}
+	}
+	if len(missing) > 0 {
+		component := components.SignupResponse(false, "All fields are required", missing)
+		component.Render(r.Context(), w)
+		return
+	}
 
-		// Use the student ID for the filename
-		filename := s.ID + ".jpg"
+	if err := a.Passwords.Check(password, s.ID); err != nil {
+		component := components.SignupResponse(false, err.Error(), map[string]string{"password": err.Error()})
+		component.Render(r.Context(), w)
+		return
+	}
 
-		// Construct the full path to save the file
-		filePath := filepath.Join(uploadDir, filename)
+	if password != confirmPassword {
+		component := components.SignupResponse(false, "Passwords don't match", map[string]string{"confirm_password": "Passwords don't match"})
+		component.Render(r.Context(), w)
+		return
+	}
 
-		// Create the new file on the server
-		dst, err := os.Create(filePath)
-		if err != nil {
-			component := components.SignupResponse(false, "Error creating file on server: "+err.Error())
+	file, _, err := r.FormFile("file") // "file" is the name attribute from your <input type="file">
+	if err != nil {
+		if err == http.ErrMissingFile {
+			component := components.SignupResponse(false, "Profile photo is required", map[string]string{"file": "Profile photo is required"})
 			component.Render(r.Context(), w)
-			return
-		}
-		defer dst.Close() // Close the destination file
-
-		// Copy the uploaded file's content to the new file
-		if _, err := io.Copy(dst, file); err != nil {
-			component := components.SignupResponse(false, "Error saving profile photo: "+err.Error())
+		} else {
+			component := components.SignupResponse(false, "Error retrieving profile photo: "+err.Error(), nil)
 			component.Render(r.Context(), w)
-			return
 		}
+		return
 	}
+	defer file.Close()
 
-	// Empty values validation
-	if s.ID == "" || u.MiddleNames == "" || u.PaternalSurname == "" || u.MaternalSurname == "" || u.Email == "" || password == "" || confirmPassword == "" {
-		component := components.SignupResponse(false, "All fields are required")
+	existingUser := db.User{Email: u.Email}
+	err = a.Store.Users.GetByEmail(r.Context(), &existingUser)
+	if err == nil {
+		component := components.SignupResponse(false, "User already exists", map[string]string{"email": "User already exists"})
+		component.Render(r.Context(), w)
+		return
+	}
+	if !errors.Is(err, db.ErrUserNotFound) {
+		component := components.SignupResponse(false, "Error creating user", nil)
 		component.Render(r.Context(), w)
 		return
 	}
 
-	if password != confirmPassword {
-		component := components.SignupResponse(false, "Passwords don't match")
+	existingStudent := db.Student{ID: s.ID}
+	err = a.Store.Students.GetByID(r.Context(), &existingStudent)
+	if err == nil {
+		component := components.SignupResponse(false, "Student already exists", map[string]string{"student_id": "Student already exists"})
+		component.Render(r.Context(), w)
+		return
+	}
+	if !errors.Is(err, db.ErrStudentNotFound) {
+		component := components.SignupResponse(false, "Error creating student", nil)
 		component.Render(r.Context(), w)
 		return
 	}
 
-	// Create User
-	err = u.GetByEmail(dbConn)
-	if err != nil && !strings.Contains(err.Error(), "user not found") {
-		component := components.SignupResponse(false, "Error creating user")
+	u.Hash, err = a.HashPassword(password)
+	if err != nil {
+		component := components.SignupResponse(false, "Error creating user", nil)
 		component.Render(r.Context(), w)
 		return
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	stagedPhoto, err := a.Uploads.Stage(file)
+	if uploads.IsInvalid(err) {
+		component := components.SignupResponse(false, "Invalid profile photo", map[string]string{"file": err.Error()})
+		component.Render(r.Context(), w)
+		return
+	}
 	if err != nil {
-		component := components.SignupResponse(false, "Error creating user")
+		component := components.SignupResponse(false, "Error saving profile photo", nil)
 		component.Render(r.Context(), w)
 		return
 	}
 
-	u.Hash = string(hashedPassword)
+	// Create user and student, the photo is only stored once they exist
+	u.PhotoKey = uploads.NewKey("photos")
+	err = a.Store.WithTx(r.Context(), func(tx *db.Store) error {
+		if err := tx.Users.Set(r.Context(), &u); err != nil {
+			return err
+		}
 
-	err = u.Set(dbConn)
+		s.UserID = u.ID
+		return tx.Students.Set(r.Context(), &s)
+	})
+	if errors.Is(err, db.ErrEmailTaken) {
+		component := components.SignupResponse(false, "User already exists", map[string]string{"email": "User already exists"})
+		component.Render(r.Context(), w)
+		return
+	}
 	if err != nil {
-		if strings.Contains(err.Error(), "already exists") {
-			component := components.SignupResponse(false, "User already exists")
-			component.Render(r.Context(), w)
-		} else {
-			component := components.SignupResponse(false, "Error creating user")
-			component.Render(r.Context(), w)
-		}
+		component := components.SignupResponse(false, "Error creating user", nil)
+		component.Render(r.Context(), w)
 		return
 	}
 
-	s.UserID = u.ID
-	err = s.Set(dbConn)
-	if err != nil {
-		if strings.Contains(err.Error(), "already exists") {
-			component := components.SignupResponse(false, "Student already exists")
-			component.Render(r.Context(), w)
-		} else {
-			component := components.SignupResponse(false, "Error creating student")
-			component.Render(r.Context(), w)
-		}
+	if err := a.Uploads.Commit(r.Context(), stagedPhoto, u.PhotoKey); err != nil {
+		// Undo the signup, deleting the user cascades to the student
+		ctx := context.WithoutCancel(r.Context())
+		a.Uploads.Remove(ctx, u.PhotoKey)
+		a.Store.Users.Delete(ctx, &u)
+		component := components.SignupResponse(false, "Error saving profile photo", nil)
+		component.Render(r.Context(), w)
 		return
 	}
 
+	// The account stays unverified until the emailed link is followed
+	if err := a.SendEmailVerification(r.Context(), &u); err != nil {
+		log.Printf("Error sending email verification to user %d: %v", u.ID, err)
+	}
+
 	// Create session
-	token, err := CreateSession(u.ID)
+	session, err := a.CreateSession(r, u.ID)
 	if err != nil {
-		component := components.SignupResponse(false, "User created but error logging in")
+		component := components.SignupResponse(false, "User created but error logging in", nil)
 		component.Render(r.Context(), w)
 		return
 	}
 
 	// Set cookies
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session",
-		Value:    token,
-		Expires:  time.Now().Add(24 * time.Hour),
-		HttpOnly: false,
-		Secure:   false,
-		SameSite: http.SameSiteStrictMode,
-		Path:     "/",
-	})
+	a.setSessionCookie(w, session)
 
 	w.Header().Set("HX-Redirect", "/")
 	w.WriteHeader(http.StatusOK)
 }
 
-func GetSessionFromRequest(r *http.Request) (*db.Session, error) {
+// Answer an attempt the lockout or backoff turned away
+func (a *Auth) RenderLoginThrottled(w http.ResponseWriter, r *http.Request, wait time.Duration, locked bool) {
+	// Round up so it never reads "0s"
+	wait = (wait + time.Second - 1).Truncate(time.Second)
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())))
+	w.WriteHeader(http.StatusTooManyRequests)
+
+	component := components.LoginThrottled(wait.String(), locked)
+	component.Render(r.Context(), w)
+}
+
+func (a *Auth) GetSessionFromRequest(r *http.Request) (*db.Session, error) {
 	cookie, err := r.Cookie("session")
 	if err != nil {
 		return nil, errors.New("no session cookie found")
 	}
 
-	return GetSession(cookie.Value)
+	return a.GetSession(r.Context(), cookie.Value)
 }
 
-func Logout(w http.ResponseWriter, r *http.Request) {
+func (a *Auth) Logout(w http.ResponseWriter, r *http.Request) {
 	cookie, err := r.Cookie("session")
 	if err == nil {
-		DeleteSession(cookie.Value)
+		a.DeleteSession(r.Context(), cookie.Value)
 	}
 
 	// Delete Cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session",
-		Value:    "",
-		Expires:  time.Now().Add(-time.Hour),
-		HttpOnly: false,
-		Secure:   false,
-		SameSite: http.SameSiteStrictMode,
-		Path:     "/",
-	})
+	a.clearSessionCookie(w)
 
 	http.Redirect(w, r, "/auth/login", http.StatusFound)
 }
 
-func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+func (a *Auth) AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		session, err := GetSessionFromRequest(r)
+		if token, ok := bearerToken(r); ok {
+			a.tokenMiddleware(w, r, token, next)
+			return
+		}
+
+		session, err := a.GetSessionFromRequest(r)
 		if err != nil {
+			a.clearSessionCookie(w)
 			http.Redirect(w, r, "/auth/login", http.StatusFound)
 			return
 		}
 
-		_, err = GetSession(session.UUID)
+		principal, err := a.resolvePrincipal(r.Context(), session.UserID)
 		if err != nil {
-			http.SetCookie(w, &http.Cookie{
-				Name:     "session",
-				Value:    "",
-				Expires:  time.Now().Add(-time.Hour),
-				HttpOnly: false,
-				Secure:   false,
-				SameSite: http.SameSiteStrictMode,
-				Path:     "/",
-			})
-			http.Redirect(w, r, "/auth/login", http.StatusFound)
+			http.Error(w, "Error loading account", http.StatusInternalServerError)
 			return
 		}
+		principal.Session = session
+
+		// Slide the cookie along with the idle timeout
+		a.setSessionCookie(w, session)
 
-		next(w, r)
+		ctx := context.WithValue(r.Context(), principalContextKey{}, principal)
+		next(w, r.WithContext(ctx))
 	}
 }
@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/internal/app"
+	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/calmestend/mercado_lobito/internal/db/memory"
+)
+
+const testPassword = "correct horse battery"
+
+// Auth over an in-memory store, with cheap hashes and no backoff so tests
+// can fail sign ins back to back
+func newTestAuth(t *testing.T) *Auth {
+	t.Helper()
+
+	t.Setenv("BCRYPT_COST", "4")
+	t.Setenv("UPLOAD_DIR", t.TempDir())
+	t.Setenv("APP_SECRET", "test secret")
+
+	a := New(app.New(memory.New()))
+	a.Login.BackoffBase = 0
+	a.Login.BackoffMax = 0
+	return a
+}
+
+// User with testPassword, a student when studentID isn't empty
+func newTestUser(t *testing.T, a *Auth, email, studentID string) *db.User {
+	t.Helper()
+
+	hash, err := a.HashPassword(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := db.User{Email: email, Hash: hash, MiddleNames: "Ana"}
+	if err := a.Store.Users.Set(context.Background(), &u); err != nil {
+		t.Fatal(err)
+	}
+	if studentID != "" {
+		if err := a.Store.Students.Set(context.Background(), &db.Student{ID: studentID, UserID: u.ID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &u
+}
+
+func postForm(h http.HandlerFunc, target string, form url.Values) *httptest.ResponseRecorder {
+	return postFormFrom(h, target, "", form)
+}
+
+// Like postForm from remoteAddr, httptest's default when empty
+func postFormFrom(h http.HandlerFunc, target, remoteAddr string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if remoteAddr != "" {
+		req.RemoteAddr = remoteAddr
+	}
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+// Like postForm signed in as u, through AuthMiddleware
+func postFormAs(t *testing.T, a *Auth, u *db.User, h http.HandlerFunc, target string, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+
+	session, err := a.CreateSession(httptest.NewRequest(http.MethodGet, "/", nil), u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "session", Value: session.UUID})
+	rec := httptest.NewRecorder()
+	a.AuthMiddleware(h)(rec, req)
+	return rec
+}
+
+func sessionCookie(rec *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "session" && c.Value != "" {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestSignin(t *testing.T) {
+	a := newTestAuth(t)
+	newTestUser(t, a, "ana@example.com", "123456")
+
+	tests := []struct {
+		name       string
+		identifier string
+		password   string
+		wantOK     bool
+	}{
+		{"email", "ana@example.com", testPassword, true},
+		{"email any case", "ANA@example.com", testPassword, true},
+		{"student id", "123456", testPassword, true},
+		{"wrong password", "ana@example.com", "wrong password!", false},
+		{"unknown email", "nobody@example.com", testPassword, false},
+		{"unknown student id", "999999", testPassword, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postForm(a.Signin, "/auth/signin", url.Values{"identifier": {tt.identifier}, "password": {tt.password}})
+
+			if tt.wantOK {
+				if rec.Header().Get("HX-Redirect") != "/" || sessionCookie(rec) == nil {
+					t.Fatalf("sign in failed: %d %s", rec.Code, rec.Body)
+				}
+				return
+			}
+			if sessionCookie(rec) != nil {
+				t.Fatal("session cookie set on failed sign in")
+			}
+			if !strings.Contains(rec.Body.String(), "Invalid Credentials") {
+				t.Errorf("body = %s, want Invalid Credentials", rec.Body)
+			}
+		})
+	}
+}
+
+func TestSigninLockout(t *testing.T) {
+	a := newTestAuth(t)
+	a.Login.MaxFailures = 3
+	newTestUser(t, a, "ana@example.com", "")
+
+	wrong := url.Values{"identifier": {"ana@example.com"}, "password": {"wrong password!"}}
+	for range a.Login.MaxFailures - 1 {
+		if rec := postForm(a.Signin, "/auth/signin", wrong); rec.Code != http.StatusOK {
+			t.Fatalf("failure before the limit: status %d", rec.Code)
+		}
+	}
+
+	// The last allowed failure already reports the lockout
+	if rec := postForm(a.Signin, "/auth/signin", wrong); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+
+	rec := postForm(a.Signin, "/auth/signin", url.Values{"identifier": {"ana@example.com"}, "password": {testPassword}})
+	if rec.Code != http.StatusTooManyRequests || sessionCookie(rec) != nil {
+		t.Fatalf("locked out account signed in: %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("missing Retry-After")
+	}
+}
+
+func TestSigninBackoff(t *testing.T) {
+	a := newTestAuth(t)
+	a.Login.BackoffBase = time.Minute
+	a.Login.BackoffMax = time.Hour
+	newTestUser(t, a, "ana@example.com", "")
+
+	postForm(a.Signin, "/auth/signin", url.Values{"identifier": {"ana@example.com"}, "password": {"wrong password!"}})
+
+	rec := postForm(a.Signin, "/auth/signin", url.Values{"identifier": {"ana@example.com"}, "password": {testPassword}})
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d right after a failure", rec.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestSigninUnknownAccountTiming(t *testing.T) {
+	a := newTestAuth(t)
+	// Enough cost that a skipped comparison can't hide in the noise
+	t.Setenv("BCRYPT_COST", "10")
+	a.Passwords = PasswordPolicyFromEnv()
+	hash, err := a.HashPassword(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := newTestUser(t, a, "ana@example.com", "")
+	u.Hash = hash
+	if err := a.Store.Users.Update(context.Background(), u); err != nil {
+		t.Fatal(err)
+	}
+
+	timeSignin := func(identifier string) time.Duration {
+		start := time.Now()
+		postForm(a.Signin, "/auth/signin", url.Values{"identifier": {identifier}, "password": {"wrong password!"}})
+		return time.Since(start)
+	}
+
+	known := timeSignin("ana@example.com")
+	unknown := timeSignin("nobody@example.com")
+	if unknown < known/3 {
+		t.Errorf("unknown account refused in %v, known one in %v", unknown, known)
+	}
+}
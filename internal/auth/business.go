@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/calmestend/mercado_lobito/internal/db"
+)
+
+var (
+	ErrNoBusiness        = errors.New("business not found")
+	ErrNotBusinessMember = errors.New("not a member of this business")
+	ErrMissingPermission = errors.New("missing permission for this business")
+)
+
+// A business the principal can act on
+type Membership struct {
+	Business   db.Business
+	Permission db.Permission
+	Owner      bool
+}
+
+// Every business the principal owns or collaborates in, owned one first
+func (a *Auth) Memberships(ctx context.Context, p *Principal) ([]Membership, error) {
+	var memberships []Membership
+
+	if p.OwnedBusinessID != 0 {
+		business := db.Business{ID: p.OwnedBusinessID}
+		if err := a.Store.Businesses.Get(ctx, &business); err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, Membership{
+			Business:   business,
+			Permission: db.PermissionManageTeam,
+			Owner:      true,
+		})
+	}
+
+	for _, m := range p.MemberOf {
+		if m.BusinessID == p.OwnedBusinessID {
+			continue
+		}
+
+		business := db.Business{ID: m.BusinessID}
+		if err := a.Store.Businesses.Get(ctx, &business); err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, Membership{
+			Business:   business,
+			Permission: m.Permission,
+		})
+	}
+
+	return memberships, nil
+}
+
+// Business the request acts on. A business_id form or query value picks one
+// explicitly, otherwise the owned business wins over the first membership.
+// Owners hold every permission.
+func (a *Auth) ResolveBusiness(r *http.Request, required db.Permission) (*Membership, error) {
+	p, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		return nil, ErrNoBusiness
+	}
+
+	businessID := 0
+	if idVal := r.FormValue("business_id"); idVal != "" {
+		id, err := strconv.Atoi(idVal)
+		if err != nil {
+			return nil, ErrNoBusiness
+		}
+		businessID = id
+	}
+
+	if businessID == 0 {
+		businessID = p.OwnedBusinessID
+	}
+	if businessID == 0 && len(p.MemberOf) > 0 {
+		businessID = p.MemberOf[0].BusinessID
+	}
+	if businessID == 0 {
+		return nil, ErrNoBusiness
+	}
+
+	m := Membership{Business: db.Business{ID: businessID}}
+	if businessID == p.OwnedBusinessID {
+		m.Owner = true
+		m.Permission = db.PermissionManageTeam
+	} else {
+		for _, bc := range p.MemberOf {
+			if bc.BusinessID == businessID {
+				m.Permission = bc.Permission
+				break
+			}
+		}
+		if m.Permission == "" {
+			return nil, ErrNotBusinessMember
+		}
+	}
+
+	if !m.Permission.Allows(required) {
+		return nil, ErrMissingPermission
+	}
+
+	if err := a.Store.Businesses.Get(r.Context(), &m.Business); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// Write the response for an error returned by ResolveBusiness
+func BusinessError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNoBusiness), errors.Is(err, db.ErrBusinessNotFound):
+		http.Error(w, "Business not found", http.StatusNotFound)
+	case errors.Is(err, ErrNotBusinessMember), errors.Is(err, ErrMissingPermission):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	default:
+		http.Error(w, "Error retrieving business", http.StatusInternalServerError)
+	}
+}
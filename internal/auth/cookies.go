@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/pkg/env"
+)
+
+// Attributes every cookie set by the app shares. Cookies are HttpOnly and
+// Secure unless COOKIE_SECURE=false, which is only meant for plain http
+// development outside localhost.
+type CookiePolicy struct {
+	Secure   bool
+	SameSite http.SameSite
+}
+
+func CookiePolicyFromEnv() CookiePolicy {
+	return CookiePolicy{
+		Secure:   env.GetBool("COOKIE_SECURE", true),
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
+func (p CookiePolicy) Cookie(name, value string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   p.Secure,
+		SameSite: p.SameSite,
+		Path:     "/",
+	}
+}
+
+// Cookie that makes the browser drop `name`
+func (p CookiePolicy) Expired(name string) *http.Cookie {
+	c := p.Cookie(name, "", time.Unix(0, 0))
+	c.MaxAge = -1
+	return c
+}
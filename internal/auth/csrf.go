@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/internal/components"
+)
+
+const (
+	csrfCookieName = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+	csrfFormField  = "csrf_token"
+)
+
+type csrfContextKey struct{}
+
+// Double submit protection: every visitor gets a random token in an HttpOnly
+// cookie, pages embed the same token in hx-headers and any state-changing
+// request must send it back. Requests with a bearer token are exempt, browsers
+// never attach one on their own and AuthMiddleware ignores the cookie then.
+func (a *Auth) CSRFMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := ""
+		if cookie, err := r.Cookie(csrfCookieName); err == nil && validCSRFToken(cookie.Value) {
+			token = cookie.Value
+		}
+
+		if _, bearer := bearerToken(r); !isSafeMethod(r.Method) && !bearer {
+			sent := r.Header.Get(csrfHeaderName)
+			// Multipart bodies are left to the handler, which caps their size
+			// before parsing them. Pages send the header anyway.
+			if sent == "" && !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+				sent = r.PostFormValue(csrfFormField)
+			}
+
+			if token == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+				w.Header().Set("Content-Type", "text/html")
+				w.WriteHeader(http.StatusForbidden)
+				components.CSRFError().Render(r.Context(), w)
+				return
+			}
+		}
+
+		if token == "" {
+			token = newCSRFToken()
+			http.SetCookie(w, a.Cookies.Cookie(csrfCookieName, token, time.Now().Add(365*24*time.Hour)))
+		}
+
+		ctx := context.WithValue(r.Context(), csrfContextKey{}, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Token of the current request, to be rendered in views.Index
+func CSRFToken(ctx context.Context) string {
+	token, _ := ctx.Value(csrfContextKey{}).(string)
+	return token
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func newCSRFToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func validCSRFToken(token string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	return err == nil && len(b) == 32
+}
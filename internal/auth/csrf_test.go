@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	a := newTestAuth(t)
+	h := a.CSRFMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(CSRFToken(r.Context())))
+	}))
+
+	token := newCSRFToken()
+	cookie := &http.Cookie{Name: csrfCookieName, Value: token}
+
+	tests := []struct {
+		name       string
+		method     string
+		cookie     *http.Cookie
+		header     string
+		form       string
+		multipart  bool
+		bearer     bool
+		wantStatus int
+	}{
+		{name: "get without cookie", method: http.MethodGet, wantStatus: http.StatusOK},
+		{name: "post without cookie", method: http.MethodPost, header: token, wantStatus: http.StatusForbidden},
+		{name: "post without token", method: http.MethodPost, cookie: cookie, wantStatus: http.StatusForbidden},
+		{name: "post wrong token", method: http.MethodPost, cookie: cookie, header: newCSRFToken(), wantStatus: http.StatusForbidden},
+		{name: "post header token", method: http.MethodPost, cookie: cookie, header: token, wantStatus: http.StatusOK},
+		{name: "post form token", method: http.MethodPost, cookie: cookie, form: token, wantStatus: http.StatusOK},
+		{name: "delete header token", method: http.MethodDelete, cookie: cookie, header: token, wantStatus: http.StatusOK},
+		// Left unparsed so the handler can cap the body first
+		{name: "post multipart form token", method: http.MethodPost, cookie: cookie, form: token, multipart: true, wantStatus: http.StatusForbidden},
+		{name: "post multipart header token", method: http.MethodPost, cookie: cookie, header: token, multipart: true, wantStatus: http.StatusOK},
+		{name: "post bearer", method: http.MethodPost, bearer: true, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", strings.NewReader(url.Values{csrfFormField: {tt.form}}.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.multipart {
+				var body bytes.Buffer
+				mw := multipart.NewWriter(&body)
+				mw.WriteField(csrfFormField, tt.form)
+				mw.Close()
+				req = httptest.NewRequest(tt.method, "/", &body)
+				req.Header.Set("Content-Type", mw.FormDataContentType())
+			}
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+			if tt.header != "" {
+				req.Header.Set(csrfHeaderName, tt.header)
+			}
+			if tt.bearer {
+				req.Header.Set("Authorization", "Bearer some-token")
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestCSRFMiddlewareIssuesToken(t *testing.T) {
+	a := newTestAuth(t)
+	h := a.CSRFMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(CSRFToken(r.Context())))
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var issued string
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == csrfCookieName {
+			issued = c.Value
+		}
+	}
+	if !validCSRFToken(issued) {
+		t.Fatalf("no valid token cookie issued, got %q", issued)
+	}
+	// Pages render the same token they set in the cookie
+	if rec.Body.String() != issued {
+		t.Errorf("token in context %q, cookie %q", rec.Body.String(), issued)
+	}
+}
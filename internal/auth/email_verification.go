@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/internal/components"
+	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/calmestend/mercado_lobito/internal/mail"
+)
+
+const emailVerificationPurpose = "email-verification"
+
+var ErrInvalidEmailVerification = errors.New("invalid or expired email verification")
+
+// Email a signed verification link. The token names the address too, so
+// links sent before an email change stop working.
+func (a *Auth) SendEmailVerification(ctx context.Context, u *db.User) error {
+	expiresAt := a.now().Add(a.EmailVerificationTTL)
+	token := a.Signer.Sign(emailVerificationPurpose, strconv.Itoa(u.ID)+":"+u.Email, expiresAt)
+	link := a.BaseURL + "/auth/verify?token=" + url.QueryEscape(token)
+
+	return a.Mailer.Send(ctx, mail.Message{
+		To:      u.Email,
+		Subject: "Verifica tu correo",
+		Body: fmt.Sprintf(
+			"Bienvenido a Mercado Lobito.\n\n"+
+				"Abre este enlace para verificar tu correo:\n%s\n\n"+
+				"El enlace expira el %s.\n",
+			link, expiresAt.Format("02/01/2006 15:04"),
+		),
+	})
+}
+
+// Mark the user a verification token was issued for as verified. Tokens
+// work once, an address already verified turns them away.
+func (a *Auth) VerifyEmail(ctx context.Context, token string) (*db.User, error) {
+	subject, err := a.Signer.Verify(emailVerificationPurpose, token, a.now())
+	if err != nil {
+		return nil, ErrInvalidEmailVerification
+	}
+
+	idVal, email, ok := strings.Cut(subject, ":")
+	if !ok {
+		return nil, ErrInvalidEmailVerification
+	}
+	id, err := strconv.Atoi(idVal)
+	if err != nil {
+		return nil, ErrInvalidEmailVerification
+	}
+
+	u := db.User{ID: id}
+	if err := a.Store.Users.GetByID(ctx, &u); err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return nil, ErrInvalidEmailVerification
+		}
+		return nil, err
+	}
+
+	if u.Email != email || u.EmailVerifiedAt != nil {
+		return nil, ErrInvalidEmailVerification
+	}
+
+	now := a.now()
+	u.EmailVerifiedAt = &now
+	if err := a.Store.Users.SetEmailVerified(ctx, &u); err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+// Send another verification link, at most once per
+// EMAIL_VERIFICATION_RESEND_INTERVAL. Must run inside AuthMiddleware.
+func (a *Auth) ResendEmailVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	p, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if p.EmailVerified {
+		components.EmailVerificationResponse(true, "Tu correo ya está verificado").Render(r.Context(), w)
+		return
+	}
+
+	if ok, wait := a.verificationResends.allow(strconv.Itoa(p.UserID), time.Now()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		w.WriteHeader(http.StatusTooManyRequests)
+		message := fmt.Sprintf("Espera %s antes de pedir otro correo", wait.Round(time.Second))
+		components.EmailVerificationResponse(false, message).Render(r.Context(), w)
+		return
+	}
+
+	u := db.User{ID: p.UserID}
+	if err := a.Store.Users.GetByID(r.Context(), &u); err != nil {
+		components.EmailVerificationResponse(false, "Error sending email").Render(r.Context(), w)
+		return
+	}
+
+	if err := a.SendEmailVerification(r.Context(), &u); err != nil {
+		log.Printf("Error sending email verification to user %d: %v", u.ID, err)
+		components.EmailVerificationResponse(false, "Error sending email").Render(r.Context(), w)
+		return
+	}
+
+	components.EmailVerificationResponse(true, "Te enviamos un nuevo enlace de verificación").Render(r.Context(), w)
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/internal/db"
+)
+
+// Verification token emailed to u
+func verificationToken(t *testing.T, a *Auth, u *db.User) string {
+	t.Helper()
+
+	mailer := &fakeMailer{}
+	a.Mailer = mailer
+	if err := a.SendEmailVerification(context.Background(), u); err != nil {
+		t.Fatal(err)
+	}
+	if len(mailer.sent) != 1 || mailer.sent[0].To != u.Email {
+		t.Fatalf("sent %v, want one email to %s", mailer.sent, u.Email)
+	}
+	return resetToken(t, mailer.sent[0])
+}
+
+func emailVerified(t *testing.T, a *Auth, u *db.User) bool {
+	t.Helper()
+
+	stored := db.User{ID: u.ID}
+	if err := a.Store.Users.GetByID(context.Background(), &stored); err != nil {
+		t.Fatal(err)
+	}
+	return stored.EmailVerifiedAt != nil
+}
+
+func TestVerifyEmail(t *testing.T) {
+	a := newTestAuth(t)
+	u := newTestUser(t, a, "ana@example.com", "")
+	ctx := context.Background()
+
+	token := verificationToken(t, a, u)
+	verified, err := a.VerifyEmail(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified.ID != u.ID || !emailVerified(t, a, u) {
+		t.Fatalf("verified user %d, stored verified = %v", verified.ID, emailVerified(t, a, u))
+	}
+
+	if _, err := a.VerifyEmail(ctx, token); !errors.Is(err, ErrInvalidEmailVerification) {
+		t.Errorf("reused token: err = %v, want %v", err, ErrInvalidEmailVerification)
+	}
+}
+
+func TestVerifyEmailRejected(t *testing.T) {
+	a := newTestAuth(t)
+	a.EmailVerificationTTL = time.Hour
+	ctx := context.Background()
+
+	t.Run("expired", func(t *testing.T) {
+		clock := newTestClock(a)
+		u := newTestUser(t, a, "ana@example.com", "")
+		token := verificationToken(t, a, u)
+
+		clock.advance(a.EmailVerificationTTL + time.Second)
+		if _, err := a.VerifyEmail(ctx, token); !errors.Is(err, ErrInvalidEmailVerification) {
+			t.Fatalf("err = %v, want %v", err, ErrInvalidEmailVerification)
+		}
+		if emailVerified(t, a, u) {
+			t.Error("expired token verified the email")
+		}
+	})
+
+	t.Run("email changed", func(t *testing.T) {
+		u := newTestUser(t, a, "beto@example.com", "")
+		token := verificationToken(t, a, u)
+
+		u.Email = "roberto@example.com"
+		if err := a.Store.Users.Update(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := a.VerifyEmail(ctx, token); !errors.Is(err, ErrInvalidEmailVerification) {
+			t.Fatalf("err = %v, want %v", err, ErrInvalidEmailVerification)
+		}
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		u := newTestUser(t, a, "carla@example.com", "")
+		token := verificationToken(t, a, u)
+
+		if _, err := a.VerifyEmail(ctx, token+"x"); !errors.Is(err, ErrInvalidEmailVerification) {
+			t.Fatalf("err = %v, want %v", err, ErrInvalidEmailVerification)
+		}
+		// Nor is the same subject signed for something else
+		other := a.Signer.Sign(invitationPurpose, strconv.Itoa(u.ID)+":"+u.Email, time.Now().Add(time.Hour))
+		if _, err := a.VerifyEmail(ctx, other); !errors.Is(err, ErrInvalidEmailVerification) {
+			t.Fatalf("other purpose: err = %v, want %v", err, ErrInvalidEmailVerification)
+		}
+	})
+}
@@ -0,0 +1,369 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/internal/components"
+	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/calmestend/mercado_lobito/internal/mail"
+	"github.com/calmestend/mercado_lobito/internal/uploads"
+)
+
+const invitationPurpose = "invitation"
+
+var (
+	ErrInvalidInvitation = errors.New("invalid or expired invitation")
+	ErrAlreadyInvited    = errors.New("there is already a pending invitation for this email")
+)
+
+func newNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Create a pending invitation and email its link
+func (a *Auth) Invite(ctx context.Context, business *db.Business, email string, permission db.Permission, invitedBy int) (*db.Invitation, error) {
+	existing := db.Invitation{BusinessID: business.ID, Email: email}
+	err := a.Store.Invitations.GetPending(ctx, &existing)
+	if err == nil {
+		return nil, ErrAlreadyInvited
+	}
+	if !errors.Is(err, db.ErrInvitationNotFound) {
+		return nil, err
+	}
+
+	inv := db.Invitation{
+		BusinessID: business.ID,
+		Email:      email,
+		Permission: permission,
+		Nonce:      newNonce(),
+		Status:     db.InvitationPending,
+		InvitedBy:  invitedBy,
+		ExpiresAt:  time.Now().Add(a.InvitationTTL),
+	}
+	if err := a.Store.Invitations.Set(ctx, &inv); err != nil {
+		return nil, err
+	}
+
+	if err := a.sendInvitation(ctx, &inv, business); err != nil {
+		return &inv, err
+	}
+	return &inv, nil
+}
+
+// Email a fresh link, earlier links stop working
+func (a *Auth) ResendInvitation(ctx context.Context, inv *db.Invitation, business *db.Business) error {
+	if inv.Status != db.InvitationPending {
+		return ErrInvalidInvitation
+	}
+
+	inv.Nonce = newNonce()
+	inv.ExpiresAt = time.Now().Add(a.InvitationTTL)
+	if err := a.Store.Invitations.Update(ctx, inv); err != nil {
+		return err
+	}
+
+	return a.sendInvitation(ctx, inv, business)
+}
+
+func (a *Auth) RevokeInvitation(ctx context.Context, inv *db.Invitation) error {
+	if inv.Status != db.InvitationPending {
+		return ErrInvalidInvitation
+	}
+
+	inv.Status = db.InvitationRevoked
+	return a.Store.Invitations.Update(ctx, inv)
+}
+
+func (a *Auth) sendInvitation(ctx context.Context, inv *db.Invitation, business *db.Business) error {
+	subject := strconv.Itoa(inv.ID) + "." + inv.Nonce
+	token := a.Signer.Sign(invitationPurpose, subject, inv.ExpiresAt)
+	link := a.BaseURL + "/invitations?token=" + url.QueryEscape(token)
+
+	return a.Mailer.Send(ctx, mail.Message{
+		To:      inv.Email,
+		Subject: fmt.Sprintf("Invitación para colaborar en %s", business.Name),
+		Body: fmt.Sprintf(
+			"Te invitaron a colaborar en %s en Mercado Lobito.\n\n"+
+				"Abre este enlace para aceptar o rechazar la invitación:\n%s\n\n"+
+				"El enlace expira el %s.\n",
+			business.Name, link, inv.ExpiresAt.Format("02/01/2006 15:04"),
+		),
+	})
+}
+
+// Pending invitation a token was issued for. Tokens of resent, revoked or
+// answered invitations are rejected.
+func (a *Auth) InvitationFromToken(ctx context.Context, token string) (*db.Invitation, error) {
+	subject, err := a.Signer.Verify(invitationPurpose, token, time.Now())
+	if err != nil {
+		return nil, ErrInvalidInvitation
+	}
+
+	idVal, nonce, ok := strings.Cut(subject, ".")
+	if !ok {
+		return nil, ErrInvalidInvitation
+	}
+	id, err := strconv.Atoi(idVal)
+	if err != nil {
+		return nil, ErrInvalidInvitation
+	}
+
+	inv := db.Invitation{ID: id}
+	if err := a.Store.Invitations.Get(ctx, &inv); err != nil {
+		if errors.Is(err, db.ErrInvitationNotFound) {
+			return nil, ErrInvalidInvitation
+		}
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(nonce), []byte(inv.Nonce)) != 1 || inv.Status != db.InvitationPending {
+		return nil, ErrInvalidInvitation
+	}
+
+	return &inv, nil
+}
+
+// Accept an invitation. Someone new sets their password here, someone with
+// an account confirms theirs, or has just signed in through the identity
+// provider when they have none. Either way they end up signed in, unless the
+// account uses two-factor and still has to go through the login form.
+func (a *Auth) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Multipart when a new account sends its profile photo along
+	a.Uploads.LimitBody(w, r, 1)
+	err := r.ParseMultipartForm(a.Uploads.Limits.MaxBytes)
+	if uploads.BodyTooLarge(err) {
+		components.InvitationResponse(false, "Profile photo is too large").Render(r.Context(), w)
+		return
+	}
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		components.InvitationResponse(false, "Error processing form").Render(r.Context(), w)
+		return
+	}
+	if r.MultipartForm != nil {
+		defer r.MultipartForm.RemoveAll()
+	}
+
+	inv, err := a.InvitationFromToken(r.Context(), r.FormValue("token"))
+	if err != nil {
+		components.InvitationResponse(false, "Invalid or expired invitation").Render(r.Context(), w)
+		return
+	}
+
+	u := db.User{Email: inv.Email}
+	err = a.Store.Users.GetByEmail(r.Context(), &u)
+	if err != nil && !errors.Is(err, db.ErrUserNotFound) {
+		components.InvitationResponse(false, "Error accepting invitation").Render(r.Context(), w)
+		return
+	}
+	hasAccount := err == nil
+
+	// Accounts that sign in only through the identity provider have no
+	// password to confirm
+	password := r.FormValue("password")
+	if password == "" && (!hasAccount || u.Hash != "") {
+		components.InvitationResponse(false, "Password is required").Render(r.Context(), w)
+		return
+	}
+
+	var s db.Student
+	var stagedPhoto *uploads.Staged
+	if hasAccount {
+		// Same lockout as signing in, so the link isn't a way to guess the
+		// account's password
+		ok, wait, locked, err := a.confirmIdentity(r, &u, password)
+		if errors.Is(err, ErrSignInAgain) {
+			components.SignInAgain().Render(r.Context(), w)
+			return
+		}
+		if err != nil {
+			components.InvitationResponse(false, "Error accepting invitation").Render(r.Context(), w)
+			return
+		}
+		if wait > 0 {
+			a.RenderLoginThrottled(w, r, wait, locked)
+			return
+		}
+		if !ok {
+			components.InvitationResponse(false, "Invalid Credentials").Render(r.Context(), w)
+			return
+		}
+	} else {
+		u.MiddleNames = r.FormValue("middle_names")
+		u.PaternalSurname = r.FormValue("paternal_surname")
+		u.MaternalSurname = r.FormValue("maternal_surname")
+
+		if u.MiddleNames == "" || u.PaternalSurname == "" || u.MaternalSurname == "" {
+			components.InvitationResponse(false, "All fields are required").Render(r.Context(), w)
+			return
+		}
+
+		if password != r.FormValue("confirm_password") {
+			components.InvitationResponse(false, "Passwords don't match").Render(r.Context(), w)
+			return
+		}
+
+		s = db.Student{ID: r.FormValue("student_id"), Grade: r.FormValue("grade"), ClassGroup: r.FormValue("class_group")}
+		if err := a.Passwords.Check(password, s.ID); err != nil {
+			components.InvitationResponse(false, err.Error()).Render(r.Context(), w)
+			return
+		}
+
+		if s.ID != "" {
+			existing := db.Student{ID: s.ID}
+			if err := a.Store.Students.GetByID(r.Context(), &existing); err == nil {
+				components.InvitationResponse(false, "Student already exists").Render(r.Context(), w)
+				return
+			}
+		}
+
+		u.Hash, err = a.HashPassword(password)
+		if err != nil {
+			components.InvitationResponse(false, "Error accepting invitation").Render(r.Context(), w)
+			return
+		}
+
+		// The photo is optional, it can be set later from the settings. Like
+		// at signup it is only stored once the account exists.
+		if file, _, err := r.FormFile("file"); err == nil {
+			defer file.Close()
+
+			stagedPhoto, err = a.Uploads.Stage(file)
+			if uploads.IsInvalid(err) {
+				components.InvitationResponse(false, "Invalid profile photo: "+err.Error()).Render(r.Context(), w)
+				return
+			}
+			if err != nil {
+				components.InvitationResponse(false, "Error saving profile photo").Render(r.Context(), w)
+				return
+			}
+			u.PhotoKey = uploads.NewKey("photos")
+		}
+	}
+
+	// Following the emailed link proves the address
+	newlyVerified := u.EmailVerifiedAt == nil
+	if newlyVerified {
+		now := time.Now()
+		u.EmailVerifiedAt = &now
+	}
+
+	err = a.Store.WithTx(r.Context(), func(tx *db.Store) error {
+		if hasAccount && newlyVerified {
+			if err := tx.Users.SetEmailVerified(r.Context(), &u); err != nil {
+				return err
+			}
+		}
+
+		if !hasAccount {
+			if err := tx.Users.Set(r.Context(), &u); err != nil {
+				return err
+			}
+
+			if s.ID != "" {
+				s.UserID = u.ID
+				if err := tx.Students.Set(r.Context(), &s); err != nil {
+					return err
+				}
+			}
+		}
+
+		bc := db.BusinessCollaborator{BusinessID: inv.BusinessID, CollaboratorID: u.ID}
+		err := tx.BusinessCollaborators.GetByBusinessAndCollaborator(r.Context(), &bc)
+		if errors.Is(err, db.ErrCollaboratorNotFound) {
+			bc.Permission = inv.Permission
+			err = tx.BusinessCollaborators.Set(r.Context(), &bc)
+		}
+		if err != nil {
+			return err
+		}
+
+		inv.Status = db.InvitationAccepted
+		return tx.Invitations.Update(r.Context(), inv)
+	})
+	if err != nil {
+		components.InvitationResponse(false, "Error accepting invitation").Render(r.Context(), w)
+		return
+	}
+
+	// The account is usable without its photo, so failing to store it only
+	// leaves the photo unset
+	if stagedPhoto != nil {
+		if err := a.Uploads.Commit(r.Context(), stagedPhoto, u.PhotoKey); err != nil {
+			log.Printf("Error saving profile photo of user %d: %v", u.ID, err)
+			ctx := context.WithoutCancel(r.Context())
+			a.Uploads.Remove(ctx, u.PhotoKey)
+			u.PhotoKey = ""
+			if err := a.Store.Users.SetPhotoKey(ctx, &u); err != nil {
+				log.Printf("Error clearing profile photo of user %d: %v", u.ID, err)
+			}
+		}
+	}
+
+	t, err := a.enabledTOTP(r.Context(), u.ID)
+	if err != nil || t != nil {
+		w.Header().Set("HX-Redirect", "/auth/login")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if hasAccount {
+		if err := a.recordLogin(r.Context(), r, u.ID, u.Email, true); err != nil {
+			log.Printf("Error recording sign in: %v", err)
+		}
+	}
+
+	session, err := a.CreateSession(r, u.ID)
+	if err != nil {
+		components.InvitationResponse(false, "Invitation accepted but error logging in").Render(r.Context(), w)
+		return
+	}
+
+	a.setSessionCookie(w, session)
+
+	w.Header().Set("HX-Redirect", "/organization?business_id="+strconv.Itoa(inv.BusinessID))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *Auth) DeclineInvitation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		components.InvitationResponse(false, "Error processing form").Render(r.Context(), w)
+		return
+	}
+
+	inv, err := a.InvitationFromToken(r.Context(), r.FormValue("token"))
+	if err != nil {
+		components.InvitationResponse(false, "Invalid or expired invitation").Render(r.Context(), w)
+		return
+	}
+
+	inv.Status = db.InvitationDeclined
+	if err := a.Store.Invitations.Update(r.Context(), inv); err != nil {
+		components.InvitationResponse(false, "Error declining invitation").Render(r.Context(), w)
+		return
+	}
+
+	components.InvitationResponse(true, "Invitación rechazada").Render(r.Context(), w)
+}
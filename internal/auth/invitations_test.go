@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/calmestend/mercado_lobito/internal/storage"
+)
+
+// Token for a pending invitation of email to a new business
+func newTestInvitation(t *testing.T, a *Auth, email string) (string, *db.Invitation) {
+	t.Helper()
+	ctx := context.Background()
+
+	b := db.Business{Name: "Tacos", OwnerID: "1001"}
+	if err := a.Store.Businesses.Set(ctx, &b); err != nil {
+		t.Fatal(err)
+	}
+	inv := db.Invitation{
+		BusinessID: b.ID,
+		Email:      email,
+		Permission: db.PermissionReadOnly,
+		Nonce:      newNonce(),
+		Status:     db.InvitationPending,
+		ExpiresAt:  time.Now().Add(time.Hour),
+	}
+	if err := a.Store.Invitations.Set(ctx, &inv); err != nil {
+		t.Fatal(err)
+	}
+	return a.Signer.Sign(invitationPurpose, strconv.Itoa(inv.ID)+"."+inv.Nonce, inv.ExpiresAt), &inv
+}
+
+// Guessing an existing account's password through an invitation runs into
+// the same lockout as signing in
+func TestAcceptInvitationLockout(t *testing.T) {
+	a := newTestAuth(t)
+	a.Login.MaxFailures = 3
+	u := newTestUser(t, a, "ana@example.com", "")
+	token, inv := newTestInvitation(t, a, u.Email)
+
+	wrong := url.Values{"token": {token}, "password": {"wrong password!"}}
+	for range a.Login.MaxFailures - 1 {
+		if rec := postForm(a.AcceptInvitation, "/invitations/accept", wrong); rec.Code != http.StatusOK {
+			t.Fatalf("failure before the limit: status %d", rec.Code)
+		}
+	}
+	if rec := postForm(a.AcceptInvitation, "/invitations/accept", wrong); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+
+	rec := postForm(a.AcceptInvitation, "/invitations/accept", url.Values{"token": {token}, "password": {testPassword}})
+	if rec.Code != http.StatusTooManyRequests || sessionCookie(rec) != nil {
+		t.Fatalf("locked out account accepted the invitation: %d", rec.Code)
+	}
+	bc := db.BusinessCollaborator{BusinessID: inv.BusinessID, CollaboratorID: u.ID}
+	if err := a.Store.BusinessCollaborators.GetByBusinessAndCollaborator(context.Background(), &bc); !errors.Is(err, db.ErrCollaboratorNotFound) {
+		t.Errorf("membership created while locked out: %v", err)
+	}
+
+	// The failures count against signing in too
+	rec = postForm(a.Signin, "/auth/signin", url.Values{"identifier": {u.Email}, "password": {testPassword}})
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("sign in status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestAcceptInvitationExistingAccount(t *testing.T) {
+	a := newTestAuth(t)
+	u := newTestUser(t, a, "ana@example.com", "")
+	token, inv := newTestInvitation(t, a, u.Email)
+
+	rec := postForm(a.AcceptInvitation, "/invitations/accept", url.Values{"token": {token}, "password": {testPassword}})
+	if sessionCookie(rec) == nil {
+		t.Fatalf("no session after accepting: %d %s", rec.Code, rec.Body)
+	}
+	bc := db.BusinessCollaborator{BusinessID: inv.BusinessID, CollaboratorID: u.ID}
+	if err := a.Store.BusinessCollaborators.GetByBusinessAndCollaborator(context.Background(), &bc); err != nil {
+		t.Errorf("membership: %v", err)
+	}
+}
+
+// Transactor that runs fn and then fails as a commit would, rolling it back
+type failingCommit struct {
+	db.Transactor
+}
+
+func (f failingCommit) WithTx(ctx context.Context, fn func(tx *db.Store) error) error {
+	return f.Transactor.WithTx(ctx, func(tx *db.Store) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return errors.New("commit failed")
+	})
+}
+
+// Invitation form of a new account with a profile photo
+func newAccountForm(t *testing.T, token string) (*bytes.Buffer, string) {
+	t.Helper()
+
+	var photo bytes.Buffer
+	if err := jpeg.Encode(&photo, image.NewRGBA(image.Rect(0, 0, 100, 100)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range map[string]string{
+		"token": token, "password": testPassword, "confirm_password": testPassword,
+		"middle_names": "Luis", "paternal_surname": "Pérez", "maternal_surname": "Gómez",
+	} {
+		mw.WriteField(k, v)
+	}
+	part, err := mw.CreateFormFile("file", "photo.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(photo.Bytes())
+	mw.Close()
+	return &body, mw.FormDataContentType()
+}
+
+// Files under the local upload directory
+func storedFiles(t *testing.T, a *Auth) []string {
+	t.Helper()
+
+	var files []string
+	dir := a.Uploads.Storage.(*storage.Local).Dir
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestAcceptInvitationPhoto(t *testing.T) {
+	tests := []struct {
+		name       string
+		failCommit bool
+	}{
+		{"stored once the account exists", false},
+		{"not stored when the transaction fails", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAuth(t)
+			token, _ := newTestInvitation(t, a, "luis@example.com")
+			if tt.failCommit {
+				a.Store.Transactor = failingCommit{a.Store.Transactor}
+			}
+
+			body, contentType := newAccountForm(t, token)
+			req := httptest.NewRequest(http.MethodPost, "/invitations/accept", body)
+			req.Header.Set("Content-Type", contentType)
+			rec := httptest.NewRecorder()
+			a.AcceptInvitation(rec, req)
+
+			u := db.User{Email: "luis@example.com"}
+			err := a.Store.Users.GetByEmail(context.Background(), &u)
+			files := storedFiles(t, a)
+
+			if tt.failCommit {
+				if !errors.Is(err, db.ErrUserNotFound) {
+					t.Errorf("account created: %v", err)
+				}
+				if len(files) != 0 {
+					t.Errorf("orphaned files %v", files)
+				}
+				return
+			}
+
+			if err != nil || u.PhotoKey == "" {
+				t.Fatalf("account %+v, %v: %s", u, err, rec.Body)
+			}
+			if _, err := a.Uploads.Open(context.Background(), u.PhotoKey); err != nil {
+				t.Errorf("photo not stored: %v", err)
+			}
+		})
+	}
+}
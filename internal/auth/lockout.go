@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/calmestend/mercado_lobito/pkg/env"
+)
+
+// How failed sign ins slow down further attempts. Every failure doubles the
+// wait before the next attempt, up to BackoffMax, and MaxFailures within
+// FailureWindow lock the account (or IP) out for Lockout.
+type LoginPolicy struct {
+	MaxFailures      int
+	MaxFailuresPerIP int
+	FailureWindow    time.Duration
+	Lockout          time.Duration
+	BackoffBase      time.Duration
+	BackoffMax       time.Duration
+	// Take the client IP from X-Forwarded-For, only safe behind a proxy
+	// that overwrites it
+	TrustProxy bool
+}
+
+func LoginPolicyFromEnv() LoginPolicy {
+	return LoginPolicy{
+		MaxFailures:      env.GetInt("LOGIN_MAX_FAILURES", 5),
+		MaxFailuresPerIP: env.GetInt("LOGIN_MAX_FAILURES_PER_IP", 20),
+		FailureWindow:    env.GetDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		Lockout:          env.GetDuration("LOGIN_LOCKOUT", 15*time.Minute),
+		BackoffBase:      env.GetDuration("LOGIN_BACKOFF_BASE", time.Second),
+		BackoffMax:       env.GetDuration("LOGIN_BACKOFF_MAX", 30*time.Second),
+		TrustProxy:       env.GetBool("TRUST_PROXY_HEADERS", false),
+	}
+}
+
+// Time left before another attempt is allowed and whether that's a lockout
+// rather than backoff
+func (p LoginPolicy) wait(f db.LoginFailures, maxFailures int, now time.Time) (time.Duration, bool) {
+	if f.Count == 0 {
+		return 0, false
+	}
+
+	if f.Count >= maxFailures {
+		return max(f.Last.Add(p.Lockout).Sub(now), 0), true
+	}
+
+	backoff := p.BackoffMax
+	if f.Count-1 < 32 {
+		backoff = min(p.BackoffBase<<(f.Count-1), p.BackoffMax)
+	}
+	return max(f.Last.Add(backoff).Sub(now), 0), false
+}
+
+// Longest wait the account and the IP are under
+func (a *Auth) loginWait(ctx context.Context, userID int, identifier, ip string) (time.Duration, bool, error) {
+	now := time.Now()
+	since := now.Add(-a.Login.FailureWindow)
+
+	account, err := a.Store.LoginEvents.AccountFailures(ctx, userID, identifier, since)
+	if err != nil {
+		return 0, false, err
+	}
+	byIP, err := a.Store.LoginEvents.IPFailures(ctx, ip, since)
+	if err != nil {
+		return 0, false, err
+	}
+
+	accountWait, accountLocked := a.Login.wait(account, a.Login.MaxFailures, now)
+	ipWait, ipLocked := a.Login.wait(byIP, a.Login.MaxFailuresPerIP, now)
+	if ipWait > accountWait {
+		return ipWait, ipLocked, nil
+	}
+	return accountWait, accountLocked, nil
+}
+
+// Check password for the account under identifier, u is nil when there is
+// none. Failures count towards the lockout and backoff, a positive wait means
+// the attempt wasn't allowed or used up the last one.
+func (a *Auth) checkLogin(r *http.Request, u *db.User, identifier, password string) (bool, time.Duration, bool, error) {
+	userID := 0
+	if u != nil {
+		userID = u.ID
+	}
+
+	wait, locked, err := a.loginWait(r.Context(), userID, identifier, a.clientIP(r))
+	if err != nil || wait > 0 {
+		return false, wait, locked, err
+	}
+
+	// Accounts that don't exist, or have no password, still cost a bcrypt
+	// comparison so timing doesn't tell them apart
+	known := u != nil && u.Hash != ""
+	hash := a.Passwords.dummyHash
+	if known {
+		hash = u.Hash
+	}
+	if VerifyPassword(hash, password) && known {
+		return true, 0, false, nil
+	}
+
+	if err := a.recordLogin(r.Context(), r, userID, identifier, false); err != nil {
+		log.Printf("Error recording failed sign in: %v", err)
+	}
+
+	// Let the user know right away when this attempt used up the last one
+	wait, locked, err = a.loginWait(r.Context(), userID, identifier, a.clientIP(r))
+	if err == nil && locked {
+		return false, wait, locked, nil
+	}
+	return false, 0, false, nil
+}
+
+func (a *Auth) recordLogin(ctx context.Context, r *http.Request, userID int, identifier string, success bool) error {
+	e := db.LoginEvent{
+		UserID:     userID,
+		Identifier: truncate(identifier, 150),
+		IP:         a.clientIP(r),
+		UserAgent:  truncate(r.UserAgent(), 255),
+		Success:    success,
+		CreatedAt:  time.Now(),
+	}
+	return a.Store.LoginEvents.Set(ctx, &e)
+}
+
+func (a *Auth) clientIP(r *http.Request) string {
+	if a.Login.TrustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
@@ -0,0 +1,379 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/internal/components"
+	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/calmestend/mercado_lobito/pkg/env"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcPurpose = "oidc-login"
+	// Cookie carrying state, nonce and PKCE verifier between the redirect to
+	// the provider and the callback
+	oidcCookieName = "oidc_flow"
+	oidcFlowTTL    = 10 * time.Minute
+)
+
+var (
+	ErrOIDCNoEmail       = errors.New("identity provider didn't share an email")
+	ErrOIDCEmailConflict = errors.New("an account already uses this email")
+)
+
+// OpenID Connect provider students can sign in with, disabled while
+// OIDC_ISSUER is empty. Point it at a local mock IdP to try it out.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// ID token claim holding the student ID, empty for none
+	StudentIDClaim string
+}
+
+func OIDCConfigFromEnv(baseURL string) OIDCConfig {
+	return OIDCConfig{
+		Issuer:         strings.TrimSuffix(env.GetEnvDefault("OIDC_ISSUER", ""), "/"),
+		ClientID:       env.GetEnvDefault("OIDC_CLIENT_ID", ""),
+		ClientSecret:   env.GetEnvDefault("OIDC_CLIENT_SECRET", ""),
+		RedirectURL:    env.GetEnvDefault("OIDC_REDIRECT_URL", baseURL+"/auth/oidc/callback"),
+		StudentIDClaim: env.GetEnvDefault("OIDC_STUDENT_ID_CLAIM", "student_id"),
+	}
+}
+
+// Provider metadata is discovered on first use, so the app starts even when
+// the provider is down
+type oidcClient struct {
+	config OIDCConfig
+	http   *http.Client
+
+	mu       sync.Mutex
+	oauth2   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+func newOIDCClient(config OIDCConfig) *oidcClient {
+	if config.Issuer == "" {
+		return nil
+	}
+	return &oidcClient{config: config, http: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (c *oidcClient) discover() (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.oauth2 != nil {
+		return c.oauth2, c.verifier, nil
+	}
+
+	// Not the request context, the key set keeps using it to refresh keys
+	provider, err := oidc.NewProvider(c.context(context.Background()), c.config.Issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.oauth2 = &oauth2.Config{
+		ClientID:     c.config.ClientID,
+		ClientSecret: c.config.ClientSecret,
+		RedirectURL:  c.config.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+	}
+	c.verifier = provider.Verifier(&oidc.Config{ClientID: c.config.ClientID})
+	return c.oauth2, c.verifier, nil
+}
+
+// ctx that makes go-oidc and oauth2 use the client with a timeout
+func (c *oidcClient) context(ctx context.Context) context.Context {
+	return oidc.ClientContext(ctx, c.http)
+}
+
+func (a *Auth) OIDCEnabled() bool {
+	return a.oidc != nil
+}
+
+// Send the browser to the provider
+func (a *Auth) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if a.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	config, _, err := a.oidc.discover()
+	if err != nil {
+		a.oidcFailed(w, r, "discovery", err)
+		return
+	}
+
+	state := newNonce()
+	nonce := newNonce()
+	verifier := oauth2.GenerateVerifier()
+
+	expiresAt := time.Now().Add(oidcFlowTTL)
+	flow := a.Signer.Sign(oidcPurpose, state+"."+nonce+"."+verifier, expiresAt)
+	cookie := a.Cookies.Cookie(oidcCookieName, flow, expiresAt)
+	// The callback is a navigation from the provider's site, a strict
+	// cookie wouldn't come back with it
+	cookie.SameSite = http.SameSiteLaxMode
+	http.SetCookie(w, cookie)
+
+	opts := []oauth2.AuthCodeOption{oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)}
+	// Confirming a change has to go through the provider's login again,
+	// not just its still open session
+	if r.URL.Query().Get("reauth") != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("prompt", "login"))
+	}
+	url := config.AuthCodeURL(state, opts...)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// Where the provider sends the browser back with an authorization code
+func (a *Auth) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if a.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcCookieName)
+	if err != nil {
+		a.oidcFailed(w, r, "missing flow cookie", err)
+		return
+	}
+	http.SetCookie(w, a.Cookies.Expired(oidcCookieName))
+
+	flow, err := a.Signer.Verify(oidcPurpose, cookie.Value, time.Now())
+	if err != nil {
+		a.oidcFailed(w, r, "flow cookie", err)
+		return
+	}
+	parts := strings.Split(flow, ".")
+	if len(parts) != 3 {
+		a.oidcFailed(w, r, "flow cookie", errors.New("malformed"))
+		return
+	}
+	state, nonce, verifier := parts[0], parts[1], parts[2]
+
+	if e := r.FormValue("error"); e != "" {
+		a.oidcFailed(w, r, "provider", fmt.Errorf("%s: %s", e, r.FormValue("error_description")))
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.FormValue("state")), []byte(state)) != 1 {
+		a.oidcFailed(w, r, "state", errors.New("mismatch"))
+		return
+	}
+
+	config, idVerifier, err := a.oidc.discover()
+	if err != nil {
+		a.oidcFailed(w, r, "discovery", err)
+		return
+	}
+
+	ctx := a.oidc.context(r.Context())
+	token, err := config.Exchange(ctx, r.FormValue("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		a.oidcFailed(w, r, "code exchange", err)
+		return
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		a.oidcFailed(w, r, "code exchange", errors.New("no id_token in response"))
+		return
+	}
+	idToken, err := idVerifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		a.oidcFailed(w, r, "id token", err)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		a.oidcFailed(w, r, "id token", errors.New("nonce mismatch"))
+		return
+	}
+
+	u, identifier, err := a.oidcUser(r.Context(), idToken)
+	if err != nil {
+		a.oidcFailed(w, r, "account", err)
+		return
+	}
+
+	// The provider stands in for the password, two-factor still applies
+	t, err := a.enabledTOTP(r.Context(), u.ID)
+	if err != nil {
+		a.oidcFailed(w, r, "two-factor", err)
+		return
+	}
+	if t != nil {
+		a.setTwoFactorPending(w, u.ID, identifier)
+		components.Redirect("/auth/2fa").Render(r.Context(), w)
+		return
+	}
+
+	if err := a.recordLogin(r.Context(), r, u.ID, identifier, true); err != nil {
+		log.Printf("Error recording sign in: %v", err)
+	}
+
+	session, err := a.CreateSession(r, u.ID)
+	if err != nil {
+		a.oidcFailed(w, r, "session", err)
+		return
+	}
+	a.setSessionCookie(w, session)
+
+	// Strict cookies set here aren't sent on a redirect that started on the
+	// provider's site, so leave through a page of our own
+	components.Redirect("/").Render(r.Context(), w)
+}
+
+func (a *Auth) oidcFailed(w http.ResponseWriter, r *http.Request, step string, err error) {
+	log.Printf("OIDC login failed at %s: %v", step, err)
+	http.Redirect(w, r, "/auth/login?error=oidc", http.StatusFound)
+}
+
+type oidcClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+}
+
+// User the ID token is for, along with the identifier to record the login
+// under. An unknown sub is linked to the student with the token's student ID,
+// then to the account with its verified email, and otherwise gets a new user.
+func (a *Auth) oidcUser(ctx context.Context, idToken *oidc.IDToken) (*db.User, string, error) {
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, "", err
+	}
+
+	studentID := ""
+	if a.oidc.config.StudentIDClaim != "" {
+		var all map[string]json.RawMessage
+		if err := idToken.Claims(&all); err != nil {
+			return nil, "", err
+		}
+		studentID = claimString(all[a.oidc.config.StudentIDClaim])
+	}
+
+	identifier := claims.Email
+	if studentID != "" {
+		identifier = studentID
+	}
+
+	identity := db.Identity{Issuer: idToken.Issuer, Subject: idToken.Subject}
+	err := a.Store.Identities.Get(ctx, &identity)
+	if err == nil {
+		u := db.User{ID: identity.UserID}
+		if err := a.Store.Users.GetByID(ctx, &u); err != nil {
+			return nil, "", err
+		}
+		return &u, identifier, nil
+	}
+	if !errors.Is(err, db.ErrIdentityNotFound) {
+		return nil, "", err
+	}
+
+	u := db.User{}
+	if studentID != "" {
+		s := db.Student{ID: studentID}
+		err := a.Store.Students.GetByID(ctx, &s)
+		if err == nil {
+			u.ID = s.UserID
+			if err := a.Store.Users.GetByID(ctx, &u); err != nil {
+				return nil, "", err
+			}
+		} else if !errors.Is(err, db.ErrStudentNotFound) {
+			return nil, "", err
+		}
+	}
+
+	if u.ID == 0 && claims.Email != "" {
+		existing := db.User{Email: claims.Email}
+		err := a.Store.Users.GetByEmail(ctx, &existing)
+		if err == nil {
+			if !claims.EmailVerified {
+				return nil, "", ErrOIDCEmailConflict
+			}
+			u = existing
+		} else if !errors.Is(err, db.ErrUserNotFound) {
+			return nil, "", err
+		}
+	}
+
+	if u.ID == 0 && claims.Email == "" {
+		return nil, "", ErrOIDCNoEmail
+	}
+
+	now := time.Now()
+	err = a.Store.WithTx(ctx, func(tx *db.Store) error {
+		if u.ID == 0 {
+			u.Email = claims.Email
+			u.MiddleNames, u.PaternalSurname, u.MaternalSurname = splitName(claims)
+			if claims.EmailVerified {
+				u.EmailVerifiedAt = &now
+			}
+			if err := tx.Users.Set(ctx, &u); err != nil {
+				return err
+			}
+
+			if studentID != "" {
+				if err := tx.Students.Set(ctx, &db.Student{ID: studentID, UserID: u.ID}); err != nil {
+					return err
+				}
+			}
+		} else if claims.EmailVerified && strings.EqualFold(u.Email, claims.Email) && u.EmailVerifiedAt == nil {
+			u.EmailVerifiedAt = &now
+			if err := tx.Users.SetEmailVerified(ctx, &u); err != nil {
+				return err
+			}
+		}
+
+		identity.UserID = u.ID
+		identity.CreatedAt = now
+		return tx.Identities.Set(ctx, &identity)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &u, identifier, nil
+}
+
+// Claim as text, providers send student IDs as strings or as numbers
+func claimString(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return strings.TrimSpace(s)
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(raw, &n); err == nil {
+		return n.String()
+	}
+	return ""
+}
+
+// Names, paternal and maternal surname, taking the first word of
+// family_name as the paternal one
+func splitName(c oidcClaims) (string, string, string) {
+	given, family := c.GivenName, c.FamilyName
+	if given == "" && family == "" {
+		given, family, _ = strings.Cut(strings.TrimSpace(c.Name), " ")
+	}
+
+	paternal, maternal, _ := strings.Cut(strings.TrimSpace(family), " ")
+	return given, paternal, strings.TrimSpace(maternal)
+}
@@ -0,0 +1,372 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/internal/db"
+)
+
+const testClientID = "mercado-lobito"
+
+// Code the mock provider hands out, with the PKCE challenge it was asked
+// for and the claims of the ID token it's exchanged for
+type mockGrant struct {
+	challenge string
+	claims    map[string]any
+}
+
+// Identity provider serving discovery, its key set and a token endpoint
+// that checks PKCE
+type mockIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]mockGrant
+	issued int
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdP{key: key, grants: map[string]mockGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                idp.URL,
+			"authorization_endpoint":                idp.URL + "/authorize",
+			"token_endpoint":                        idp.URL + "/token",
+			"jwks_uri":                              idp.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", idp.token)
+
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	idp.mu.Lock()
+	g, ok := idp.grants[r.PostForm.Get("code")]
+	delete(idp.grants, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idp.sign(g.claims),
+	})
+}
+
+// RS256 JWT with claims
+func (idp *mockIdP) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, sum[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// Auth signing in through a fresh mock provider
+func newTestOIDC(t *testing.T) (*Auth, *mockIdP) {
+	t.Helper()
+
+	a := newTestAuth(t)
+	idp := newMockIdP(t)
+	a.oidc = newOIDCClient(OIDCConfig{
+		Issuer:         idp.URL,
+		ClientID:       testClientID,
+		ClientSecret:   "secret",
+		RedirectURL:    "http://localhost/auth/oidc/callback",
+		StudentIDClaim: "student_id",
+	})
+	return a, idp
+}
+
+// Go through OIDCLogin, the provider and OIDCCallback with an ID token
+// carrying claims. tamper, when set, changes the callback's query or the
+// grant before the provider sees it.
+func (idp *mockIdP) signin(t *testing.T, a *Auth, claims map[string]any, tamper func(query url.Values, g *mockGrant)) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	a.OIDCLogin(rec, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(location.String(), idp.URL+"/authorize") {
+		t.Fatalf("not sent to the provider: %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	auth := location.Query()
+	var flow *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == oidcCookieName {
+			flow = c
+		}
+	}
+
+	now := time.Now()
+	g := mockGrant{challenge: auth.Get("code_challenge"), claims: map[string]any{
+		"iss":   idp.URL,
+		"aud":   testClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": auth.Get("nonce"),
+	}}
+	for k, v := range claims {
+		g.claims[k] = v
+	}
+
+	idp.mu.Lock()
+	idp.issued++
+	code := "code-" + strconv.Itoa(idp.issued)
+	idp.mu.Unlock()
+	query := url.Values{"code": {code}, "state": {auth.Get("state")}}
+	if tamper != nil {
+		tamper(query, &g)
+	}
+	idp.mu.Lock()
+	idp.grants[code] = g
+	idp.mu.Unlock()
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?"+query.Encode(), nil)
+	req.AddCookie(flow)
+	rec = httptest.NewRecorder()
+	a.OIDCCallback(rec, req)
+	return rec
+}
+
+// User the session cookie set by rec belongs to, nil when there's none
+func sessionUser(t *testing.T, a *Auth, rec *httptest.ResponseRecorder) *db.User {
+	t.Helper()
+
+	cookie := sessionCookie(rec)
+	if cookie == nil {
+		return nil
+	}
+	session, err := a.GetSession(context.Background(), cookie.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := db.User{ID: session.UserID}
+	if err := a.Store.Users.GetByID(context.Background(), &u); err != nil {
+		t.Fatal(err)
+	}
+	return &u
+}
+
+func TestOIDCCallback(t *testing.T) {
+	claims := map[string]any{
+		"sub":            "sub-1",
+		"email":          "nuevo@example.com",
+		"email_verified": true,
+		"given_name":     "Luis",
+		"family_name":    "Pérez Gómez",
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(query url.Values, g *mockGrant)
+	}{
+		{"state mismatch", func(query url.Values, g *mockGrant) { query.Set("state", newNonce()) }},
+		{"missing state", func(query url.Values, g *mockGrant) { query.Del("state") }},
+		{"pkce mismatch", func(query url.Values, g *mockGrant) {
+			sum := sha256.Sum256([]byte("someone else's verifier"))
+			g.challenge = base64.RawURLEncoding.EncodeToString(sum[:])
+		}},
+		{"nonce mismatch", func(query url.Values, g *mockGrant) { g.claims["nonce"] = newNonce() }},
+		{"another audience", func(query url.Values, g *mockGrant) { g.claims["aud"] = "another-app" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, idp := newTestOIDC(t)
+
+			rec := idp.signin(t, a, claims, tt.tamper)
+			if rec.Header().Get("Location") != "/auth/login?error=oidc" || sessionCookie(rec) != nil {
+				t.Errorf("signed in: %d %s", rec.Code, rec.Header().Get("Location"))
+			}
+			if err := a.Store.Users.GetByEmail(context.Background(), &db.User{Email: "nuevo@example.com"}); !errors.Is(err, db.ErrUserNotFound) {
+				t.Errorf("account created: %v", err)
+			}
+		})
+	}
+
+	t.Run("new account", func(t *testing.T) {
+		a, idp := newTestOIDC(t)
+
+		u := sessionUser(t, a, idp.signin(t, a, claims, nil))
+		if u == nil {
+			t.Fatal("not signed in")
+		}
+		if u.Email != "nuevo@example.com" || u.Hash != "" || u.EmailVerifiedAt == nil {
+			t.Errorf("user = %+v", u)
+		}
+		if u.MiddleNames != "Luis" || u.PaternalSurname != "Pérez" || u.MaternalSurname != "Gómez" {
+			t.Errorf("names = %q %q %q", u.MiddleNames, u.PaternalSurname, u.MaternalSurname)
+		}
+	})
+}
+
+func TestOIDCLinking(t *testing.T) {
+	a, idp := newTestOIDC(t)
+	existing := newTestUser(t, a, "Ana@Example.com", "")
+
+	claims := map[string]any{"sub": "sub-ana", "email": "ana@example.com", "email_verified": false}
+
+	// An unverified email could be anyone's
+	rec := idp.signin(t, a, claims, nil)
+	if sessionCookie(rec) != nil {
+		t.Fatal("signed in to an account through an unverified email")
+	}
+	identity := db.Identity{Issuer: idp.URL, Subject: "sub-ana"}
+	if err := a.Store.Identities.Get(context.Background(), &identity); !errors.Is(err, db.ErrIdentityNotFound) {
+		t.Fatalf("identity linked: %v", err)
+	}
+
+	claims["email_verified"] = true
+	u := sessionUser(t, a, idp.signin(t, a, claims, nil))
+	if u == nil || u.ID != existing.ID {
+		t.Fatalf("signed in as %+v, want the account with the email in another case", u)
+	}
+	if u.EmailVerifiedAt == nil {
+		t.Error("email not marked verified")
+	}
+
+	// Once linked the sub is enough, whatever email comes along
+	claims["email"] = "ana.lopez@example.com"
+	if u := sessionUser(t, a, idp.signin(t, a, claims, nil)); u == nil || u.ID != existing.ID {
+		t.Errorf("linked identity signed in as %+v", u)
+	}
+}
+
+// Accounts that only sign in through the provider confirm changes with a
+// recent sign in instead of a password
+func TestOIDCReauth(t *testing.T) {
+	a, idp := newTestOIDC(t)
+	u := sessionUser(t, a, idp.signin(t, a, map[string]any{"sub": "sub-1", "email": "luis@example.com", "email_verified": true}, nil))
+	if u == nil {
+		t.Fatal("not signed in")
+	}
+
+	t.Run("disable two-factor", func(t *testing.T) {
+		_, recoveryCodes := enrollTwoFactor(t, a, u)
+		disable := func() *httptest.ResponseRecorder {
+			return postFormAs(t, a, u, a.DisableTwoFactor, "/auth/2fa/disable", url.Values{"code": {recoveryCodes[0]}})
+		}
+
+		maxAge := a.ReauthMaxAge
+		a.ReauthMaxAge = 0
+		if rec := disable(); !strings.Contains(rec.Body.String(), "/auth/oidc/login?reauth=1") {
+			t.Errorf("stale session: %d %s", rec.Code, rec.Body)
+		}
+		a.ReauthMaxAge = maxAge
+
+		disable()
+		if enabled, _, err := a.TwoFactorStatus(context.Background(), u.ID); err != nil || enabled {
+			t.Errorf("still enabled after a fresh sign in: %v", err)
+		}
+	})
+
+	t.Run("accept invitation", func(t *testing.T) {
+		token, inv := newTestInvitation(t, a, u.Email)
+		other := newTestUser(t, a, "ana@example.com", "")
+
+		accept := func(as *db.User) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, "/invitations/accept", strings.NewReader(url.Values{"token": {token}}.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if as != nil {
+				session, err := a.CreateSession(req, as.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.AddCookie(&http.Cookie{Name: "session", Value: session.UUID})
+			}
+			rec := httptest.NewRecorder()
+			a.AcceptInvitation(rec, req)
+			return rec
+		}
+		member := func() bool {
+			bc := db.BusinessCollaborator{BusinessID: inv.BusinessID, CollaboratorID: u.ID}
+			err := a.Store.BusinessCollaborators.GetByBusinessAndCollaborator(context.Background(), &bc)
+			if err != nil && !errors.Is(err, db.ErrCollaboratorNotFound) {
+				t.Fatal(err)
+			}
+			return err == nil
+		}
+
+		for name, as := range map[string]*db.User{"signed out": nil, "someone else's session": other} {
+			if rec := accept(as); !strings.Contains(rec.Body.String(), "/auth/oidc/login?reauth=1") || member() {
+				t.Errorf("%s: %d %s", name, rec.Code, rec.Body)
+			}
+		}
+
+		if rec := accept(u); !member() {
+			t.Errorf("not accepted from a fresh sign in: %d %s", rec.Code, rec.Body)
+		}
+	})
+}
+
+func TestOIDCLoginReauth(t *testing.T) {
+	a, _ := newTestOIDC(t)
+
+	for target, want := range map[string]string{"/auth/oidc/login": "", "/auth/oidc/login?reauth=1": "login"} {
+		rec := httptest.NewRecorder()
+		a.OIDCLogin(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		location, err := url.Parse(rec.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := location.Query().Get("prompt"); got != want {
+			t.Errorf("%s: prompt = %q, want %q", target, got, want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/calmestend/mercado_lobito/pkg/env"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcrypt ignores anything past 72 bytes
+const maxPasswordBytes = 72
+
+var (
+	ErrPasswordTooLong      = fmt.Errorf("Password must be at most %d bytes", maxPasswordBytes)
+	ErrPasswordBreached     = errors.New("This password appears in known data breaches, choose another one")
+	ErrPasswordHasStudentID = errors.New("Password can't contain your student ID")
+)
+
+// What a new password has to meet and how it is hashed. Hashes made at a
+// lower Cost are upgraded the next time their owner signs in.
+type PasswordPolicy struct {
+	MinLength int
+	Cost      int
+	// Lowercased passwords from the list at PASSWORD_BREACHED_LIST
+	breached map[string]struct{}
+	// Hash of a random password at Cost, sign ins for accounts that don't
+	// exist are checked against it so they take as long to refuse
+	dummyHash string
+}
+
+func PasswordPolicyFromEnv() PasswordPolicy {
+	p := PasswordPolicy{
+		MinLength: env.GetInt("PASSWORD_MIN_LENGTH", 10),
+		Cost:      min(max(env.GetInt("BCRYPT_COST", 12), bcrypt.MinCost), bcrypt.MaxCost),
+	}
+
+	dummy, err := bcrypt.GenerateFromPassword([]byte(rand.Text()), p.Cost)
+	if err != nil {
+		log.Fatalf("Error %s when hashing the dummy password\n", err)
+	}
+	p.dummyHash = string(dummy)
+
+	if path := env.GetEnvDefault("PASSWORD_BREACHED_LIST", ""); path != "" {
+		// Running without the check would let breached passwords through
+		// unnoticed
+		breached, err := loadBreachedPasswords(path)
+		if err != nil {
+			log.Fatalf("Error %s when loading PASSWORD_BREACHED_LIST\n", err)
+		}
+		p.breached = breached
+	}
+
+	return p
+}
+
+// One password per line, blank lines and lines starting with # are skipped
+func loadBreachedPasswords(path string) (map[string]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	breached := map[string]struct{}{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		breached[strings.ToLower(line)] = struct{}{}
+	}
+	return breached, scanner.Err()
+}
+
+// Why password can't be used, nil when it can. studentID may be empty.
+func (p PasswordPolicy) Check(password, studentID string) error {
+	if len([]rune(password)) < p.MinLength {
+		return fmt.Errorf("Password must be at least %d characters", p.MinLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return ErrPasswordTooLong
+	}
+	if studentID != "" && strings.Contains(password, studentID) {
+		return ErrPasswordHasStudentID
+	}
+	if _, ok := p.breached[strings.ToLower(password)]; ok {
+		return ErrPasswordBreached
+	}
+	return nil
+}
+
+func (a *Auth) HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), a.Passwords.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Whether hash was made at a lower cost than the policy asks for now
+func (a *Auth) needsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err == nil && cost < a.Passwords.Cost
+}
+
+// Store a fresh hash of the password the user just signed in with. Failing
+// only means trying again next time, so it doesn't stop the sign in.
+func (a *Auth) rehashPassword(ctx context.Context, u *db.User, password string) {
+	hash, err := a.HashPassword(password)
+	if err != nil {
+		log.Printf("Error rehashing password for user %d: %v", u.ID, err)
+		return
+	}
+
+	u.Hash = hash
+	if err := a.Store.Users.Update(ctx, u); err != nil {
+		log.Printf("Error rehashing password for user %d: %v", u.ID, err)
+	}
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestPasswordPolicyBreachedList(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(list, []byte("# common ones\nPassword1234\n\n  qwertyuiop  \n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BCRYPT_COST", "4")
+	t.Setenv("PASSWORD_BREACHED_LIST", list)
+	p := PasswordPolicyFromEnv()
+
+	tests := []struct {
+		password string
+		want     error
+	}{
+		{"password1234", ErrPasswordBreached},
+		{"QWERTYUIOP", ErrPasswordBreached},
+		{"# common ones", nil},
+		{testPassword, nil},
+	}
+	for _, tt := range tests {
+		if err := p.Check(tt.password, ""); !errors.Is(err, tt.want) {
+			t.Errorf("Check(%q) = %v, want %v", tt.password, err, tt.want)
+		}
+	}
+}
+
+// log.Fatalf ends the process, so the policy is built in a child test run
+func TestPasswordPolicyUnreadableBreachedList(t *testing.T) {
+	if os.Getenv("TEST_BREACHED_LIST_CHILD") == "1" {
+		PasswordPolicyFromEnv()
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestPasswordPolicyUnreadableBreachedList$")
+	cmd.Env = append(os.Environ(),
+		"TEST_BREACHED_LIST_CHILD=1",
+		"BCRYPT_COST=4",
+		"PASSWORD_BREACHED_LIST="+filepath.Join(t.TempDir(), "missing.txt"),
+	)
+	out, err := cmd.CombinedOutput()
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("policy built without its breached list, err = %v:\n%s", err, out)
+	}
+}
@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/internal/components"
+	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/calmestend/mercado_lobito/internal/mail"
+)
+
+var ErrInvalidPasswordReset = errors.New("invalid or expired password reset")
+
+// Random token to email and the hash to store in its place
+func newOpaqueToken() (token string, hash string) {
+	b := make([]byte, 32)
+	rand.Read(b)
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Same answer whether the account exists or not, so the form can't be used
+// to find out who is registered
+const passwordResetRequested = "Si la cuenta existe, enviamos un enlace para restablecer la contraseña a su correo."
+
+func (a *Auth) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		components.PasswordResetResponse(false, "Error processing form").Render(r.Context(), w)
+		return
+	}
+
+	identifier := strings.TrimSpace(r.FormValue("identifier"))
+	if identifier == "" {
+		components.PasswordResetResponse(false, "Student ID or email is required").Render(r.Context(), w)
+		return
+	}
+
+	// Throttled before the lookup so unknown identifiers are limited the
+	// same. Both limits are checked before either is used up, a request the
+	// IP limit turns away doesn't cost the identifier its turn.
+	now := time.Now()
+	key, ip := strings.ToLower(identifier), a.clientIP(r)
+	if wait := max(a.passwordResetsByIdentifier.wait(key, now), a.passwordResetsByIP.wait(ip, now)); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		w.WriteHeader(http.StatusTooManyRequests)
+		message := fmt.Sprintf("Espera %s antes de pedir otro correo", wait.Round(time.Second))
+		components.PasswordResetResponse(false, message).Render(r.Context(), w)
+		return
+	}
+	a.passwordResetsByIdentifier.record(key, now)
+	a.passwordResetsByIP.record(ip, now)
+
+	u, err := a.lookupUser(r.Context(), identifier)
+	if err != nil {
+		if !errors.Is(err, db.ErrUserNotFound) {
+			log.Printf("Error looking up %q for password reset: %v", identifier, err)
+		}
+		components.PasswordResetResponse(true, passwordResetRequested).Render(r.Context(), w)
+		return
+	}
+
+	if err := a.sendPasswordReset(r.Context(), u); err != nil {
+		// Not told apart from an unknown account
+		log.Printf("Error sending password reset to user %d: %v", u.ID, err)
+	}
+
+	components.PasswordResetResponse(true, passwordResetRequested).Render(r.Context(), w)
+}
+
+func (a *Auth) sendPasswordReset(ctx context.Context, u *db.User) error {
+	token, hash := newOpaqueToken()
+
+	pr := db.PasswordReset{
+		UserID:    u.ID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(a.PasswordResetTTL),
+	}
+	if err := a.Store.PasswordResets.Set(ctx, &pr); err != nil {
+		return err
+	}
+
+	link := a.BaseURL + "/auth/reset?token=" + url.QueryEscape(token)
+
+	return a.Mailer.Send(ctx, mail.Message{
+		To:      u.Email,
+		Subject: "Restablecer contraseña",
+		Body: fmt.Sprintf(
+			"Recibimos una solicitud para restablecer la contraseña de tu cuenta de Mercado Lobito.\n\n"+
+				"Abre este enlace para elegir una nueva:\n%s\n\n"+
+				"El enlace se puede usar una sola vez y expira el %s.\n"+
+				"Si no lo pediste, ignora este correo.\n",
+			link, pr.ExpiresAt.Format("02/01/2006 15:04"),
+		),
+	})
+}
+
+// Unused, unexpired reset a token was issued for
+func (a *Auth) PasswordResetFromToken(ctx context.Context, token string) (*db.PasswordReset, error) {
+	if token == "" {
+		return nil, ErrInvalidPasswordReset
+	}
+
+	pr := db.PasswordReset{TokenHash: hashToken(token)}
+	if err := a.Store.PasswordResets.GetValid(ctx, &pr, time.Now()); err != nil {
+		if errors.Is(err, db.ErrPasswordResetNotFound) {
+			return nil, ErrInvalidPasswordReset
+		}
+		return nil, err
+	}
+	return &pr, nil
+}
+
+// Set the new password, use up the user's reset tokens and sign them out
+// everywhere. Their API tokens are revoked too, whoever reset the password
+// because the account was compromised may not know which ones leaked.
+func (a *Auth) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		components.PasswordResetResponse(false, "Error processing form").Render(r.Context(), w)
+		return
+	}
+
+	password := r.FormValue("password")
+	if password == "" {
+		components.PasswordResetResponse(false, "Password is required").Render(r.Context(), w)
+		return
+	}
+	if password != r.FormValue("confirm_password") {
+		components.PasswordResetResponse(false, "Passwords don't match").Render(r.Context(), w)
+		return
+	}
+
+	pr, err := a.PasswordResetFromToken(r.Context(), r.FormValue("token"))
+	if err != nil {
+		components.PasswordResetResponse(false, "Invalid or expired link").Render(r.Context(), w)
+		return
+	}
+
+	student := db.Student{UserID: pr.UserID}
+	if err := a.Store.Students.GetByUserID(r.Context(), &student); err != nil && !errors.Is(err, db.ErrStudentNotFound) {
+		components.PasswordResetResponse(false, "Error resetting password").Render(r.Context(), w)
+		return
+	}
+	if err := a.Passwords.Check(password, student.ID); err != nil {
+		components.PasswordResetResponse(false, err.Error()).Render(r.Context(), w)
+		return
+	}
+
+	hash, err := a.HashPassword(password)
+	if err != nil {
+		components.PasswordResetResponse(false, "Error resetting password").Render(r.Context(), w)
+		return
+	}
+
+	err = a.Store.WithTx(r.Context(), func(tx *db.Store) error {
+		// Marking it first makes a second request with the same token fail
+		if err := tx.PasswordResets.MarkUsed(r.Context(), pr, time.Now()); err != nil {
+			return err
+		}
+		// Any other link sent earlier could undo this reset
+		if err := tx.PasswordResets.MarkUsedByUserID(r.Context(), pr.UserID, time.Now()); err != nil {
+			return err
+		}
+
+		u := db.User{ID: pr.UserID}
+		if err := tx.Users.GetByID(r.Context(), &u); err != nil {
+			return err
+		}
+
+		u.Hash = hash
+		if err := tx.Users.Update(r.Context(), &u); err != nil {
+			return err
+		}
+
+		if err := tx.APITokens.DeleteByUserID(r.Context(), u.ID); err != nil {
+			return err
+		}
+		return tx.Sessions.DeleteByUserID(r.Context(), u.ID)
+	})
+	if errors.Is(err, db.ErrPasswordResetNotFound) {
+		components.PasswordResetResponse(false, "Invalid or expired link").Render(r.Context(), w)
+		return
+	}
+	if err != nil {
+		components.PasswordResetResponse(false, "Error resetting password").Render(r.Context(), w)
+		return
+	}
+
+	a.clearSessionCookie(w)
+
+	w.Header().Set("HX-Redirect", "/auth/login")
+	w.WriteHeader(http.StatusOK)
+}
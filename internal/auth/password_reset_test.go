@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/internal/mail"
+)
+
+// Mailer recording what it's asked to send, failing with err when set
+type fakeMailer struct {
+	sent []mail.Message
+	err  error
+}
+
+func (m *fakeMailer) Send(ctx context.Context, msg mail.Message) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func TestRequestPasswordResetUniform(t *testing.T) {
+	tests := []struct {
+		name       string
+		identifier string
+		sendErr    error
+		wantSent   int
+	}{
+		{"existing account", "ana@example.com", nil, 1},
+		{"unknown account", "nobody@example.com", nil, 0},
+		{"sending fails", "ana@example.com", errors.New("smtp down"), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAuth(t)
+			mailer := &fakeMailer{err: tt.sendErr}
+			a.Mailer = mailer
+			newTestUser(t, a, "ana@example.com", "")
+
+			rec := postForm(a.RequestPasswordReset, "/auth/forgot", url.Values{"identifier": {tt.identifier}})
+			if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), passwordResetRequested) {
+				t.Errorf("response = %d %s, want the uniform answer", rec.Code, rec.Body)
+			}
+			if len(mailer.sent) != tt.wantSent {
+				t.Errorf("sent %d emails, want %d", len(mailer.sent), tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestRequestPasswordResetThrottle(t *testing.T) {
+	a := newTestAuth(t)
+	mailer := &fakeMailer{}
+	a.Mailer = mailer
+	a.passwordResetsByIP = newThrottle(3, time.Hour)
+	newTestUser(t, a, "ana@example.com", "")
+	newTestUser(t, a, "luis@example.com", "")
+
+	request := func(identifier, remoteAddr string) *httptest.ResponseRecorder {
+		return postFormFrom(a.RequestPasswordReset, "/auth/forgot", remoteAddr, url.Values{"identifier": {identifier}})
+	}
+	throttled := func(t *testing.T, rec *httptest.ResponseRecorder) {
+		t.Helper()
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+		}
+		if rec.Header().Get("Retry-After") == "" {
+			t.Error("missing Retry-After")
+		}
+	}
+
+	t.Run("same identifier", func(t *testing.T) {
+		if rec := request("ana@example.com", "192.0.2.1:1234"); rec.Code != http.StatusOK {
+			t.Fatalf("first request: status = %d", rec.Code)
+		}
+		// From somewhere else and in another case
+		throttled(t, request("ANA@example.com", "192.0.2.2:1234"))
+	})
+
+	t.Run("shared ip", func(t *testing.T) {
+		// Different people behind the same address get through up to the limit
+		for _, identifier := range []string{"nobody@example.com", "someone@example.com"} {
+			if rec := request(identifier, "192.0.2.1:1234"); rec.Code != http.StatusOK {
+				t.Fatalf("%s: status = %d", identifier, rec.Code)
+			}
+		}
+		throttled(t, request("luis@example.com", "192.0.2.1:1234"))
+
+		// Turned away by the IP limit, luis still has their turn
+		if rec := request("luis@example.com", "192.0.2.3:1234"); rec.Code != http.StatusOK {
+			t.Errorf("identifier used up by a request the IP limit refused: status = %d", rec.Code)
+		}
+	})
+
+	if len(mailer.sent) != 2 {
+		t.Errorf("sent %d emails, want 2", len(mailer.sent))
+	}
+}
+
+// Token out of the link in a password reset email
+func resetToken(t *testing.T, msg mail.Message) string {
+	t.Helper()
+
+	_, link, ok := strings.Cut(msg.Body, "token=")
+	if !ok {
+		t.Fatalf("no token in %q", msg.Body)
+	}
+	token, err := url.QueryUnescape(strings.Fields(link)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestResetPasswordInvalidatesOtherLinks(t *testing.T) {
+	a := newTestAuth(t)
+	mailer := &fakeMailer{}
+	a.Mailer = mailer
+	u := newTestUser(t, a, "ana@example.com", "")
+
+	// Two links asked for a while apart, the older one leaked
+	for range 2 {
+		if err := a.sendPasswordReset(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	older, newer := resetToken(t, mailer.sent[0]), resetToken(t, mailer.sent[1])
+
+	reset := func(token, password string) *httptest.ResponseRecorder {
+		return postForm(a.ResetPassword, "/auth/password/reset", url.Values{
+			"token": {token}, "password": {password}, "confirm_password": {password},
+		})
+	}
+
+	if rec := reset(newer, "a brand new secret"); rec.Header().Get("HX-Redirect") != "/auth/login" {
+		t.Fatalf("reset failed: %d %s", rec.Code, rec.Body)
+	}
+
+	for name, token := range map[string]string{"older link": older, "same link again": newer} {
+		rec := reset(token, "the attacker's pick")
+		if rec.Header().Get("HX-Redirect") != "" || !strings.Contains(rec.Body.String(), "Invalid or expired link") {
+			t.Errorf("%s still works: %d %s", name, rec.Code, rec.Body)
+		}
+	}
+
+	rec := postForm(a.Signin, "/auth/signin", url.Values{"identifier": {"ana@example.com"}, "password": {"a brand new secret"}})
+	if sessionCookie(rec) == nil {
+		t.Errorf("can't sign in with the reset password: %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"context"
+	"log"
+
+	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/calmestend/mercado_lobito/internal/uploads"
+)
+
+// Store a staged photo under a fresh key and point the user at it. The old
+// photo is removed once nothing refers to it, and browsers holding its URL
+// can't see the new one.
+func (a *Auth) ReplacePhoto(ctx context.Context, u *db.User, staged *uploads.Staged) error {
+	key := uploads.NewKey("photos")
+	if err := a.Uploads.Commit(ctx, staged, key); err != nil {
+		a.Uploads.Remove(ctx, key)
+		return err
+	}
+
+	old := u.PhotoKey
+	u.PhotoKey = key
+	if err := a.Store.Users.SetPhotoKey(ctx, u); err != nil {
+		u.PhotoKey = old
+		a.Uploads.Remove(ctx, key)
+		return err
+	}
+
+	if old != "" {
+		if err := a.Uploads.Remove(ctx, old); err != nil {
+			log.Printf("Error removing old photo of user %d: %v", u.ID, err)
+		}
+	}
+	return nil
+}
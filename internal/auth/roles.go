@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/calmestend/mercado_lobito/internal/db"
+)
+
+type Role string
+
+const (
+	// Student that owns a business
+	RoleStudentOwner Role = "student_owner"
+	// Member of someone else's business through businesses_collaborators
+	RoleCollaborator Role = "collaborator"
+	// User listed in the admins table
+	RoleAdmin Role = "admin"
+	// Faculty advisor, not assigned to anyone yet
+	RoleAdvisor Role = "advisor"
+)
+
+// Who is making the request, resolved once by AuthMiddleware
+type Principal struct {
+	UserID int
+	// Nil when the request came with an API token
+	Session *db.Session
+	// Set instead of Session for API token requests
+	Token *db.APIToken
+	// Unverified accounts can't create a business
+	EmailVerified bool
+	// Signing in takes a code from an authenticator app
+	TwoFactorEnabled bool
+	// Empty when the user isn't a student
+	StudentID string
+	// Zero when the user doesn't own a business
+	OwnedBusinessID int
+	// Businesses the user collaborates in
+	MemberOf []db.BusinessCollaborator
+	Roles    []Role
+}
+
+func (p *Principal) HasRole(roles ...Role) bool {
+	for _, role := range roles {
+		if slices.Contains(p.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// Owner or collaborator of the business
+func (p *Principal) IsBusinessMember(businessID int) bool {
+	if businessID == 0 {
+		return false
+	}
+	if p.OwnedBusinessID == businessID {
+		return true
+	}
+	for _, m := range p.MemberOf {
+		if m.BusinessID == businessID {
+			return true
+		}
+	}
+	return false
+}
+
+type principalContextKey struct{}
+
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalContextKey{}).(*Principal)
+	return p, ok
+}
+
+func (a *Auth) resolvePrincipal(ctx context.Context, userID int) (*Principal, error) {
+	p := &Principal{UserID: userID}
+
+	u := db.User{ID: userID}
+	if err := a.Store.Users.GetByID(ctx, &u); err != nil {
+		return nil, err
+	}
+	p.EmailVerified = u.EmailVerifiedAt != nil
+
+	t, err := a.enabledTOTP(ctx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	p.TwoFactorEnabled = t != nil
+
+	student := db.Student{UserID: userID}
+	err = a.Store.Students.GetByUserID(ctx, &student)
+	if err != nil && !errors.Is(err, db.ErrStudentNotFound) {
+		return nil, err
+	}
+
+	if err == nil {
+		p.StudentID = student.ID
+
+		business := db.Business{OwnerID: student.ID}
+		err := a.Store.Businesses.GetByOwnerID(ctx, &business)
+		if err != nil && !errors.Is(err, db.ErrBusinessNotFound) {
+			return nil, err
+		}
+		if err == nil {
+			p.OwnedBusinessID = business.ID
+			p.Roles = append(p.Roles, RoleStudentOwner)
+		}
+	}
+
+	p.MemberOf, err = a.Store.BusinessCollaborators.GetByCollaboratorID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.MemberOf) > 0 {
+		p.Roles = append(p.Roles, RoleCollaborator)
+	}
+
+	admin := db.Admin{UserID: userID}
+	err = a.Store.Admins.GetByUserID(ctx, &admin)
+	if err != nil && !errors.Is(err, db.ErrAdminNotFound) {
+		return nil, err
+	}
+	if err == nil {
+		p.Roles = append(p.Roles, RoleAdmin)
+	}
+
+	return p, nil
+}
+
+// Only let through principals with at least one of the roles. Must run
+// inside AuthMiddleware.
+func (a *Auth) RequireRole(roles ...Role) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			p, ok := PrincipalFromContext(r.Context())
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if !p.HasRole(roles...) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next(w, r)
+		}
+	}
+}
+
+// Only let through owners and collaborators. When the request names a
+// business_id the principal must belong to that one. Must run inside
+// AuthMiddleware.
+func (a *Auth) RequireBusinessMember(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if idVal := r.FormValue("business_id"); idVal != "" {
+			businessID, err := strconv.Atoi(idVal)
+			if err != nil {
+				http.Error(w, "Bad business ID", http.StatusBadRequest)
+				return
+			}
+			if !p.IsBusinessMember(businessID) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+		} else if !p.HasRole(RoleStudentOwner, RoleCollaborator) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/calmestend/mercado_lobito/internal/db"
+)
+
+// Handler answering 204 to whatever gets past the middleware
+func noContent(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Owner, collaborators at each permission, an admin and a user with no
+// role, all around one business
+type roleFixture struct {
+	business db.Business
+	owner    *db.User
+	members  map[db.Permission]*db.User
+	admin    *db.User
+	outsider *db.User
+}
+
+func newRoleFixture(t *testing.T, a *Auth) *roleFixture {
+	t.Helper()
+	ctx := context.Background()
+
+	f := &roleFixture{
+		owner:    newTestUser(t, a, "owner@example.com", "1001"),
+		members:  map[db.Permission]*db.User{},
+		admin:    newTestUser(t, a, "admin@example.com", ""),
+		outsider: newTestUser(t, a, "outsider@example.com", "1002"),
+	}
+
+	f.business = db.Business{Name: "Tacos", OwnerID: "1001"}
+	if err := a.Store.Businesses.Set(ctx, &f.business); err != nil {
+		t.Fatal(err)
+	}
+	for _, permission := range []db.Permission{db.PermissionReadOnly, db.PermissionManageProducts, db.PermissionManageTeam} {
+		u := newTestUser(t, a, string(permission)+"@example.com", "")
+		bc := db.BusinessCollaborator{BusinessID: f.business.ID, CollaboratorID: u.ID, Permission: permission}
+		if err := a.Store.BusinessCollaborators.Set(ctx, &bc); err != nil {
+			t.Fatal(err)
+		}
+		f.members[permission] = u
+	}
+	if err := a.Store.Admins.Set(ctx, &db.Admin{UserID: f.admin.ID}); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestRequireRole(t *testing.T) {
+	a := newTestAuth(t)
+	f := newRoleFixture(t, a)
+	adminOnly := a.RequireRole(RoleAdmin)(noContent)
+	membersOnly := a.RequireRole(RoleStudentOwner, RoleCollaborator)(noContent)
+
+	tests := []struct {
+		name string
+		user *db.User
+		h    http.HandlerFunc
+		want int
+	}{
+		{"admin on admin route", f.admin, adminOnly, http.StatusNoContent},
+		{"owner on admin route", f.owner, adminOnly, http.StatusForbidden},
+		{"collaborator on admin route", f.members[db.PermissionManageTeam], adminOnly, http.StatusForbidden},
+		{"outsider on admin route", f.outsider, adminOnly, http.StatusForbidden},
+		{"owner on members route", f.owner, membersOnly, http.StatusNoContent},
+		{"collaborator on members route", f.members[db.PermissionReadOnly], membersOnly, http.StatusNoContent},
+		{"admin on members route", f.admin, membersOnly, http.StatusForbidden},
+		{"outsider on members route", f.outsider, membersOnly, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := postFormAs(t, a, tt.user, tt.h, "/", nil); rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+
+	// Outside AuthMiddleware there is nobody to check
+	rec := httptest.NewRecorder()
+	adminOnly(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("without a principal: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestRequireTwoFactor(t *testing.T) {
+	a := newTestAuth(t)
+	f := newRoleFixture(t, a)
+	h := a.RequireTwoFactor(noContent)
+
+	rec := postFormAs(t, a, f.admin, h, "/", nil)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/profile/config" {
+		t.Fatalf("admin without two-factor: %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	// Roles that don't require it go through without enrolling
+	if rec := postFormAs(t, a, f.owner, h, "/", nil); rec.Code != http.StatusNoContent {
+		t.Errorf("owner: status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	enrollTwoFactor(t, a, f.admin)
+	if rec := postFormAs(t, a, f.admin, h, "/", nil); rec.Code != http.StatusNoContent {
+		t.Errorf("enrolled admin: status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+}
+
+func TestResolveBusinessPermissions(t *testing.T) {
+	a := newTestAuth(t)
+	f := newRoleFixture(t, a)
+	form := url.Values{"business_id": {strconv.Itoa(f.business.ID)}}
+
+	requiring := func(required db.Permission) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if _, err := a.ResolveBusiness(r, required); err != nil {
+				BusinessError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		user     *db.User
+		required db.Permission
+		want     int
+	}{
+		{"read only reads", f.members[db.PermissionReadOnly], db.PermissionReadOnly, http.StatusNoContent},
+		{"read only manages products", f.members[db.PermissionReadOnly], db.PermissionManageProducts, http.StatusForbidden},
+		{"read only manages team", f.members[db.PermissionReadOnly], db.PermissionManageTeam, http.StatusForbidden},
+		{"product manager reads", f.members[db.PermissionManageProducts], db.PermissionReadOnly, http.StatusNoContent},
+		{"product manager manages products", f.members[db.PermissionManageProducts], db.PermissionManageProducts, http.StatusNoContent},
+		{"product manager manages team", f.members[db.PermissionManageProducts], db.PermissionManageTeam, http.StatusForbidden},
+		{"team manager reads", f.members[db.PermissionManageTeam], db.PermissionReadOnly, http.StatusNoContent},
+		{"team manager manages products", f.members[db.PermissionManageTeam], db.PermissionManageProducts, http.StatusNoContent},
+		{"team manager manages team", f.members[db.PermissionManageTeam], db.PermissionManageTeam, http.StatusNoContent},
+		{"owner manages team", f.owner, db.PermissionManageTeam, http.StatusNoContent},
+		{"outsider reads", f.outsider, db.PermissionReadOnly, http.StatusForbidden},
+		{"admin reads", f.admin, db.PermissionReadOnly, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := postFormAs(t, a, tt.user, requiring(tt.required), "/", form); rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireBusinessMember(t *testing.T) {
+	a := newTestAuth(t)
+	f := newRoleFixture(t, a)
+	other := db.Business{Name: "Tortas", OwnerID: "1002"}
+	if err := a.Store.Businesses.Set(context.Background(), &other); err != nil {
+		t.Fatal(err)
+	}
+	h := a.RequireBusinessMember(noContent)
+
+	tests := []struct {
+		name       string
+		user       *db.User
+		businessID string
+		want       int
+	}{
+		{"owner", f.owner, strconv.Itoa(f.business.ID), http.StatusNoContent},
+		{"collaborator", f.members[db.PermissionReadOnly], strconv.Itoa(f.business.ID), http.StatusNoContent},
+		{"collaborator without a business", f.members[db.PermissionReadOnly], "", http.StatusNoContent},
+		{"collaborator in another business", f.members[db.PermissionManageTeam], strconv.Itoa(other.ID), http.StatusForbidden},
+		{"owner of another business", f.outsider, strconv.Itoa(f.business.ID), http.StatusForbidden},
+		{"admin", f.admin, "", http.StatusForbidden},
+		{"bad business id", f.owner, "tacos", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			if tt.businessID != "" {
+				form.Set("business_id", tt.businessID)
+			}
+			if rec := postFormAs(t, a, tt.user, h, "/", form); rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
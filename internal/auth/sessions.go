@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/calmestend/mercado_lobito/pkg/env"
+	"github.com/google/uuid"
+)
+
+var ErrSessionExpired = errors.New("session expired")
+
+type SessionConfig struct {
+	// Lifetime of a session no matter how active it is
+	AbsoluteTimeout time.Duration
+	// A session unused for this long expires
+	IdleTimeout time.Duration
+	// LastSeenAt is written at most once per RenewInterval
+	RenewInterval time.Duration
+	// How often expired rows are deleted
+	SweepInterval time.Duration
+}
+
+func SessionConfigFromEnv() SessionConfig {
+	return SessionConfig{
+		AbsoluteTimeout: env.GetDuration("SESSION_ABSOLUTE_TIMEOUT", 24*time.Hour),
+		IdleTimeout:     env.GetDuration("SESSION_IDLE_TIMEOUT", 2*time.Hour),
+		RenewInterval:   env.GetDuration("SESSION_RENEW_INTERVAL", time.Minute),
+		SweepInterval:   env.GetDuration("SESSION_SWEEP_INTERVAL", 10*time.Minute),
+	}
+}
+
+// Start a session for userID on the client making r
+func (a *Auth) CreateSession(r *http.Request, userID int) (*db.Session, error) {
+	now := a.now()
+
+	session := db.Session{
+		UUID:       uuid.NewString(),
+		UserID:     userID,
+		ExpiresAt:  now.Add(a.Sessions.AbsoluteTimeout),
+		LastSeenAt: now,
+		CreatedAt:  now,
+		IP:         a.clientIP(r),
+		UserAgent:  truncate(r.UserAgent(), 255),
+	}
+
+	err := a.Store.Sessions.Set(r.Context(), &session)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// Get a live session, expired ones are deleted on sight. Activity pushes
+// the idle deadline forward.
+func (a *Auth) GetSession(ctx context.Context, uuid string) (*db.Session, error) {
+	session := db.Session{
+		UUID: uuid,
+	}
+
+	err := a.Store.Sessions.Get(ctx, &session)
+	if err != nil {
+		return nil, errors.New("session not found")
+	}
+
+	now := a.now()
+	if !now.Before(session.ExpiresAt) || !now.Before(session.LastSeenAt.Add(a.Sessions.IdleTimeout)) {
+		a.Store.Sessions.Delete(ctx, &session)
+		return nil, ErrSessionExpired
+	}
+
+	if now.Sub(session.LastSeenAt) >= a.Sessions.RenewInterval {
+		session.LastSeenAt = now
+		if err := a.Store.Sessions.Touch(ctx, &session); err != nil {
+			log.Printf("Error %s when renewing session", err)
+		}
+	}
+
+	return &session, nil
+}
+
+// Live sessions of the user, most recently used first. Expired rows the
+// sweeper hasn't got to yet are left out.
+func (a *Auth) ListSessions(ctx context.Context, userID int) ([]db.Session, error) {
+	sessions, err := a.Store.Sessions.ListByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := a.now()
+	live := sessions[:0]
+	for _, session := range sessions {
+		if now.Before(session.ExpiresAt) && now.Before(session.LastSeenAt.Add(a.Sessions.IdleTimeout)) {
+			live = append(live, session)
+		}
+	}
+	return live, nil
+}
+
+func (a *Auth) DeleteSession(ctx context.Context, uuid string) error {
+	session := db.Session{
+		UUID: uuid,
+	}
+
+	return a.Store.Sessions.Delete(ctx, &session)
+}
+
+// The cookie expires with whichever session deadline comes first
+func (a *Auth) sessionCookieExpiry(session *db.Session) time.Time {
+	idleDeadline := session.LastSeenAt.Add(a.Sessions.IdleTimeout)
+	if idleDeadline.Before(session.ExpiresAt) {
+		return idleDeadline
+	}
+	return session.ExpiresAt
+}
+
+func (a *Auth) setSessionCookie(w http.ResponseWriter, session *db.Session) {
+	http.SetCookie(w, a.Cookies.Cookie("session", session.UUID, a.sessionCookieExpiry(session)))
+}
+
+func (a *Auth) clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, a.Cookies.Expired("session"))
+}
+
+// Delete expired sessions every SweepInterval until ctx is done
+func (a *Auth) SweepSessions(ctx context.Context) {
+	ticker := time.NewTicker(a.Sessions.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.sweepSessions(ctx)
+		}
+	}
+}
+
+// One pass of SweepSessions
+func (a *Auth) sweepSessions(ctx context.Context) {
+	now := a.now()
+	deleted, err := a.Store.Sessions.DeleteExpired(ctx, now, now.Add(-a.Sessions.IdleTimeout))
+	if err != nil {
+		log.Printf("Error %s when deleting expired sessions", err)
+		return
+	}
+	if deleted > 0 {
+		log.Printf("Deleted %d expired sessions", deleted)
+	}
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/internal/db"
+)
+
+// Clock standing still until a test moves it
+type testClock struct {
+	now time.Time
+}
+
+func newTestClock(a *Auth) *testClock {
+	c := &testClock{now: time.Now()}
+	a.now = func() time.Time { return c.now }
+	return c
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestSession(t *testing.T, a *Auth, u *db.User) *db.Session {
+	t.Helper()
+
+	session, err := a.CreateSession(httptest.NewRequest(http.MethodGet, "/", nil), u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+// Whether the session's row is still stored, expired or not
+func sessionStored(t *testing.T, a *Auth, session *db.Session) bool {
+	t.Helper()
+
+	err := a.Store.Sessions.Get(context.Background(), &db.Session{UUID: session.UUID})
+	if err != nil && !errors.Is(err, db.ErrSessionNotFound) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func TestSessionIdleTimeout(t *testing.T) {
+	a := newTestAuth(t)
+	a.Sessions = SessionConfig{AbsoluteTimeout: 24 * time.Hour, IdleTimeout: time.Hour, RenewInterval: time.Minute}
+	clock := newTestClock(a)
+	u := newTestUser(t, a, "ana@example.com", "")
+	session := newTestSession(t, a, u)
+	ctx := context.Background()
+
+	// Each use pushes the idle deadline forward
+	for range 3 {
+		clock.advance(59 * time.Minute)
+		if _, err := a.GetSession(ctx, session.UUID); err != nil {
+			t.Fatalf("session in use expired: %v", err)
+		}
+	}
+
+	clock.advance(time.Hour)
+	if sessions, err := a.ListSessions(ctx, u.ID); err != nil || len(sessions) != 0 {
+		t.Errorf("idle session listed: %v, %v", sessions, err)
+	}
+	if _, err := a.GetSession(ctx, session.UUID); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("err = %v, want %v", err, ErrSessionExpired)
+	}
+	if sessionStored(t, a, session) {
+		t.Error("expired session not deleted on sight")
+	}
+}
+
+func TestSessionAbsoluteTimeout(t *testing.T) {
+	a := newTestAuth(t)
+	a.Sessions = SessionConfig{AbsoluteTimeout: 3 * time.Hour, IdleTimeout: time.Hour, RenewInterval: time.Minute}
+	clock := newTestClock(a)
+	u := newTestUser(t, a, "ana@example.com", "")
+	session := newTestSession(t, a, u)
+	ctx := context.Background()
+
+	for elapsed := 30 * time.Minute; elapsed < a.Sessions.AbsoluteTimeout; elapsed += 30 * time.Minute {
+		clock.advance(30 * time.Minute)
+		if _, err := a.GetSession(ctx, session.UUID); err != nil {
+			t.Fatalf("expired after %v: %v", elapsed, err)
+		}
+	}
+
+	// However active, it ends AbsoluteTimeout after signing in
+	clock.advance(30 * time.Minute)
+	if _, err := a.GetSession(ctx, session.UUID); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("err = %v, want %v", err, ErrSessionExpired)
+	}
+}
+
+func TestSweepSessions(t *testing.T) {
+	a := newTestAuth(t)
+	a.Sessions = SessionConfig{AbsoluteTimeout: 2 * time.Hour, IdleTimeout: time.Hour, RenewInterval: time.Minute}
+	clock := newTestClock(a)
+	u := newTestUser(t, a, "ana@example.com", "")
+	ctx := context.Background()
+
+	idle := newTestSession(t, a, u)
+	old := newTestSession(t, a, u)
+	for range 2 {
+		clock.advance(50 * time.Minute)
+		if _, err := a.GetSession(ctx, old.UUID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.advance(10 * time.Minute)
+	live := newTestSession(t, a, u)
+	clock.advance(15 * time.Minute)
+
+	a.sweepSessions(ctx)
+
+	for name, tt := range map[string]struct {
+		session *db.Session
+		want    bool
+	}{
+		"idle":    {idle, false},
+		"too old": {old, false},
+		"live":    {live, true},
+	} {
+		if got := sessionStored(t, a, tt.session); got != tt.want {
+			t.Errorf("%s session stored = %v, want %v", name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// Lets each key act at most limit times per interval. Kept in memory, so
+// limits reset on restart and aren't shared between instances.
+type throttle struct {
+	mu       sync.Mutex
+	limit    int
+	interval time.Duration
+	// When each key acted within the last interval, oldest first
+	acted map[string][]time.Time
+}
+
+func newThrottle(limit int, interval time.Duration) *throttle {
+	return &throttle{
+		limit:    max(limit, 1),
+		interval: interval,
+		acted:    map[string][]time.Time{},
+	}
+}
+
+// Whether key may act now, recording it when it may. Otherwise returns how
+// long is left to wait.
+func (t *throttle) allow(key string, now time.Time) (bool, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if wait := t.waitLocked(key, now); wait > 0 {
+		return false, wait
+	}
+	t.acted[key] = append(t.acted[key], now)
+	return true, 0
+}
+
+// How long before key may act, without recording anything
+func (t *throttle) wait(key string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.waitLocked(key, now)
+}
+
+// Record that key acted, for when it was let through by checking wait
+func (t *throttle) record(key string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.acted[key] = append(t.acted[key], now)
+}
+
+func (t *throttle) waitLocked(key string, now time.Time) time.Duration {
+	for k, times := range t.acted {
+		i := 0
+		for i < len(times) && now.Sub(times[i]) >= t.interval {
+			i++
+		}
+		if i == len(times) {
+			delete(t.acted, k)
+		} else if i > 0 {
+			t.acted[k] = times[i:]
+		}
+	}
+
+	times := t.acted[key]
+	if len(times) < t.limit {
+		return 0
+	}
+	// Another slot opens once the oldest of the last limit drops out
+	return max(t.interval-now.Sub(times[len(times)-t.limit]), time.Nanosecond)
+}
@@ -0,0 +1,417 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/calmestend/mercado_lobito/internal/components"
+	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/calmestend/mercado_lobito/pkg/totp"
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	twoFactorPurpose = "two-factor-login"
+	// Cookie carrying who passed the password step and still owes a code
+	twoFactorCookieName = "2fa_pending"
+	twoFactorIssuer     = "Mercado Lobito"
+	recoveryCodeCount   = 10
+)
+
+// Roles that can't use the app without two-factor
+var twoFactorRoles = []Role{RoleAdmin}
+
+// Whether the principal has to enroll before going on
+func (p *Principal) NeedsTwoFactor() bool {
+	return !p.TwoFactorEnabled && p.HasRole(twoFactorRoles...)
+}
+
+// Enabled enrollment of the user, nil when there is none
+func (a *Auth) enabledTOTP(ctx context.Context, userID int) (*db.TOTP, error) {
+	t := db.TOTP{UserID: userID}
+	err := a.Store.TOTP.Get(ctx, &t)
+	if errors.Is(err, db.ErrTOTPNotFound) || (err == nil && t.EnabledAt == nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Check a code from the authenticator app or, failing that, one of the
+// recovery codes. Both can only be used once.
+func (a *Auth) checkSecondFactor(ctx context.Context, t *db.TOTP, code string) (bool, error) {
+	if step, ok := totp.Validate(t.Secret, code, time.Now()); ok {
+		return a.Store.TOTP.UseStep(ctx, t, step)
+	}
+
+	return a.Store.TOTP.UseRecoveryCode(ctx, t.UserID, hashToken(normalizeRecoveryCode(code)), time.Now())
+}
+
+// Check a code for the signed in user u the same as the second step of
+// signing in, under the same lockout
+func (a *Auth) checkCode(r *http.Request, u *db.User, t *db.TOTP, code string) (bool, time.Duration, bool, error) {
+	wait, locked, err := a.loginWait(r.Context(), u.ID, u.Email, a.clientIP(r))
+	if err != nil || wait > 0 {
+		return false, wait, locked, err
+	}
+
+	ok, err := a.checkSecondFactor(r.Context(), t, code)
+	if err != nil || ok {
+		return ok, 0, false, err
+	}
+
+	if err := a.recordLogin(r.Context(), r, u.ID, u.Email, false); err != nil {
+		log.Printf("Error recording failed sign in: %v", err)
+	}
+
+	wait, locked, err = a.loginWait(r.Context(), u.ID, u.Email, a.clientIP(r))
+	if err == nil && locked {
+		return false, wait, locked, nil
+	}
+	return false, 0, false, nil
+}
+
+func newRecoveryCodes() (codes []string, hashes []string) {
+	for range recoveryCodeCount {
+		b := make([]byte, 5)
+		rand.Read(b)
+		code := hex.EncodeToString(b)
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashToken(code))
+	}
+	return codes, hashes
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+// Remember who passed the first step. Nothing about the sign in is recorded
+// until the code checks out.
+func (a *Auth) setTwoFactorPending(w http.ResponseWriter, userID int, identifier string) {
+	expiresAt := time.Now().Add(a.TwoFactorTTL)
+	token := a.Signer.Sign(twoFactorPurpose, strconv.Itoa(userID)+":"+identifier, expiresAt)
+	http.SetCookie(w, a.Cookies.Cookie(twoFactorCookieName, token, expiresAt))
+}
+
+// Swap the login form for the code prompt
+func (a *Auth) startTwoFactor(w http.ResponseWriter, r *http.Request, userID int, identifier string) {
+	a.setTwoFactorPending(w, userID, identifier)
+
+	w.Header().Set("HX-Retarget", "#login")
+	w.Header().Set("HX-Reswap", "outerHTML")
+	components.TwoFactorPrompt().Render(r.Context(), w)
+}
+
+// Second step of Signin
+func (a *Auth) VerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		components.TwoFactorResponse(false, "Error processing form").Render(r.Context(), w)
+		return
+	}
+
+	cookie, err := r.Cookie(twoFactorCookieName)
+	if err != nil {
+		components.TwoFactorResponse(false, "Tu inicio de sesión expiró, vuelve a ingresar tu contraseña").Render(r.Context(), w)
+		return
+	}
+	subject, err := a.Signer.Verify(twoFactorPurpose, cookie.Value, time.Now())
+	if err != nil {
+		http.SetCookie(w, a.Cookies.Expired(twoFactorCookieName))
+		components.TwoFactorResponse(false, "Tu inicio de sesión expiró, vuelve a ingresar tu contraseña").Render(r.Context(), w)
+		return
+	}
+
+	idVal, identifier, _ := strings.Cut(subject, ":")
+	userID, err := strconv.Atoi(idVal)
+	if err != nil {
+		components.TwoFactorResponse(false, "Error signing in").Render(r.Context(), w)
+		return
+	}
+
+	// Codes count towards the same lockout as passwords
+	wait, locked, err := a.loginWait(r.Context(), userID, identifier, a.clientIP(r))
+	if err != nil {
+		components.TwoFactorResponse(false, "Error signing in").Render(r.Context(), w)
+		return
+	}
+	if wait > 0 {
+		a.RenderLoginThrottled(w, r, wait, locked)
+		return
+	}
+
+	t, err := a.enabledTOTP(r.Context(), userID)
+	if err != nil || t == nil {
+		components.TwoFactorResponse(false, "Error signing in").Render(r.Context(), w)
+		return
+	}
+
+	ok, err := a.checkSecondFactor(r.Context(), t, r.FormValue("code"))
+	if err != nil {
+		components.TwoFactorResponse(false, "Error signing in").Render(r.Context(), w)
+		return
+	}
+	if !ok {
+		if err := a.recordLogin(r.Context(), r, userID, identifier, false); err != nil {
+			log.Printf("Error recording failed sign in: %v", err)
+		}
+
+		wait, locked, err := a.loginWait(r.Context(), userID, identifier, a.clientIP(r))
+		if err == nil && locked {
+			http.SetCookie(w, a.Cookies.Expired(twoFactorCookieName))
+			a.RenderLoginThrottled(w, r, wait, locked)
+			return
+		}
+
+		components.TwoFactorResponse(false, "Código incorrecto").Render(r.Context(), w)
+		return
+	}
+
+	if err := a.recordLogin(r.Context(), r, userID, identifier, true); err != nil {
+		log.Printf("Error recording sign in: %v", err)
+	}
+
+	session, err := a.CreateSession(r, userID)
+	if err != nil {
+		components.TwoFactorResponse(false, "Error creating session").Render(r.Context(), w)
+		return
+	}
+
+	http.SetCookie(w, a.Cookies.Expired(twoFactorCookieName))
+	a.setSessionCookie(w, session)
+
+	w.Header().Set("HX-Redirect", "/")
+	w.WriteHeader(http.StatusOK)
+}
+
+// Status of the principal's enrollment for the settings page
+func (a *Auth) TwoFactorStatus(ctx context.Context, userID int) (enabled bool, recoveryCodesLeft int, err error) {
+	t, err := a.enabledTOTP(ctx, userID)
+	if err != nil || t == nil {
+		return false, 0, err
+	}
+
+	recoveryCodesLeft, err = a.Store.TOTP.CountRecoveryCodes(ctx, userID)
+	return true, recoveryCodesLeft, err
+}
+
+// Start enrollment with a new secret and show it as a QR code
+func (a *Auth) SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	p, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if p.TwoFactorEnabled {
+		components.TwoFactorResponse(false, "La verificación en dos pasos ya está activa").Render(r.Context(), w)
+		return
+	}
+
+	u := db.User{ID: p.UserID}
+	if err := a.Store.Users.GetByID(r.Context(), &u); err != nil {
+		components.TwoFactorResponse(false, "Error starting enrollment").Render(r.Context(), w)
+		return
+	}
+
+	t := db.TOTP{UserID: p.UserID, Secret: totp.NewSecret()}
+	if err := a.Store.TOTP.SetPending(r.Context(), &t); err != nil {
+		components.TwoFactorResponse(false, "Error starting enrollment").Render(r.Context(), w)
+		return
+	}
+
+	png, err := qrcode.Encode(totp.URI(twoFactorIssuer, u.Email, t.Secret), qrcode.Medium, 256)
+	if err != nil {
+		components.TwoFactorResponse(false, "Error starting enrollment").Render(r.Context(), w)
+		return
+	}
+	qrSrc := "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+
+	renderTwoFactorSection(w, r, components.TwoFactorSetup(qrSrc, groupSecret(t.Secret)))
+}
+
+// Secret in groups of four, easier to type in by hand
+func groupSecret(secret string) string {
+	var b strings.Builder
+	for i, c := range secret {
+		if i > 0 && i%4 == 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// Finish enrollment once the app shows a matching code and hand out the
+// recovery codes, the only time they can be seen
+func (a *Auth) EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		components.TwoFactorResponse(false, "Error processing form").Render(r.Context(), w)
+		return
+	}
+
+	p, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	t := db.TOTP{UserID: p.UserID}
+	if err := a.Store.TOTP.Get(r.Context(), &t); err != nil || t.EnabledAt != nil {
+		components.TwoFactorResponse(false, "Empieza de nuevo la configuración").Render(r.Context(), w)
+		return
+	}
+
+	step, ok := totp.Validate(t.Secret, r.FormValue("code"), time.Now())
+	if !ok {
+		components.TwoFactorResponse(false, "Código incorrecto").Render(r.Context(), w)
+		return
+	}
+
+	now := time.Now()
+	t.EnabledAt = &now
+	t.LastStep = step
+	codes, hashes := newRecoveryCodes()
+
+	err := a.Store.WithTx(r.Context(), func(tx *db.Store) error {
+		if err := tx.TOTP.Enable(r.Context(), &t); err != nil {
+			return err
+		}
+		return tx.TOTP.SetRecoveryCodes(r.Context(), t.UserID, hashes)
+	})
+	if err != nil {
+		components.TwoFactorResponse(false, "Error enabling two-factor").Render(r.Context(), w)
+		return
+	}
+
+	renderTwoFactorSection(w, r, components.TwoFactorRecoveryCodes(codes))
+}
+
+// Turn two-factor off, asking for the password, or a recent sign in for
+// accounts without one, and a current code so a left open session isn't
+// enough
+func (a *Auth) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		components.TwoFactorResponse(false, "Error processing form").Render(r.Context(), w)
+		return
+	}
+
+	p, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	t, err := a.enabledTOTP(r.Context(), p.UserID)
+	if err != nil || t == nil {
+		components.TwoFactorResponse(false, "La verificación en dos pasos no está activa").Render(r.Context(), w)
+		return
+	}
+
+	u := db.User{ID: p.UserID}
+	if err := a.Store.Users.GetByID(r.Context(), &u); err != nil {
+		components.TwoFactorResponse(false, "Error disabling two-factor").Render(r.Context(), w)
+		return
+	}
+
+	// Same lockout as signing in, so a live session isn't a way to guess
+	// the password
+	ok, wait, locked, err := a.confirmIdentity(r, &u, r.FormValue("password"))
+	if errors.Is(err, ErrSignInAgain) {
+		components.SignInAgain().Render(r.Context(), w)
+		return
+	}
+	if err != nil {
+		components.TwoFactorResponse(false, "Error disabling two-factor").Render(r.Context(), w)
+		return
+	}
+	if wait > 0 {
+		a.RenderLoginThrottled(w, r, wait, locked)
+		return
+	}
+	if !ok {
+		components.TwoFactorResponse(false, "Invalid Credentials").Render(r.Context(), w)
+		return
+	}
+
+	ok, wait, locked, err = a.checkCode(r, &u, t, r.FormValue("code"))
+	if err != nil {
+		components.TwoFactorResponse(false, "Error disabling two-factor").Render(r.Context(), w)
+		return
+	}
+	if wait > 0 {
+		a.RenderLoginThrottled(w, r, wait, locked)
+		return
+	}
+	if !ok {
+		components.TwoFactorResponse(false, "Código incorrecto").Render(r.Context(), w)
+		return
+	}
+
+	if err := a.Store.TOTP.Delete(r.Context(), p.UserID); err != nil {
+		components.TwoFactorResponse(false, "Error disabling two-factor").Render(r.Context(), w)
+		return
+	}
+
+	renderTwoFactorSection(w, r, components.TwoFactorSettings(false, 0, p.HasRole(twoFactorRoles...), u.Hash != ""))
+}
+
+// Forms in the settings section post their errors to its messages, what
+// comes after a successful step replaces the whole section
+func renderTwoFactorSection(w http.ResponseWriter, r *http.Request, c templ.Component) {
+	w.Header().Set("HX-Retarget", "#two-factor")
+	w.Header().Set("HX-Reswap", "outerHTML")
+	c.Render(r.Context(), w)
+}
+
+// Send principals that must use two-factor and haven't enrolled to the
+// settings page. Must run inside AuthMiddleware.
+func (a *Auth) RequireTwoFactor(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if p.NeedsTwoFactor() {
+			http.Redirect(w, r, "/profile/config", http.StatusFound)
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/calmestend/mercado_lobito/pkg/totp"
+)
+
+// Enroll u through the settings handlers, returning the secret and the
+// recovery codes handed out
+func enrollTwoFactor(t *testing.T, a *Auth, u *db.User) (string, []string) {
+	t.Helper()
+
+	postFormAs(t, a, u, a.SetupTwoFactor, "/auth/2fa/setup", nil)
+	pending := db.TOTP{UserID: u.ID}
+	if err := a.Store.TOTP.Get(context.Background(), &pending); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := postFormAs(t, a, u, a.EnableTwoFactor, "/auth/2fa/enable", url.Values{"code": {totpCode(t, pending.Secret, 5)}}); !strings.Contains(rec.Body.String(), "Código incorrecto") {
+		t.Fatalf("wrong code enabled two-factor: %s", rec.Body)
+	}
+
+	rec := postFormAs(t, a, u, a.EnableTwoFactor, "/auth/2fa/enable", url.Values{"code": {totpCode(t, pending.Secret, 0)}})
+	var codes []string
+	for _, m := range regexp.MustCompile(`<code>([0-9a-f]{5}-[0-9a-f]{5})</code>`).FindAllStringSubmatch(rec.Body.String(), -1) {
+		codes = append(codes, m[1])
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d: %s", len(codes), recoveryCodeCount, rec.Body)
+	}
+	return pending.Secret, codes
+}
+
+// Code offset steps away from the current one
+func totpCode(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+
+	code, err := totp.Code(secret, totp.Step(time.Now())+offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// Sign in with the password and then code, returning the session cookie if
+// both went through
+func signinTwoFactor(t *testing.T, a *Auth, email, code string) *http.Cookie {
+	t.Helper()
+
+	rec := postForm(a.Signin, "/auth/signin", url.Values{"identifier": {email}, "password": {testPassword}})
+	if sessionCookie(rec) != nil {
+		t.Fatal("signed in without a code")
+	}
+	var pending *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == twoFactorCookieName {
+			pending = c
+		}
+	}
+	if pending == nil {
+		t.Fatalf("no code prompt: %d %s", rec.Code, rec.Body)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/2fa/verify", strings.NewReader(url.Values{"code": {code}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(pending)
+	rec = httptest.NewRecorder()
+	a.VerifyTwoFactor(rec, req)
+	return sessionCookie(rec)
+}
+
+func TestTwoFactorSignin(t *testing.T) {
+	a := newTestAuth(t)
+	u := newTestUser(t, a, "ana@example.com", "")
+	secret, recoveryCodes := enrollTwoFactor(t, a, u)
+
+	// The code that enabled it was already used
+	enrolled := db.TOTP{UserID: u.ID}
+	if err := a.Store.TOTP.Get(context.Background(), &enrolled); err != nil {
+		t.Fatal(err)
+	}
+	used, err := totp.Code(secret, enrolled.LastStep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signinTwoFactor(t, a, u.Email, used) != nil {
+		t.Error("signed in replaying the enrollment code")
+	}
+	if signinTwoFactor(t, a, u.Email, totpCode(t, secret, 1)) == nil {
+		t.Error("can't sign in with the next code")
+	}
+
+	if signinTwoFactor(t, a, u.Email, strings.ToUpper(recoveryCodes[0])) == nil {
+		t.Error("can't sign in with a recovery code")
+	}
+	if signinTwoFactor(t, a, u.Email, recoveryCodes[0]) != nil {
+		t.Error("signed in with a recovery code used before")
+	}
+
+	_, left, err := a.TwoFactorStatus(context.Background(), u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left != recoveryCodeCount-1 {
+		t.Errorf("%d recovery codes left, want %d", left, recoveryCodeCount-1)
+	}
+}
+
+func TestDisableTwoFactor(t *testing.T) {
+	a := newTestAuth(t)
+	u := newTestUser(t, a, "ana@example.com", "")
+	_, recoveryCodes := enrollTwoFactor(t, a, u)
+
+	disable := func(password, code string) *httptest.ResponseRecorder {
+		return postFormAs(t, a, u, a.DisableTwoFactor, "/auth/2fa/disable", url.Values{"password": {password}, "code": {code}})
+	}
+	enabled := func() bool {
+		t.Helper()
+		enabled, _, err := a.TwoFactorStatus(context.Background(), u.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return enabled
+	}
+
+	if rec := disable("wrong password!", recoveryCodes[0]); !strings.Contains(rec.Body.String(), "Invalid Credentials") {
+		t.Errorf("wrong password: %d %s", rec.Code, rec.Body)
+	}
+	if rec := disable(testPassword, "12345"); !strings.Contains(rec.Body.String(), "Código incorrecto") {
+		t.Errorf("wrong code: %d %s", rec.Code, rec.Body)
+	}
+	if !enabled() {
+		t.Fatal("disabled without the right password and code")
+	}
+
+	disable(testPassword, recoveryCodes[0])
+	if enabled() {
+		t.Error("still enabled")
+	}
+}
+
+func TestDisableTwoFactorLockout(t *testing.T) {
+	a := newTestAuth(t)
+	a.Login.MaxFailures = 3
+	u := newTestUser(t, a, "ana@example.com", "")
+	_, recoveryCodes := enrollTwoFactor(t, a, u)
+
+	for range a.Login.MaxFailures - 1 {
+		postFormAs(t, a, u, a.DisableTwoFactor, "/auth/2fa/disable", url.Values{"password": {"wrong password!"}, "code": {recoveryCodes[0]}})
+	}
+
+	// The last allowed failure already reports the lockout, and the right
+	// password doesn't get through it either
+	for _, password := range []string{"wrong password!", testPassword} {
+		rec := postFormAs(t, a, u, a.DisableTwoFactor, "/auth/2fa/disable", url.Values{"password": {password}, "code": {recoveryCodes[0]}})
+		if rec.Code != http.StatusTooManyRequests {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+		}
+	}
+
+	// Signing in is locked out the same
+	rec := postForm(a.Signin, "/auth/signin", url.Values{"identifier": {u.Email}, "password": {testPassword}})
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("sign in status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+}
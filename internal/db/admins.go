@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+type Admin struct {
+	ID     int
+	UserID int
+}
+
+type AdminStore interface {
+	Set(ctx context.Context, a *Admin) error
+	GetByUserID(ctx context.Context, a *Admin) error
+	Delete(ctx context.Context, a *Admin) error
+}
+
+type mysqlAdminStore struct {
+	conn
+}
+
+func (s *mysqlAdminStore) Set(ctx context.Context, a *Admin) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, `INSERT INTO admins(user_id) VALUES (?)`, a.UserID)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err == nil {
+		a.ID = int(id)
+	}
+
+	return nil
+}
+
+func (s *mysqlAdminStore) GetByUserID(ctx context.Context, a *Admin) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT id, user_id FROM admins WHERE user_id = ?`
+	row := s.db.QueryRowContext(ctx, stmt, a.UserID)
+	err := row.Scan(&a.ID, &a.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrAdminNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *mysqlAdminStore) Delete(ctx context.Context, a *Admin) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `DELETE FROM admins WHERE id = ?`
+	_, err := s.db.ExecContext(ctx, stmt, a.ID)
+	return err
+}
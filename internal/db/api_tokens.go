@@ -0,0 +1,190 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+// What an API token may do, split into read for GET and write for the rest
+type Scope string
+
+const (
+	ScopeProductsRead  Scope = "products:read"
+	ScopeProductsWrite Scope = "products:write"
+	// Collaborators and invitations
+	ScopeTeamRead  Scope = "team:read"
+	ScopeTeamWrite Scope = "team:write"
+)
+
+// Every scope, in the order the token form lists them
+var Scopes = []Scope{ScopeProductsRead, ScopeProductsWrite, ScopeTeamRead, ScopeTeamWrite}
+
+func (s Scope) Valid() bool {
+	return slices.Contains(Scopes, s)
+}
+
+// Personal access token for scripts calling /api
+type APIToken struct {
+	ID        int
+	UserID    int
+	Name      string
+	TokenHash string
+	Scopes    []Scope
+	CreatedAt time.Time
+	// Nil for tokens that don't expire
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+}
+
+func (t *APIToken) HasScope(s Scope) bool {
+	return slices.Contains(t.Scopes, s)
+}
+
+type APITokenStore interface {
+	Set(ctx context.Context, t *APIToken) error
+	GetByHash(ctx context.Context, t *APIToken) error
+	// Newest first
+	ListByUserID(ctx context.Context, userID int) ([]APIToken, error)
+	// Store t.LastUsedAt
+	Touch(ctx context.Context, t *APIToken) error
+	// Delete the token with t.ID only if it belongs to t.UserID
+	DeleteForUser(ctx context.Context, t *APIToken) error
+	// Revoke every token of the user
+	DeleteByUserID(ctx context.Context, userID int) error
+}
+
+type mysqlAPITokenStore struct {
+	conn
+}
+
+func joinScopes(scopes []Scope) string {
+	s := make([]string, len(scopes))
+	for i, scope := range scopes {
+		s[i] = string(scope)
+	}
+	return strings.Join(s, ",")
+}
+
+func splitScopes(s string) []Scope {
+	var scopes []Scope
+	for _, scope := range strings.Split(s, ",") {
+		if scope != "" {
+			scopes = append(scopes, Scope(scope))
+		}
+	}
+	return scopes
+}
+
+func (s *mysqlAPITokenStore) Set(ctx context.Context, t *APIToken) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO api_tokens(user_id, name, token_hash, scopes, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	res, err := s.db.ExecContext(ctx, stmt, t.UserID, t.Name, t.TokenHash, joinScopes(t.Scopes), t.CreatedAt, t.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err == nil {
+		t.ID = int(id)
+	}
+
+	return nil
+}
+
+func (s *mysqlAPITokenStore) GetByHash(ctx context.Context, t *APIToken) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `
+		SELECT id, user_id, name, token_hash, scopes, created_at, expires_at, last_used_at
+		FROM api_tokens
+		WHERE token_hash = ?
+	`
+	var scopes string
+	err := s.db.QueryRowContext(ctx, stmt, t.TokenHash).Scan(&t.ID, &t.UserID, &t.Name, &t.TokenHash, &scopes, &t.CreatedAt, &t.ExpiresAt, &t.LastUsedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrAPITokenNotFound
+		}
+		return err
+	}
+
+	t.Scopes = splitScopes(scopes)
+	return nil
+}
+
+func (s *mysqlAPITokenStore) ListByUserID(ctx context.Context, userID int) ([]APIToken, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `
+		SELECT id, user_id, name, token_hash, scopes, created_at, expires_at, last_used_at
+		FROM api_tokens
+		WHERE user_id = ?
+		ORDER BY created_at DESC, id DESC
+	`
+	rows, err := s.db.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		var t APIToken
+		var scopes string
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.TokenHash, &scopes, &t.CreatedAt, &t.ExpiresAt, &t.LastUsedAt); err != nil {
+			return nil, err
+		}
+		t.Scopes = splitScopes(scopes)
+		tokens = append(tokens, t)
+	}
+
+	return tokens, rows.Err()
+}
+
+func (s *mysqlAPITokenStore) Touch(ctx context.Context, t *APIToken) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE api_tokens SET last_used_at = ? WHERE id = ?`
+	_, err := s.db.ExecContext(ctx, stmt, t.LastUsedAt, t.ID)
+	return err
+}
+
+func (s *mysqlAPITokenStore) DeleteForUser(ctx context.Context, t *APIToken) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `DELETE FROM api_tokens WHERE id = ? AND user_id = ?`
+	res, err := s.db.ExecContext(ctx, stmt, t.ID, t.UserID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
+
+func (s *mysqlAPITokenStore) DeleteByUserID(ctx context.Context, userID int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM api_tokens WHERE user_id = ?`, userID)
+	return err
+}
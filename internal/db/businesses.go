@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 )
@@ -13,8 +14,28 @@ type Business struct {
 	OwnerID     string
 }
 
-func (b *Business) Set(db *sql.DB) error {
-	stmt, err := db.Prepare(`
+type BusinessStore interface {
+	Set(ctx context.Context, b *Business) error
+	Get(ctx context.Context, b *Business) error
+	GetByOwnerID(ctx context.Context, b *Business) error
+	List(ctx context.Context) ([]Business, error)
+	GetProductsByOwnerID(ctx context.Context, b *Business) ([]Product, error)
+	GetCollaboratorsByBusinessID(ctx context.Context, b *Business) ([]Collaborator, error)
+	Update(ctx context.Context, b *Business) error
+	// Hand the business to the student b.OwnerID
+	TransferOwnership(ctx context.Context, b *Business) error
+	Delete(ctx context.Context, b *Business) error
+}
+
+type mysqlBusinessStore struct {
+	conn
+}
+
+func (s *mysqlBusinessStore) Set(ctx context.Context, b *Business) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := s.db.PrepareContext(ctx, `
 		insert INTO businesses (name, type, description, owner_id)
 		VALUES (?, ?, ?, ?)
 	`)
@@ -23,7 +44,7 @@ func (b *Business) Set(db *sql.DB) error {
 	}
 	defer stmt.Close()
 
-	res, err := stmt.Exec(b.Name, b.Type, b.Description, b.OwnerID)
+	res, err := stmt.ExecContext(ctx, b.Name, b.Type, b.Description, b.OwnerID)
 	if err != nil {
 		return err
 	}
@@ -36,41 +57,82 @@ func (b *Business) Set(db *sql.DB) error {
 	return nil
 }
 
-func (b *Business) Get(db *sql.DB) error {
+func (s *mysqlBusinessStore) Get(ctx context.Context, b *Business) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	stmt := `
 		SELECT id, name, type, description, owner_id
 		FROM businesses
 		WHERE id = ?
 	`
-	row := db.QueryRow(stmt, b.ID)
+	row := s.db.QueryRowContext(ctx, stmt, b.ID)
 	err := row.Scan(&b.ID, &b.Name, &b.Type, &b.Description, &b.OwnerID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return errors.New("business not found")
+			return ErrBusinessNotFound
 		}
 		return err
 	}
 	return nil
 }
 
-func (b *Business) GetByOwnerID(db *sql.DB) error {
+func (s *mysqlBusinessStore) GetByOwnerID(ctx context.Context, b *Business) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	stmt := `
 		SELECT id, name, type, description, owner_id
 		FROM businesses
 		WHERE owner_id = ?
 	`
-	row := db.QueryRow(stmt, b.OwnerID)
+	row := s.db.QueryRowContext(ctx, stmt, b.OwnerID)
 	err := row.Scan(&b.ID, &b.Name, &b.Type, &b.Description, &b.OwnerID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return errors.New("business not found")
+			return ErrBusinessNotFound
 		}
 		return err
 	}
 	return nil
 }
 
-func (b *Business) GetProductsByOwnerID(db *sql.DB) ([]Product, error) {
+func (s *mysqlBusinessStore) List(ctx context.Context) ([]Business, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `
+		SELECT id, name, type, description, owner_id
+		FROM businesses
+		ORDER BY name
+	`
+
+	rows, err := s.db.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var businesses []Business
+	for rows.Next() {
+		var b Business
+		if err := rows.Scan(&b.ID, &b.Name, &b.Type, &b.Description, &b.OwnerID); err != nil {
+			return nil, err
+		}
+		businesses = append(businesses, b)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return businesses, nil
+}
+
+func (s *mysqlBusinessStore) GetProductsByOwnerID(ctx context.Context, b *Business) ([]Product, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	stmt := `
 		SELECT p.id, p.title, p.price, p.stock, p.business_id
 		FROM products p
@@ -78,7 +140,7 @@ func (b *Business) GetProductsByOwnerID(db *sql.DB) ([]Product, error) {
 		WHERE b.owner_id = ?
 	`
 
-	rows, err := db.Query(stmt, b.OwnerID)
+	rows, err := s.db.QueryContext(ctx, stmt, b.OwnerID)
 	if err != nil {
 		return nil, err
 	}
@@ -101,18 +163,68 @@ func (b *Business) GetProductsByOwnerID(db *sql.DB) ([]Product, error) {
 	return products, nil
 }
 
-func (b *Business) Delete(db *sql.DB) error {
+func (s *mysqlBusinessStore) GetCollaboratorsByBusinessID(ctx context.Context, b *Business) ([]Collaborator, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `
+		SELECT u.id, u.middle_names, u.paternal_surname, u.maternal_surname, u.email, u.personal_id, u.photo_key, bc.permission
+		FROM users u
+		INNER JOIN businesses_collaborators bc ON u.id = bc.collaborator_id
+		WHERE bc.business_id = ?
+	`
+
+	rows, err := s.db.QueryContext(ctx, stmt, b.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var collaborators []Collaborator
+	for rows.Next() {
+		var c Collaborator
+		err := rows.Scan(&c.ID, &c.MiddleNames, &c.PaternalSurname,
+			&c.MaternalSurname, &c.Email, &c.PersonalID, &c.PhotoKey, &c.Permission)
+		if err != nil {
+			return nil, err
+		}
+		collaborators = append(collaborators, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collaborators, nil
+}
+
+func (s *mysqlBusinessStore) Delete(ctx context.Context, b *Business) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	stmt := `DELETE FROM businesses WHERE id = ?`
-	_, err := db.Exec(stmt, b.ID)
+	_, err := s.db.ExecContext(ctx, stmt, b.ID)
 	return err
 }
 
-func (b *Business) Update(db *sql.DB) error {
+func (s *mysqlBusinessStore) Update(ctx context.Context, b *Business) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	stmt := `
 		UPDATE businesses
 		SET name = ?, type = ?, description = ?
 		WHERE id = ?
 	`
-	_, err := db.Exec(stmt, b.Name, b.Type, b.Description, b.ID)
+	_, err := s.db.ExecContext(ctx, stmt, b.Name, b.Type, b.Description, b.ID)
+	return err
+}
+
+func (s *mysqlBusinessStore) TransferOwnership(ctx context.Context, b *Business) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE businesses SET owner_id = ? WHERE id = ?`
+	_, err := s.db.ExecContext(ctx, stmt, b.OwnerID, b.ID)
 	return err
 }
@@ -1,28 +1,76 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"errors"
-	"log"
 )
 
+type Permission string
+
+const (
+	PermissionReadOnly       Permission = "read_only"
+	PermissionManageProducts Permission = "manage_products"
+	// Includes managing products
+	PermissionManageTeam Permission = "manage_team"
+)
+
+var permissionLevels = map[Permission]int{
+	PermissionReadOnly:       1,
+	PermissionManageProducts: 2,
+	PermissionManageTeam:     3,
+}
+
+func (p Permission) Valid() bool {
+	_, ok := permissionLevels[p]
+	return ok
+}
+
+// Whether holding p is enough for something that requires `required`
+func (p Permission) Allows(required Permission) bool {
+	return p.Valid() && permissionLevels[p] >= permissionLevels[required]
+}
+
 type BusinessCollaborator struct {
 	ID             int
 	BusinessID     int
 	CollaboratorID int
+	Permission     Permission
+}
+
+// Collaborator user along with their permission in one business
+type Collaborator struct {
+	User
+	Permission Permission
+}
+
+type BusinessCollaboratorStore interface {
+	Set(ctx context.Context, bc *BusinessCollaborator) error
+	Get(ctx context.Context, bc *BusinessCollaborator) error
+	GetByBusinessAndCollaborator(ctx context.Context, bc *BusinessCollaborator) error
+	GetByCollaboratorID(ctx context.Context, collaboratorID int) ([]BusinessCollaborator, error)
+	UpdatePermission(ctx context.Context, bc *BusinessCollaborator) error
+	Delete(ctx context.Context, bc *BusinessCollaborator) error
 }
 
-func (bc *BusinessCollaborator) Set(db *sql.DB) error {
-	stmt, err := db.Prepare(`
-		insert INTO businesses_collaborators (business_id, collaborator_id)
-		VALUES (?, ?)
+type mysqlBusinessCollaboratorStore struct {
+	conn
+}
+
+func (s *mysqlBusinessCollaboratorStore) Set(ctx context.Context, bc *BusinessCollaborator) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := s.db.PrepareContext(ctx, `
+		insert INTO businesses_collaborators (business_id, collaborator_id, permission)
+		VALUES (?, ?, ?)
 	`)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	res, err := stmt.Exec(bc.BusinessID, bc.CollaboratorID)
+	res, err := stmt.ExecContext(ctx, bc.BusinessID, bc.CollaboratorID, bc.Permission)
 	if err != nil {
 		return err
 	}
@@ -35,74 +83,91 @@ func (bc *BusinessCollaborator) Set(db *sql.DB) error {
 	return nil
 }
 
-func (bc *BusinessCollaborator) Get(db *sql.DB) error {
+func (s *mysqlBusinessCollaboratorStore) Get(ctx context.Context, bc *BusinessCollaborator) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	stmt := `
-		SELECT id, business_id, collaborator_id
+		SELECT id, business_id, collaborator_id, permission
 		FROM businesses_collaborators
 		WHERE id = ?
 	`
-	row := db.QueryRow(stmt, bc.ID)
-	err := row.Scan(&bc.ID, &bc.BusinessID, &bc.CollaboratorID)
+	row := s.db.QueryRowContext(ctx, stmt, bc.ID)
+	err := row.Scan(&bc.ID, &bc.BusinessID, &bc.CollaboratorID, &bc.Permission)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return errors.New("business not found")
+			return ErrCollaboratorNotFound
 		}
 		return err
 	}
 	return nil
 }
 
-func (bc *BusinessCollaborator) GetByBusinessAndCollaborator(db *sql.DB) error {
-	stmt := `SELECT business_id, collaborator_id FROM business_collaborators 
+func (s *mysqlBusinessCollaboratorStore) GetByBusinessAndCollaborator(ctx context.Context, bc *BusinessCollaborator) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT id, business_id, collaborator_id, permission FROM businesses_collaborators
 			 WHERE business_id = ? AND collaborator_id = ?`
 
-	row := db.QueryRow(stmt, bc.BusinessID, bc.CollaboratorID)
-	err := row.Scan(&bc.BusinessID, &bc.CollaboratorID)
+	row := s.db.QueryRowContext(ctx, stmt, bc.BusinessID, bc.CollaboratorID)
+	err := row.Scan(&bc.ID, &bc.BusinessID, &bc.CollaboratorID, &bc.Permission)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return errors.New("business collaborator relationship not found")
+			return ErrCollaboratorNotFound
 		}
 		return err
 	}
 	return nil
 }
 
-func (b *Business) GetCollaboratorsByBusinessID(db *sql.DB) ([]User, error) {
+func (s *mysqlBusinessCollaboratorStore) GetByCollaboratorID(ctx context.Context, collaboratorID int) ([]BusinessCollaborator, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	stmt := `
-		SELECT u.id, u.middle_names, u.paternal_surname, u.maternal_surname, u.email, u.personal_id
-		FROM users u
-		INNER JOIN businesses_collaborators bc ON u.id = bc.collaborator_id
-		WHERE bc.business_id = ?
+		SELECT id, business_id, collaborator_id, permission
+		FROM businesses_collaborators
+		WHERE collaborator_id = ?
+		ORDER BY id
 	`
 
-	rows, err := db.Query(stmt, b.ID)
+	rows, err := s.db.QueryContext(ctx, stmt, collaboratorID)
 	if err != nil {
 		return nil, err
 	}
-
-	log.Print(rows)
 	defer rows.Close()
 
-	var collaborators []User
+	var memberships []BusinessCollaborator
 	for rows.Next() {
-		var user User
-		err := rows.Scan(&user.ID, &user.MiddleNames, &user.PaternalSurname,
-			&user.MaternalSurname, &user.Email, &user.PersonalID)
-		if err != nil {
+		var bc BusinessCollaborator
+		if err := rows.Scan(&bc.ID, &bc.BusinessID, &bc.CollaboratorID, &bc.Permission); err != nil {
 			return nil, err
 		}
-		collaborators = append(collaborators, user)
+		memberships = append(memberships, bc)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
 
-	return collaborators, nil
+	return memberships, nil
+}
+
+func (s *mysqlBusinessCollaboratorStore) UpdatePermission(ctx context.Context, bc *BusinessCollaborator) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE businesses_collaborators SET permission = ? WHERE id = ?`
+	_, err := s.db.ExecContext(ctx, stmt, bc.Permission, bc.ID)
+	return err
 }
 
-func (bc *BusinessCollaborator) Delete(db *sql.DB) error {
+func (s *mysqlBusinessCollaboratorStore) Delete(ctx context.Context, bc *BusinessCollaborator) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	stmt := `DELETE FROM businesses_collaborators WHERE id = ?`
-	_, err := db.Exec(stmt, bc.ID)
+	_, err := s.db.ExecContext(ctx, stmt, bc.ID)
 	return err
 }
@@ -18,17 +18,47 @@ type database struct {
 	Host     string
 }
 
-// Create and connect to mysql db
+// Create and connect to mysql db, refusing to start with pending migrations
+// unless DB_AUTO_MIGRATE is enabled
 func Init() *sql.DB {
+	db := Open()
+
+	if env.GetBool("DB_AUTO_MIGRATE", false) {
+		applied, err := MigrateUp(db)
+		if err != nil {
+			log.Fatalf("Error %s when migrating DB\n", err)
+		}
+		for _, m := range applied {
+			log.Printf("Applied migration %04d_%s", m.Version, m.Name)
+		}
+		return db
+	}
+
+	pending, err := GetPendingMigrations(db)
+	if err != nil {
+		log.Fatalf("Error %s when checking DB migrations\n", err)
+	}
+
+	if len(pending) > 0 {
+		log.Fatalf("DB has %d pending migrations, run 'mercado_lobito migrate up' or set DB_AUTO_MIGRATE=true\n", len(pending))
+	}
+
+	return db
+}
+
+// Connect to mysql db without checking its schema
+func Open() *sql.DB {
 	var dbVariables *database = getVariables()
 
-	connString := fmt.Sprintf("%s:%s@tcp(%s)/%s", dbVariables.User, dbVariables.Password, dbVariables.Host, dbVariables.Name)
+	connString := fmt.Sprintf("%s:%s@tcp(%s)/%s?parseTime=true", dbVariables.User, dbVariables.Password, dbVariables.Host, dbVariables.Name)
 
 	db, err := sql.Open("mysql", connString)
 	if err != nil {
 		log.Fatalf("Error %s when opening DB\n", err)
 	}
 
+	configurePool(db)
+
 	ctx, cancelFunc := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancelFunc()
 
@@ -44,105 +74,15 @@ func Init() *sql.DB {
 
 	log.Print("Connected to DB successfully")
 
-	err = createSchema(db)
-	if err != nil {
-		log.Fatalf("Error %s when creating scheme in DB\n", err)
-	}
-
 	return db
 }
 
-// Create Database Scheme
-func createSchema(db *sql.DB) error {
-	tables := []string{
-		`
-		CREATE TABLE IF NOT EXISTS users (
-			id INT AUTO_INCREMENT PRIMARY KEY,
-			middle_names VARCHAR(100) NOT NULL,
-			paternal_surname VARCHAR(100) NOT NULL,
-			maternal_surname VARCHAR(100) DEFAULT NULL,
-			personal_id CHAR(100) DEFAULT NULL,
-			email VARCHAR(150) NOT NULL,
-			hash CHAR(255) NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			update_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
-		);
-		`,
-		`
-		CREATE TABLE IF NOT EXISTS admins (
-			id INT AUTO_INCREMENT PRIMARY KEY,
-			user_id INT,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			update_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-		);
-		`,
-		`
-		CREATE TABLE IF NOT EXISTS students (
-			id CHAR(10) PRIMARY KEY,
-			grade VARCHAR(20) NOT NULL,
-			class_group VARCHAR(10) NOT NULL,
-			user_id INT,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			update_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-		);
-		`,
-		`
-		CREATE TABLE IF NOT EXISTS businesses (
-			id INT AUTO_INCREMENT PRIMARY KEY,
-			name VARCHAR(100) NOT NULL,
-			type VARCHAR(100) DEFAULT NULL,
-			description TEXT DEFAULT NULL,
-			owner_id CHAR(10),
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			update_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-			FOREIGN KEY (owner_id) REFERENCES students(id) ON DELETE CASCADE
-		);
-		`,
-		`
-		CREATE TABLE IF NOT EXISTS businesses_collaborators (
-			id INT AUTO_INCREMENT PRIMARY KEY,
-			business_id INT,
-			collaborator_id INT,
-			FOREIGN KEY (business_id) REFERENCES businesses(id) ON DELETE CASCADE,
-			FOREIGN KEY (collaborator_id) REFERENCES users(id) ON DELETE CASCADE
-		);
-		`,
-		`
-		CREATE TABLE IF NOT EXISTS products (
-			id INT AUTO_INCREMENT PRIMARY KEY,
-			title VARCHAR(100) NOT NULL,
-			price DECIMAL(10,2) NOT NULL,
-			stock INT NOT NULL DEFAULT 0,
-			business_id INT,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			update_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-			FOREIGN KEY (business_id) REFERENCES businesses(id) ON DELETE CASCADE
-		);
-		`,
-		`
-		CREATE TABLE IF NOT EXISTS sessions (
-			id INT AUTO_INCREMENT PRIMARY KEY,
-			uuid CHAR(255) NOT NULL,
-			user_id INT NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			update_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-		);
-		`,
-	}
-
-	for _, table := range tables {
-		stmt, err := db.Prepare(table)
-		if err != nil {
-			return err
-		}
-
-		stmt.Exec()
-	}
-
-	return nil
+// Pool limits, the same *sql.DB is shared by every request
+func configurePool(db *sql.DB) {
+	db.SetMaxOpenConns(env.GetInt("DB_MAX_OPEN_CONNS", 25))
+	db.SetMaxIdleConns(env.GetInt("DB_MAX_IDLE_CONNS", 25))
+	db.SetConnMaxLifetime(env.GetDuration("DB_CONN_MAX_LIFETIME", 5*time.Minute))
+	db.SetConnMaxIdleTime(env.GetDuration("DB_CONN_MAX_IDLE_TIME", time.Minute))
 }
 
 func getVariables() *database {
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// Cost of opening a connection to the stub driver, standing in for the TCP
+// and auth handshake with mysql
+const stubConnectCost = time.Millisecond
+
+func init() {
+	sql.Register("stub", stubDriver{})
+}
+
+// Driver whose connections take stubConnectCost to open and whose queries
+// return nothing right away, so only connection handling is measured
+type stubDriver struct{}
+
+func (stubDriver) Open(string) (driver.Conn, error) {
+	time.Sleep(stubConnectCost)
+	return stubConn{}, nil
+}
+
+type stubConn struct{}
+
+func (stubConn) Prepare(string) (driver.Stmt, error) { return stubStmt{}, nil }
+func (stubConn) Close() error                        { return nil }
+func (stubConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+type stubStmt struct{}
+
+func (stubStmt) Close() error                               { return nil }
+func (stubStmt) NumInput() int                              { return -1 }
+func (stubStmt) Exec([]driver.Value) (driver.Result, error) { return driver.RowsAffected(0), nil }
+func (stubStmt) Query([]driver.Value) (driver.Rows, error)  { return stubRows{}, nil }
+
+type stubRows struct{}
+
+func (stubRows) Columns() []string         { return nil }
+func (stubRows) Close() error              { return nil }
+func (stubRows) Next([]driver.Value) error { return io.EOF }
+
+// A request's worth of work: one lookup through the store, like the session
+// check every page does
+func lookup(b *testing.B, store *Store) {
+	u := User{ID: 1}
+	if err := store.Users.GetByID(context.Background(), &u); !errors.Is(err, ErrUserNotFound) {
+		b.Fatal(err)
+	}
+}
+
+// Both benchmarks serve requests concurrently, as the server does, and do
+// the same lookup per request so their ns/op compare
+
+// What handlers did before sharing the pool: open one per request, create
+// the database, ping, query and close
+func BenchmarkPoolPerRequest(b *testing.B) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			db, err := sql.Open("stub", "")
+			if err != nil {
+				b.Fatal(err)
+			}
+			configurePool(db)
+			if _, err := db.Exec("CREATE DATABASE IF NOT EXISTS bench"); err != nil {
+				b.Fatal(err)
+			}
+			if err := db.Ping(); err != nil {
+				b.Fatal(err)
+			}
+			lookup(b, NewMySQLStore(db))
+			db.Close()
+		}
+	})
+}
+
+// One pool for every request, each query under DB_QUERY_TIMEOUT
+func BenchmarkPoolShared(b *testing.B) {
+	db, err := sql.Open("stub", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	configurePool(db)
+	store := NewMySQLStore(db)
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			lookup(b, store)
+		}
+	})
+}
+
+// The same two against a real mysql, when MYSQL_HOST and the rest of its
+// variables are set. Open creates the database if it's missing.
+func BenchmarkMySQL(b *testing.B) {
+	if os.Getenv("MYSQL_HOST") == "" {
+		b.Skip("MYSQL_HOST not set")
+	}
+
+	b.Run("per request", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				db := Open()
+				lookup(b, NewMySQLStore(db))
+				db.Close()
+			}
+		})
+	})
+
+	b.Run("shared", func(b *testing.B) {
+		db := Open()
+		defer db.Close()
+		store := NewMySQLStore(db)
+
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				lookup(b, store)
+			}
+		})
+	})
+}
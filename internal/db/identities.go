@@ -0,0 +1,65 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Account at an OpenID Connect provider, identified by the provider's
+// issuer URL and its sub claim
+type Identity struct {
+	ID        int
+	UserID    int
+	Issuer    string
+	Subject   string
+	CreatedAt time.Time
+}
+
+type IdentityStore interface {
+	Set(ctx context.Context, i *Identity) error
+	// Find by i.Issuer and i.Subject
+	Get(ctx context.Context, i *Identity) error
+}
+
+type mysqlIdentityStore struct {
+	conn
+}
+
+func (s *mysqlIdentityStore) Set(ctx context.Context, i *Identity) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `INSERT INTO user_identities(user_id, issuer, subject, created_at) VALUES (?, ?, ?, ?)`
+	res, err := s.db.ExecContext(ctx, stmt, i.UserID, i.Issuer, i.Subject, i.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err == nil {
+		i.ID = int(id)
+	}
+
+	return nil
+}
+
+func (s *mysqlIdentityStore) Get(ctx context.Context, i *Identity) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `
+		SELECT id, user_id, issuer, subject, created_at
+		FROM user_identities
+		WHERE issuer = ? AND subject = ?
+	`
+	err := s.db.QueryRowContext(ctx, stmt, i.Issuer, i.Subject).Scan(&i.ID, &i.UserID, &i.Issuer, &i.Subject, &i.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrIdentityNotFound
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type InvitationStatus string
+
+const (
+	InvitationPending  InvitationStatus = "pending"
+	InvitationAccepted InvitationStatus = "accepted"
+	InvitationDeclined InvitationStatus = "declined"
+	InvitationRevoked  InvitationStatus = "revoked"
+)
+
+// Invite for someone to join a business as a collaborator
+type Invitation struct {
+	ID         int
+	BusinessID int
+	Email      string
+	Permission Permission
+	// Part of the emailed token, a new one invalidates earlier links
+	Nonce  string
+	Status InvitationStatus
+	// Zero once the account that sent it is deleted
+	InvitedBy int
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+type InvitationStore interface {
+	Set(ctx context.Context, i *Invitation) error
+	Get(ctx context.Context, i *Invitation) error
+	// Pending invitation for i.BusinessID and i.Email
+	GetPending(ctx context.Context, i *Invitation) error
+	ListPendingByBusinessID(ctx context.Context, businessID int) ([]Invitation, error)
+	// Update Nonce, Status and ExpiresAt
+	Update(ctx context.Context, i *Invitation) error
+}
+
+type mysqlInvitationStore struct {
+	conn
+}
+
+const invitationColumns = `id, business_id, email, permission, nonce, status, invited_by, expires_at, created_at`
+
+func scanInvitation(row interface{ Scan(...any) error }, i *Invitation) error {
+	var invitedBy sql.NullInt64
+	if err := row.Scan(&i.ID, &i.BusinessID, &i.Email, &i.Permission, &i.Nonce, &i.Status, &invitedBy, &i.ExpiresAt, &i.CreatedAt); err != nil {
+		return err
+	}
+	i.InvitedBy = int(invitedBy.Int64)
+	return nil
+}
+
+func (s *mysqlInvitationStore) Set(ctx context.Context, i *Invitation) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := s.db.PrepareContext(ctx, `
+		INSERT INTO invitations(business_id, email, permission, nonce, status, invited_by, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var invitedBy sql.NullInt64
+	if i.InvitedBy != 0 {
+		invitedBy = sql.NullInt64{Int64: int64(i.InvitedBy), Valid: true}
+	}
+
+	res, err := stmt.ExecContext(ctx, i.BusinessID, i.Email, i.Permission, i.Nonce, i.Status, invitedBy, i.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err == nil {
+		i.ID = int(id)
+	}
+
+	return nil
+}
+
+func (s *mysqlInvitationStore) Get(ctx context.Context, i *Invitation) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT ` + invitationColumns + ` FROM invitations WHERE id = ?`
+	err := scanInvitation(s.db.QueryRowContext(ctx, stmt, i.ID), i)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvitationNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *mysqlInvitationStore) GetPending(ctx context.Context, i *Invitation) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `
+		SELECT ` + invitationColumns + `
+		FROM invitations
+		WHERE business_id = ? AND email = ? AND status = ?
+		ORDER BY id DESC
+		LIMIT 1
+	`
+	err := scanInvitation(s.db.QueryRowContext(ctx, stmt, i.BusinessID, i.Email, InvitationPending), i)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvitationNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *mysqlInvitationStore) ListPendingByBusinessID(ctx context.Context, businessID int) ([]Invitation, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `
+		SELECT ` + invitationColumns + `
+		FROM invitations
+		WHERE business_id = ? AND status = ?
+		ORDER BY id
+	`
+	rows, err := s.db.QueryContext(ctx, stmt, businessID, InvitationPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []Invitation
+	for rows.Next() {
+		var i Invitation
+		if err := scanInvitation(rows, &i); err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+func (s *mysqlInvitationStore) Update(ctx context.Context, i *Invitation) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE invitations SET nonce = ?, status = ?, expires_at = ? WHERE id = ?`
+	_, err := s.db.ExecContext(ctx, stmt, i.Nonce, i.Status, i.ExpiresAt, i.ID)
+	return err
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Sign in attempt, kept for auditing and to throttle guessing
+type LoginEvent struct {
+	ID int
+	// Zero when the identifier didn't match any account
+	UserID     int
+	Identifier string
+	IP         string
+	UserAgent  string
+	Success    bool
+	CreatedAt  time.Time
+}
+
+// Failed attempts counted for one account or IP
+type LoginFailures struct {
+	Count int
+	Last  time.Time
+}
+
+type LoginEventStore interface {
+	Set(ctx context.Context, e *LoginEvent) error
+	// Failures since `since` for the user, or for the identifier when userID
+	// is zero. A successful sign in starts the count over.
+	AccountFailures(ctx context.Context, userID int, identifier string, since time.Time) (LoginFailures, error)
+	// Failures from ip since `since`, successes don't reset them
+	IPFailures(ctx context.Context, ip string, since time.Time) (LoginFailures, error)
+}
+
+type mysqlLoginEventStore struct {
+	conn
+}
+
+func (s *mysqlLoginEventStore) Set(ctx context.Context, e *LoginEvent) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var userID sql.NullInt64
+	if e.UserID != 0 {
+		userID = sql.NullInt64{Int64: int64(e.UserID), Valid: true}
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO login_events(user_id, identifier, ip, user_agent, success, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, userID, e.Identifier, e.IP, e.UserAgent, e.Success, e.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err == nil {
+		e.ID = int(id)
+	}
+
+	return nil
+}
+
+func (s *mysqlLoginEventStore) AccountFailures(ctx context.Context, userID int, identifier string, since time.Time) (LoginFailures, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	match := `user_id = ?`
+	arg := any(userID)
+	if userID == 0 {
+		match = `user_id IS NULL AND identifier = ?`
+		arg = identifier
+	}
+
+	stmt := `
+		SELECT COUNT(*), MAX(created_at)
+		FROM login_events
+		WHERE ` + match + ` AND success = FALSE AND created_at > GREATEST(?, COALESCE(
+			(SELECT MAX(created_at) FROM login_events WHERE ` + match + ` AND success = TRUE),
+			?
+		))
+	`
+	return scanLoginFailures(s.db.QueryRowContext(ctx, stmt, arg, since, arg, since))
+}
+
+func (s *mysqlLoginEventStore) IPFailures(ctx context.Context, ip string, since time.Time) (LoginFailures, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `
+		SELECT COUNT(*), MAX(created_at)
+		FROM login_events
+		WHERE ip = ? AND success = FALSE AND created_at > ?
+	`
+	return scanLoginFailures(s.db.QueryRowContext(ctx, stmt, ip, since))
+}
+
+func scanLoginFailures(row *sql.Row) (LoginFailures, error) {
+	var f LoginFailures
+	var last sql.NullTime
+	if err := row.Scan(&f.Count, &last); err != nil {
+		return LoginFailures{}, err
+	}
+	f.Last = last.Time
+	return f, nil
+}
@@ -0,0 +1,1389 @@
+// Package memory is an in-memory db.Store, used to run handlers without mysql
+package memory
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/google/uuid"
+)
+
+// Rows of every table, guarded by one lock so joins see a consistent state
+type data struct {
+	mu sync.RWMutex
+	// Held for the whole of a transaction, so transactions run one at a time
+	txMu sync.Mutex
+
+	users                 map[int]db.User
+	students              map[string]db.Student
+	businesses            map[int]db.Business
+	products              map[int]db.Product
+	productImages         map[int]db.ProductImage
+	sessions              map[string]db.Session
+	businessCollaborators map[int]db.BusinessCollaborator
+	admins                map[int]db.Admin
+	invitations           map[int]db.Invitation
+	passwordResets        map[int]passwordReset
+	loginEvents           map[int]db.LoginEvent
+	totp                  map[int]db.TOTP
+	recoveryCodes         map[int]recoveryCode
+	apiTokens             map[int]db.APIToken
+	identities            map[int]db.Identity
+
+	lastID int
+}
+
+func (d *data) nextID() int {
+	d.lastID++
+	return d.lastID
+}
+
+func New() *db.Store {
+	d := &data{
+		users:                 map[int]db.User{},
+		students:              map[string]db.Student{},
+		businesses:            map[int]db.Business{},
+		products:              map[int]db.Product{},
+		productImages:         map[int]db.ProductImage{},
+		sessions:              map[string]db.Session{},
+		businessCollaborators: map[int]db.BusinessCollaborator{},
+		admins:                map[int]db.Admin{},
+		invitations:           map[int]db.Invitation{},
+		passwordResets:        map[int]passwordReset{},
+		loginEvents:           map[int]db.LoginEvent{},
+		totp:                  map[int]db.TOTP{},
+		recoveryCodes:         map[int]recoveryCode{},
+		apiTokens:             map[int]db.APIToken{},
+		identities:            map[int]db.Identity{},
+	}
+
+	store := newStore(d)
+	txStore := newStore(d)
+	txStore.Transactor = joinedTx{txStore}
+	store.Transactor = &transactor{data: d, txStore: txStore}
+	return store
+}
+
+func newStore(d *data) *db.Store {
+	return &db.Store{
+		Users:                 &userStore{d},
+		Students:              &studentStore{d},
+		Businesses:            &businessStore{d},
+		Products:              &productStore{d},
+		ProductImages:         &productImageStore{d},
+		Sessions:              &sessionStore{d},
+		BusinessCollaborators: &businessCollaboratorStore{d},
+		Admins:                &adminStore{d},
+		Invitations:           &invitationStore{d},
+		PasswordResets:        &passwordResetStore{d},
+		LoginEvents:           &loginEventStore{d},
+		TOTP:                  &totpStore{d},
+		APITokens:             &apiTokenStore{d},
+		Identities:            &identityStore{d},
+	}
+}
+
+// Transactions write straight to the shared maps and restore a snapshot
+// taken at the start when fn fails. Reads outside the transaction can see
+// uncommitted rows, which is fine for tests.
+type transactor struct {
+	*data
+	txStore *db.Store
+}
+
+func (t *transactor) WithTx(_ context.Context, fn func(tx *db.Store) error) error {
+	t.txMu.Lock()
+	defer t.txMu.Unlock()
+
+	t.mu.RLock()
+	snapshot := t.clone()
+	t.mu.RUnlock()
+
+	if err := fn(t.txStore); err != nil {
+		t.mu.Lock()
+		t.restore(snapshot)
+		t.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+type joinedTx struct {
+	store *db.Store
+}
+
+func (j joinedTx) WithTx(_ context.Context, fn func(tx *db.Store) error) error {
+	return fn(j.store)
+}
+
+func (d *data) clone() *data {
+	return &data{
+		users:                 maps.Clone(d.users),
+		students:              maps.Clone(d.students),
+		businesses:            maps.Clone(d.businesses),
+		products:              maps.Clone(d.products),
+		productImages:         maps.Clone(d.productImages),
+		sessions:              maps.Clone(d.sessions),
+		businessCollaborators: maps.Clone(d.businessCollaborators),
+		admins:                maps.Clone(d.admins),
+		invitations:           maps.Clone(d.invitations),
+		passwordResets:        maps.Clone(d.passwordResets),
+		loginEvents:           maps.Clone(d.loginEvents),
+		totp:                  maps.Clone(d.totp),
+		recoveryCodes:         maps.Clone(d.recoveryCodes),
+		apiTokens:             maps.Clone(d.apiTokens),
+		identities:            maps.Clone(d.identities),
+		lastID:                d.lastID,
+	}
+}
+
+func (d *data) restore(snapshot *data) {
+	d.users = snapshot.users
+	d.students = snapshot.students
+	d.businesses = snapshot.businesses
+	d.products = snapshot.products
+	d.productImages = snapshot.productImages
+	d.sessions = snapshot.sessions
+	d.businessCollaborators = snapshot.businessCollaborators
+	d.admins = snapshot.admins
+	d.invitations = snapshot.invitations
+	d.passwordResets = snapshot.passwordResets
+	d.loginEvents = snapshot.loginEvents
+	d.totp = snapshot.totp
+	d.recoveryCodes = snapshot.recoveryCodes
+	d.apiTokens = snapshot.apiTokens
+	d.identities = snapshot.identities
+	d.lastID = snapshot.lastID
+}
+
+type userStore struct{ *data }
+
+func (s *userStore) Set(_ context.Context, u *db.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.emailTaken(u.Email, 0) {
+		return db.ErrEmailTaken
+	}
+
+	u.ID = s.nextID()
+	s.users[u.ID] = *u
+	return nil
+}
+
+func (s *userStore) GetByID(_ context.Context, u *db.User) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found, ok := s.users[u.ID]
+	if !ok {
+		return db.ErrUserNotFound
+	}
+	*u = found
+	return nil
+}
+
+func (s *userStore) GetByEmail(_ context.Context, u *db.User) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, found := range sortedUsers(s.users) {
+		if strings.EqualFold(found.Email, u.Email) {
+			*u = found
+			return nil
+		}
+	}
+	return db.ErrUserNotFound
+}
+
+func (s *userStore) Update(_ context.Context, u *db.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.emailTaken(u.Email, u.ID) {
+		return db.ErrEmailTaken
+	}
+
+	if found, ok := s.users[u.ID]; ok {
+		updated := *u
+		updated.EmailVerifiedAt = found.EmailVerifiedAt
+		updated.PhotoKey = found.PhotoKey
+		s.users[u.ID] = updated
+	}
+	return nil
+}
+
+func (s *userStore) SetPhotoKey(_ context.Context, u *db.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if found, ok := s.users[u.ID]; ok {
+		found.PhotoKey = u.PhotoKey
+		s.users[u.ID] = found
+	}
+	return nil
+}
+
+// Mirror the unique index on users(email), which compares case-insensitively
+func (s *userStore) emailTaken(email string, exceptID int) bool {
+	for id, found := range s.users {
+		if id != exceptID && strings.EqualFold(found.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *userStore) SetEmailVerified(_ context.Context, u *db.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if found, ok := s.users[u.ID]; ok {
+		found.EmailVerifiedAt = u.EmailVerifiedAt
+		s.users[u.ID] = found
+	}
+	return nil
+}
+
+func (s *userStore) Delete(_ context.Context, u *db.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.users, u.ID)
+	s.cascadeUser(u.ID)
+	return nil
+}
+
+// Mirror the ON DELETE CASCADE foreign keys on users(id)
+func (d *data) cascadeUser(userID int) {
+	for id, student := range d.students {
+		if student.UserID == userID {
+			delete(d.students, id)
+			d.cascadeStudent(id)
+		}
+	}
+	for id, session := range d.sessions {
+		if session.UserID == userID {
+			delete(d.sessions, id)
+		}
+	}
+	for id, bc := range d.businessCollaborators {
+		if bc.CollaboratorID == userID {
+			delete(d.businessCollaborators, id)
+		}
+	}
+	for id, admin := range d.admins {
+		if admin.UserID == userID {
+			delete(d.admins, id)
+		}
+	}
+	for id, i := range d.invitations {
+		if i.InvitedBy == userID {
+			i.InvitedBy = 0
+			d.invitations[id] = i
+		}
+	}
+	for id, pr := range d.passwordResets {
+		if pr.UserID == userID {
+			delete(d.passwordResets, id)
+		}
+	}
+	for id, e := range d.loginEvents {
+		if e.UserID == userID {
+			delete(d.loginEvents, id)
+		}
+	}
+	delete(d.totp, userID)
+	for id, c := range d.recoveryCodes {
+		if c.userID == userID {
+			delete(d.recoveryCodes, id)
+		}
+	}
+	for id, t := range d.apiTokens {
+		if t.UserID == userID {
+			delete(d.apiTokens, id)
+		}
+	}
+	for id, i := range d.identities {
+		if i.UserID == userID {
+			delete(d.identities, id)
+		}
+	}
+}
+
+// Mirror the ON DELETE CASCADE foreign key on students(id)
+func (d *data) cascadeStudent(studentID string) {
+	for id, business := range d.businesses {
+		if business.OwnerID == studentID {
+			delete(d.businesses, id)
+			d.cascadeBusiness(id)
+		}
+	}
+}
+
+// Mirror the ON DELETE CASCADE foreign keys on businesses(id)
+func (d *data) cascadeBusiness(businessID int) {
+	for id, product := range d.products {
+		if product.BusinessID == businessID {
+			delete(d.products, id)
+			d.cascadeProduct(id)
+		}
+	}
+	for id, bc := range d.businessCollaborators {
+		if bc.BusinessID == businessID {
+			delete(d.businessCollaborators, id)
+		}
+	}
+	for id, i := range d.invitations {
+		if i.BusinessID == businessID {
+			delete(d.invitations, id)
+		}
+	}
+}
+
+// Mirror the ON DELETE CASCADE foreign key on products(id)
+func (d *data) cascadeProduct(productID int) {
+	for id, img := range d.productImages {
+		if img.ProductID == productID {
+			delete(d.productImages, id)
+		}
+	}
+}
+
+type studentStore struct{ *data }
+
+func (s *studentStore) Set(_ context.Context, st *db.Student) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.students[st.ID]; ok {
+		return fmt.Errorf("student '%s' already exists", st.ID)
+	}
+	s.students[st.ID] = *st
+	return nil
+}
+
+func (s *studentStore) GetByID(_ context.Context, st *db.Student) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found, ok := s.students[st.ID]
+	if !ok {
+		return db.ErrStudentNotFound
+	}
+	*st = found
+	return nil
+}
+
+func (s *studentStore) GetByUserID(_ context.Context, st *db.Student) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, found := range s.students {
+		if found.UserID == st.UserID {
+			*st = found
+			return nil
+		}
+	}
+	return db.ErrStudentNotFound
+}
+
+func (s *studentStore) List(_ context.Context) ([]db.Student, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	students := make([]db.Student, 0, len(s.students))
+	for _, st := range s.students {
+		students = append(students, st)
+	}
+	sort.Slice(students, func(i, j int) bool { return students[i].ID < students[j].ID })
+	return students, nil
+}
+
+func (s *studentStore) Update(_ context.Context, st *db.Student) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found, ok := s.students[st.ID]
+	if ok {
+		found.Grade = st.Grade
+		found.ClassGroup = st.ClassGroup
+		s.students[st.ID] = found
+	}
+	return nil
+}
+
+func (s *studentStore) Delete(_ context.Context, st *db.Student) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.students, st.ID)
+	s.cascadeStudent(st.ID)
+	return nil
+}
+
+type businessStore struct{ *data }
+
+func (s *businessStore) Set(_ context.Context, b *db.Business) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b.ID = s.nextID()
+	s.businesses[b.ID] = *b
+	return nil
+}
+
+func (s *businessStore) Get(_ context.Context, b *db.Business) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found, ok := s.businesses[b.ID]
+	if !ok {
+		return db.ErrBusinessNotFound
+	}
+	*b = found
+	return nil
+}
+
+func (s *businessStore) GetByOwnerID(_ context.Context, b *db.Business) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found, ok := s.businessByOwner(b.OwnerID)
+	if !ok {
+		return db.ErrBusinessNotFound
+	}
+	*b = found
+	return nil
+}
+
+func (s *businessStore) List(_ context.Context) ([]db.Business, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	businesses := make([]db.Business, 0, len(s.businesses))
+	for _, b := range s.businesses {
+		businesses = append(businesses, b)
+	}
+	sort.Slice(businesses, func(i, j int) bool { return businesses[i].Name < businesses[j].Name })
+	return businesses, nil
+}
+
+func (d *data) businessByOwner(ownerID string) (db.Business, bool) {
+	ids := make([]int, 0, len(d.businesses))
+	for id := range d.businesses {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		if d.businesses[id].OwnerID == ownerID {
+			return d.businesses[id], true
+		}
+	}
+	return db.Business{}, false
+}
+
+func (s *businessStore) GetProductsByOwnerID(_ context.Context, b *db.Business) ([]db.Product, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var products []db.Product
+	for _, p := range sortedProducts(s.products) {
+		business, ok := s.businesses[p.BusinessID]
+		if ok && business.OwnerID == b.OwnerID {
+			products = append(products, p)
+		}
+	}
+	return products, nil
+}
+
+func (s *businessStore) GetCollaboratorsByBusinessID(_ context.Context, b *db.Business) ([]db.Collaborator, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var collaborators []db.Collaborator
+	for _, bc := range sortedCollaborators(s.businessCollaborators) {
+		if bc.BusinessID != b.ID {
+			continue
+		}
+		if u, ok := s.users[bc.CollaboratorID]; ok {
+			u.Hash = ""
+			collaborators = append(collaborators, db.Collaborator{User: u, Permission: bc.Permission})
+		}
+	}
+	return collaborators, nil
+}
+
+func (s *businessStore) Update(_ context.Context, b *db.Business) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found, ok := s.businesses[b.ID]
+	if ok {
+		found.Name = b.Name
+		found.Type = b.Type
+		found.Description = b.Description
+		s.businesses[b.ID] = found
+	}
+	return nil
+}
+
+func (s *businessStore) TransferOwnership(_ context.Context, b *db.Business) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found, ok := s.businesses[b.ID]
+	if ok {
+		found.OwnerID = b.OwnerID
+		s.businesses[b.ID] = found
+	}
+	return nil
+}
+
+func (s *businessStore) Delete(_ context.Context, b *db.Business) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.businesses, b.ID)
+	s.cascadeBusiness(b.ID)
+	return nil
+}
+
+type productStore struct{ *data }
+
+func (s *productStore) Set(_ context.Context, p *db.Product) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p.ID = s.nextID()
+	s.products[p.ID] = *p
+	return nil
+}
+
+func (s *productStore) GetByID(_ context.Context, p *db.Product) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found, ok := s.products[p.ID]
+	if !ok {
+		return db.ErrProductNotFound
+	}
+	*p = found
+	return nil
+}
+
+func (s *productStore) Update(_ context.Context, p *db.Product) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found, ok := s.products[p.ID]
+	if ok {
+		found.Title = p.Title
+		found.Price = p.Price
+		found.Stock = p.Stock
+		s.products[p.ID] = found
+	}
+	return nil
+}
+
+func (s *productStore) Delete(_ context.Context, p *db.Product) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.products, p.ID)
+	s.cascadeProduct(p.ID)
+	return nil
+}
+
+type productImageStore struct{ *data }
+
+func (s *productImageStore) Set(_ context.Context, img *db.ProductImage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	img.ID = s.nextID()
+	s.productImages[img.ID] = *img
+	return nil
+}
+
+func (s *productImageStore) GetByID(_ context.Context, img *db.ProductImage) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found, ok := s.productImages[img.ID]
+	if !ok {
+		return db.ErrProductImageNotFound
+	}
+	*img = found
+	return nil
+}
+
+func (s *productImageStore) GetByProductID(_ context.Context, productID int) ([]db.ProductImage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var images []db.ProductImage
+	for _, img := range sortedProductImages(s.productImages) {
+		if img.ProductID == productID {
+			images = append(images, img)
+		}
+	}
+	return images, nil
+}
+
+func (s *productImageStore) GetByBusinessID(_ context.Context, businessID int) ([]db.ProductImage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var images []db.ProductImage
+	for _, img := range sortedProductImages(s.productImages) {
+		if s.products[img.ProductID].BusinessID == businessID {
+			images = append(images, img)
+		}
+	}
+	return images, nil
+}
+
+// Transactions already run one at a time, nothing to lock
+func (s *productImageStore) GetByBusinessIDForUpdate(ctx context.Context, businessID int) ([]db.ProductImage, error) {
+	return s.GetByBusinessID(ctx, businessID)
+}
+
+// Transactions already run one at a time, nothing to lock
+func (s *productImageStore) CountForUpdate(_ context.Context, productID int) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.products[productID]; !ok {
+		return 0, db.ErrProductNotFound
+	}
+	count := 0
+	for _, img := range s.productImages {
+		if img.ProductID == productID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *productImageStore) Reorder(_ context.Context, productID int, ids []int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for position, id := range ids {
+		if img, ok := s.productImages[id]; ok && img.ProductID == productID {
+			img.Position = position
+			s.productImages[id] = img
+		}
+	}
+	return nil
+}
+
+func (s *productImageStore) SetCover(_ context.Context, img *db.ProductImage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, found := range s.productImages {
+		if found.ProductID == img.ProductID {
+			found.IsCover = id == img.ID
+			s.productImages[id] = found
+		}
+	}
+	img.IsCover = true
+	return nil
+}
+
+func (s *productImageStore) Delete(_ context.Context, img *db.ProductImage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.productImages, img.ID)
+	return nil
+}
+
+type sessionStore struct{ *data }
+
+func (s *sessionStore) Set(_ context.Context, session *db.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session.UUID == "" {
+		session.UUID = uuid.New().String()
+	}
+	session.ID = s.nextID()
+	s.sessions[session.UUID] = *session
+	return nil
+}
+
+func (s *sessionStore) Get(_ context.Context, session *db.Session) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found, ok := s.sessions[session.UUID]
+	if !ok {
+		return db.ErrSessionNotFound
+	}
+	*session = found
+	return nil
+}
+
+func (s *sessionStore) ListByUserID(_ context.Context, userID int) ([]db.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sessions []db.Session
+	for _, session := range s.sessions {
+		if session.UserID == userID {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastSeenAt.Equal(sessions[j].LastSeenAt) {
+			return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+		}
+		return sessions[i].ID > sessions[j].ID
+	})
+	return sessions, nil
+}
+
+func (s *sessionStore) Touch(_ context.Context, session *db.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found, ok := s.sessions[session.UUID]
+	if ok {
+		found.LastSeenAt = session.LastSeenAt
+		s.sessions[session.UUID] = found
+	}
+	return nil
+}
+
+func (s *sessionStore) Delete(_ context.Context, session *db.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, session.UUID)
+	return nil
+}
+
+func (s *sessionStore) DeleteForUser(_ context.Context, session *db.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, found := range s.sessions {
+		if found.ID == session.ID && found.UserID == session.UserID {
+			delete(s.sessions, id)
+			return nil
+		}
+	}
+	return db.ErrSessionNotFound
+}
+
+func (s *sessionStore) DeleteOthers(_ context.Context, userID int, keepUUID string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for id, session := range s.sessions {
+		if session.UserID == userID && id != keepUUID {
+			delete(s.sessions, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *sessionStore) DeleteByUserID(_ context.Context, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, session := range s.sessions {
+		if session.UserID == userID {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (s *sessionStore) DeleteExpired(_ context.Context, now time.Time, idleSince time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for id, session := range s.sessions {
+		if !session.ExpiresAt.After(now) || !session.LastSeenAt.After(idleSince) {
+			delete(s.sessions, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+type businessCollaboratorStore struct{ *data }
+
+func (s *businessCollaboratorStore) Set(_ context.Context, bc *db.BusinessCollaborator) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bc.ID = s.nextID()
+	s.businessCollaborators[bc.ID] = *bc
+	return nil
+}
+
+func (s *businessCollaboratorStore) Get(_ context.Context, bc *db.BusinessCollaborator) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found, ok := s.businessCollaborators[bc.ID]
+	if !ok {
+		return db.ErrCollaboratorNotFound
+	}
+	*bc = found
+	return nil
+}
+
+func (s *businessCollaboratorStore) GetByBusinessAndCollaborator(_ context.Context, bc *db.BusinessCollaborator) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, found := range sortedCollaborators(s.businessCollaborators) {
+		if found.BusinessID == bc.BusinessID && found.CollaboratorID == bc.CollaboratorID {
+			*bc = found
+			return nil
+		}
+	}
+	return db.ErrCollaboratorNotFound
+}
+
+func (s *businessCollaboratorStore) GetByCollaboratorID(_ context.Context, collaboratorID int) ([]db.BusinessCollaborator, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var memberships []db.BusinessCollaborator
+	for _, bc := range sortedCollaborators(s.businessCollaborators) {
+		if bc.CollaboratorID == collaboratorID {
+			memberships = append(memberships, bc)
+		}
+	}
+	return memberships, nil
+}
+
+func (s *businessCollaboratorStore) UpdatePermission(_ context.Context, bc *db.BusinessCollaborator) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found, ok := s.businessCollaborators[bc.ID]
+	if ok {
+		found.Permission = bc.Permission
+		s.businessCollaborators[bc.ID] = found
+	}
+	return nil
+}
+
+func (s *businessCollaboratorStore) Delete(_ context.Context, bc *db.BusinessCollaborator) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.businessCollaborators, bc.ID)
+	return nil
+}
+
+type adminStore struct{ *data }
+
+func (s *adminStore) Set(_ context.Context, a *db.Admin) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a.ID = s.nextID()
+	s.admins[a.ID] = *a
+	return nil
+}
+
+func (s *adminStore) GetByUserID(_ context.Context, a *db.Admin) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, found := range s.admins {
+		if found.UserID == a.UserID {
+			*a = found
+			return nil
+		}
+	}
+	return db.ErrAdminNotFound
+}
+
+func (s *adminStore) Delete(_ context.Context, a *db.Admin) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.admins, a.ID)
+	return nil
+}
+
+type invitationStore struct{ *data }
+
+func (s *invitationStore) Set(_ context.Context, i *db.Invitation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i.ID = s.nextID()
+	i.CreatedAt = time.Now()
+	s.invitations[i.ID] = *i
+	return nil
+}
+
+func (s *invitationStore) Get(_ context.Context, i *db.Invitation) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found, ok := s.invitations[i.ID]
+	if !ok {
+		return db.ErrInvitationNotFound
+	}
+	*i = found
+	return nil
+}
+
+func (s *invitationStore) GetPending(_ context.Context, i *db.Invitation) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found := false
+	for _, inv := range sortedInvitations(s.invitations) {
+		if inv.BusinessID == i.BusinessID && inv.Email == i.Email && inv.Status == db.InvitationPending {
+			*i = inv
+			found = true
+		}
+	}
+	if !found {
+		return db.ErrInvitationNotFound
+	}
+	return nil
+}
+
+func (s *invitationStore) ListPendingByBusinessID(_ context.Context, businessID int) ([]db.Invitation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var invitations []db.Invitation
+	for _, i := range sortedInvitations(s.invitations) {
+		if i.BusinessID == businessID && i.Status == db.InvitationPending {
+			invitations = append(invitations, i)
+		}
+	}
+	return invitations, nil
+}
+
+func (s *invitationStore) Update(_ context.Context, i *db.Invitation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found, ok := s.invitations[i.ID]
+	if !ok {
+		return nil
+	}
+	found.Nonce = i.Nonce
+	found.Status = i.Status
+	found.ExpiresAt = i.ExpiresAt
+	s.invitations[i.ID] = found
+	return nil
+}
+
+// Row of password_resets, used mirrors used_at IS NOT NULL
+type passwordReset struct {
+	db.PasswordReset
+	used bool
+}
+
+type passwordResetStore struct{ *data }
+
+func (s *passwordResetStore) Set(_ context.Context, pr *db.PasswordReset) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, found := range s.passwordResets {
+		if found.TokenHash == pr.TokenHash {
+			return fmt.Errorf("password reset token already exists")
+		}
+	}
+	pr.ID = s.nextID()
+	s.passwordResets[pr.ID] = passwordReset{PasswordReset: *pr}
+	return nil
+}
+
+func (s *passwordResetStore) GetValid(_ context.Context, pr *db.PasswordReset, now time.Time) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, found := range s.passwordResets {
+		if found.TokenHash == pr.TokenHash && !found.used && found.ExpiresAt.After(now) {
+			*pr = found.PasswordReset
+			return nil
+		}
+	}
+	return db.ErrPasswordResetNotFound
+}
+
+func (s *passwordResetStore) MarkUsed(_ context.Context, pr *db.PasswordReset, _ time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found, ok := s.passwordResets[pr.ID]
+	if !ok || found.used {
+		return db.ErrPasswordResetNotFound
+	}
+	found.used = true
+	s.passwordResets[pr.ID] = found
+	return nil
+}
+
+func (s *passwordResetStore) MarkUsedByUserID(_ context.Context, userID int, _ time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, found := range s.passwordResets {
+		if found.UserID == userID {
+			found.used = true
+			s.passwordResets[id] = found
+		}
+	}
+	return nil
+}
+
+type loginEventStore struct{ *data }
+
+func (s *loginEventStore) Set(_ context.Context, e *db.LoginEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e.ID = s.nextID()
+	s.loginEvents[e.ID] = *e
+	return nil
+}
+
+func (s *loginEventStore) AccountFailures(_ context.Context, userID int, identifier string, since time.Time) (db.LoginFailures, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	matches := func(e db.LoginEvent) bool {
+		if userID == 0 {
+			return e.UserID == 0 && e.Identifier == identifier
+		}
+		return e.UserID == userID
+	}
+
+	for _, e := range s.loginEvents {
+		if matches(e) && e.Success && e.CreatedAt.After(since) {
+			since = e.CreatedAt
+		}
+	}
+
+	var f db.LoginFailures
+	for _, e := range s.loginEvents {
+		if matches(e) && !e.Success && e.CreatedAt.After(since) {
+			f.Count++
+			if e.CreatedAt.After(f.Last) {
+				f.Last = e.CreatedAt
+			}
+		}
+	}
+	return f, nil
+}
+
+func (s *loginEventStore) IPFailures(_ context.Context, ip string, since time.Time) (db.LoginFailures, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var f db.LoginFailures
+	for _, e := range s.loginEvents {
+		if e.IP == ip && !e.Success && e.CreatedAt.After(since) {
+			f.Count++
+			if e.CreatedAt.After(f.Last) {
+				f.Last = e.CreatedAt
+			}
+		}
+	}
+	return f, nil
+}
+
+// Maps don't keep insertion order, sort by id so results match mysql's
+type totpStore struct{ *data }
+
+// Row of totp_recovery_codes
+type recoveryCode struct {
+	userID int
+	hash   string
+	used   bool
+}
+
+func (s *totpStore) SetPending(_ context.Context, t *db.TOTP) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t.EnabledAt = nil
+	t.LastStep = 0
+	s.totp[t.UserID] = *t
+	return nil
+}
+
+func (s *totpStore) Get(_ context.Context, t *db.TOTP) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found, ok := s.totp[t.UserID]
+	if !ok {
+		return db.ErrTOTPNotFound
+	}
+	*t = found
+	return nil
+}
+
+func (s *totpStore) Enable(_ context.Context, t *db.TOTP) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if found, ok := s.totp[t.UserID]; ok {
+		found.EnabledAt = t.EnabledAt
+		found.LastStep = t.LastStep
+		s.totp[t.UserID] = found
+	}
+	return nil
+}
+
+func (s *totpStore) UseStep(_ context.Context, t *db.TOTP, step int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found, ok := s.totp[t.UserID]
+	if !ok || found.LastStep >= step {
+		return false, nil
+	}
+	found.LastStep = step
+	s.totp[t.UserID] = found
+	t.LastStep = step
+	return true, nil
+}
+
+func (s *totpStore) Delete(_ context.Context, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.totp, userID)
+	for id, c := range s.recoveryCodes {
+		if c.userID == userID {
+			delete(s.recoveryCodes, id)
+		}
+	}
+	return nil
+}
+
+func (s *totpStore) SetRecoveryCodes(_ context.Context, userID int, hashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, c := range s.recoveryCodes {
+		if c.userID == userID {
+			delete(s.recoveryCodes, id)
+		}
+	}
+	for _, hash := range hashes {
+		s.recoveryCodes[s.nextID()] = recoveryCode{userID: userID, hash: hash}
+	}
+	return nil
+}
+
+func (s *totpStore) UseRecoveryCode(_ context.Context, userID int, hash string, _ time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, c := range s.recoveryCodes {
+		if c.userID == userID && c.hash == hash && !c.used {
+			c.used = true
+			s.recoveryCodes[id] = c
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *totpStore) CountRecoveryCodes(_ context.Context, userID int) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := 0
+	for _, c := range s.recoveryCodes {
+		if c.userID == userID && !c.used {
+			n++
+		}
+	}
+	return n, nil
+}
+
+type apiTokenStore struct{ *data }
+
+func (s *apiTokenStore) Set(_ context.Context, t *db.APIToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, found := range s.apiTokens {
+		if found.TokenHash == t.TokenHash {
+			return fmt.Errorf("api token already exists")
+		}
+	}
+	t.ID = s.nextID()
+	s.apiTokens[t.ID] = *t
+	return nil
+}
+
+func (s *apiTokenStore) GetByHash(_ context.Context, t *db.APIToken) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, found := range s.apiTokens {
+		if found.TokenHash == t.TokenHash {
+			*t = found
+			return nil
+		}
+	}
+	return db.ErrAPITokenNotFound
+}
+
+func (s *apiTokenStore) ListByUserID(_ context.Context, userID int) ([]db.APIToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var tokens []db.APIToken
+	for _, t := range s.apiTokens {
+		if t.UserID == userID {
+			tokens = append(tokens, t)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID > tokens[j].ID })
+	return tokens, nil
+}
+
+func (s *apiTokenStore) Touch(_ context.Context, t *db.APIToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if found, ok := s.apiTokens[t.ID]; ok {
+		found.LastUsedAt = t.LastUsedAt
+		s.apiTokens[t.ID] = found
+	}
+	return nil
+}
+
+func (s *apiTokenStore) DeleteForUser(_ context.Context, t *db.APIToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found, ok := s.apiTokens[t.ID]
+	if !ok || found.UserID != t.UserID {
+		return db.ErrAPITokenNotFound
+	}
+	delete(s.apiTokens, t.ID)
+	return nil
+}
+
+func (s *apiTokenStore) DeleteByUserID(_ context.Context, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, t := range s.apiTokens {
+		if t.UserID == userID {
+			delete(s.apiTokens, id)
+		}
+	}
+	return nil
+}
+
+type identityStore struct{ *data }
+
+func (s *identityStore) Set(_ context.Context, i *db.Identity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, found := range s.identities {
+		if found.Issuer == i.Issuer && found.Subject == i.Subject {
+			return fmt.Errorf("identity already exists")
+		}
+	}
+	i.ID = s.nextID()
+	s.identities[i.ID] = *i
+	return nil
+}
+
+func (s *identityStore) Get(_ context.Context, i *db.Identity) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, found := range s.identities {
+		if found.Issuer == i.Issuer && found.Subject == i.Subject {
+			*i = found
+			return nil
+		}
+	}
+	return db.ErrIdentityNotFound
+}
+
+func sortedUsers(m map[int]db.User) []db.User {
+	users := make([]db.User, 0, len(m))
+	for _, u := range m {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users
+}
+
+func sortedProducts(m map[int]db.Product) []db.Product {
+	products := make([]db.Product, 0, len(m))
+	for _, p := range m {
+		products = append(products, p)
+	}
+	sort.Slice(products, func(i, j int) bool { return products[i].ID < products[j].ID })
+	return products
+}
+
+// By product, then position, like the ORDER BY of the mysql store
+func sortedProductImages(m map[int]db.ProductImage) []db.ProductImage {
+	images := make([]db.ProductImage, 0, len(m))
+	for _, img := range m {
+		images = append(images, img)
+	}
+	sort.Slice(images, func(i, j int) bool {
+		a, b := images[i], images[j]
+		if a.ProductID != b.ProductID {
+			return a.ProductID < b.ProductID
+		}
+		if a.Position != b.Position {
+			return a.Position < b.Position
+		}
+		return a.ID < b.ID
+	})
+	return images
+}
+
+func sortedCollaborators(m map[int]db.BusinessCollaborator) []db.BusinessCollaborator {
+	collaborators := make([]db.BusinessCollaborator, 0, len(m))
+	for _, bc := range m {
+		collaborators = append(collaborators, bc)
+	}
+	sort.Slice(collaborators, func(i, j int) bool { return collaborators[i].ID < collaborators[j].ID })
+	return collaborators
+}
+
+func sortedInvitations(m map[int]db.Invitation) []db.Invitation {
+	invitations := make([]db.Invitation, 0, len(m))
+	for _, i := range m {
+		invitations = append(invitations, i)
+	}
+	sort.Slice(invitations, func(i, j int) bool { return invitations[i].ID < invitations[j].ID })
+	return invitations
+}
@@ -0,0 +1,107 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/calmestend/mercado_lobito/internal/db"
+)
+
+func TestWithTx(t *testing.T) {
+	ctx := context.Background()
+	errAbort := errors.New("abort")
+
+	tests := []struct {
+		name       string
+		fail       bool
+		wantStored bool
+	}{
+		{"commit", false, true},
+		{"rollback", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := New()
+			owner := db.User{Email: "owner@example.com"}
+			if err := store.Users.Set(ctx, &owner); err != nil {
+				t.Fatal(err)
+			}
+
+			var u db.User
+			var b db.Business
+			err := store.WithTx(ctx, func(tx *db.Store) error {
+				u = db.User{Email: "ana@example.com"}
+				if err := tx.Users.Set(ctx, &u); err != nil {
+					return err
+				}
+				// Joins the transaction instead of committing on its own
+				err := tx.WithTx(ctx, func(tx *db.Store) error {
+					b = db.Business{Name: "Tacos", OwnerID: "1001"}
+					return tx.Businesses.Set(ctx, &b)
+				})
+				if err != nil {
+					return err
+				}
+				// Deletes roll back along with inserts
+				if err := tx.Users.Delete(ctx, &owner); err != nil {
+					return err
+				}
+				if tt.fail {
+					return errAbort
+				}
+				return nil
+			})
+			if tt.fail != errors.Is(err, errAbort) {
+				t.Fatalf("WithTx = %v", err)
+			}
+
+			stored := store.Users.GetByID(ctx, &db.User{ID: u.ID}) == nil
+			if stored != tt.wantStored {
+				t.Errorf("user stored = %v, want %v", stored, tt.wantStored)
+			}
+			stored = store.Businesses.Get(ctx, &db.Business{ID: b.ID}) == nil
+			if stored != tt.wantStored {
+				t.Errorf("business stored = %v, want %v", stored, tt.wantStored)
+			}
+			ownerKept := store.Users.GetByID(ctx, &db.User{ID: owner.ID}) == nil
+			if ownerKept == tt.wantStored {
+				t.Errorf("deleted user kept = %v, want %v", ownerKept, !tt.wantStored)
+			}
+		})
+	}
+}
+
+// Deleting a user removes what mysql's ON DELETE CASCADE would
+func TestUserDeleteCascades(t *testing.T) {
+	ctx := context.Background()
+	store := New()
+
+	u := db.User{Email: "ana@example.com"}
+	store.Users.Set(ctx, &u)
+	store.Students.Set(ctx, &db.Student{ID: "1001", UserID: u.ID})
+	b := db.Business{Name: "Tacos", OwnerID: "1001"}
+	store.Businesses.Set(ctx, &b)
+	p := db.Product{Title: "Taco", BusinessID: b.ID}
+	store.Products.Set(ctx, &p)
+	img := db.ProductImage{ProductID: p.ID, Key: "products/x"}
+	store.ProductImages.Set(ctx, &img)
+
+	if err := store.Users.Delete(ctx, &u); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Students.GetByID(ctx, &db.Student{ID: "1001"}); !errors.Is(err, db.ErrStudentNotFound) {
+		t.Errorf("student: %v", err)
+	}
+	if err := store.Businesses.Get(ctx, &db.Business{ID: b.ID}); !errors.Is(err, db.ErrBusinessNotFound) {
+		t.Errorf("business: %v", err)
+	}
+	if err := store.Products.GetByID(ctx, &db.Product{ID: p.ID}); !errors.Is(err, db.ErrProductNotFound) {
+		t.Errorf("product: %v", err)
+	}
+	if err := store.ProductImages.GetByID(ctx, &db.ProductImage{ID: img.ID}); !errors.Is(err, db.ErrProductImageNotFound) {
+		t.Errorf("product image: %v", err)
+	}
+}
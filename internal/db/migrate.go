@@ -0,0 +1,372 @@
+package db
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations live in migrations/ as <version>_<name>.up.sql and
+// <version>_<name>.down.sql, e.g. 0002_add_sessions_expiry.up.sql
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Load every migration embedded in the binary, sorted by version
+func LoadMigrations() ([]Migration, error) {
+	return loadMigrations(migrationFiles)
+}
+
+// Load the migrations in the migrations directory of fsys
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		filename := entry.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(filename, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(filename, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration '%s' must end with .up.sql or .down.sql", filename)
+		}
+
+		base := strings.TrimSuffix(filename, "."+direction+".sql")
+		versionStr, name, found := strings.Cut(base, "_")
+		if !found {
+			return nil, fmt.Errorf("migration '%s' must be named <version>_<name>", filename)
+		}
+
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("migration '%s' has an invalid version", filename)
+		}
+
+		content, err := fs.ReadFile(fsys, path.Join("migrations", filename))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %d has two different names: '%s' and '%s'", version, m.Name, name)
+		}
+
+		if direction == "up" {
+			m.Up = string(content)
+			sum := sha256.Sum256(content)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d (%s) needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Create the table used to track applied migrations
+func ensureMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INT PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			checksum CHAR(64) NOT NULL,
+			applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	return err
+}
+
+type appliedMigration struct {
+	Checksum  string
+	AppliedAt time.Time
+}
+
+func getAppliedMigrations(db *sql.DB) (map[int]appliedMigration, error) {
+	rows, err := db.Query(`SELECT version, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]appliedMigration{}
+	for rows.Next() {
+		var version int
+		var m appliedMigration
+		var appliedAt sql.NullTime
+		if err := rows.Scan(&version, &m.Checksum, &appliedAt); err != nil {
+			return nil, err
+		}
+		m.AppliedAt = appliedAt.Time
+		applied[version] = m
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return applied, nil
+}
+
+// Report every known migration and whether it has been applied.
+// Fails if an applied migration was edited after being applied or if the
+// database has a version this binary doesn't know about.
+func GetMigrationStatus(db *sql.DB) ([]MigrationStatus, error) {
+	if err := ensureMigrationsTable(db); err != nil {
+		return nil, err
+	}
+
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := getAppliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return migrationStatus(migrations, applied)
+}
+
+// Match the known migrations against the applied ones
+func migrationStatus(migrations []Migration, applied map[int]appliedMigration) ([]MigrationStatus, error) {
+	known := map[int]bool{}
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		known[m.Version] = true
+
+		s := MigrationStatus{Migration: m}
+		if a, ok := applied[m.Version]; ok {
+			if a.Checksum != m.Checksum {
+				return nil, fmt.Errorf("checksum mismatch for migration %d (%s): it was modified after being applied", m.Version, m.Name)
+			}
+			s.Applied = true
+			s.AppliedAt = a.AppliedAt
+		}
+		status = append(status, s)
+	}
+
+	for version := range applied {
+		if !known[version] {
+			return nil, fmt.Errorf("database has migration %d applied, but this binary doesn't know it", version)
+		}
+	}
+
+	return status, nil
+}
+
+func GetPendingMigrations(db *sql.DB) ([]Migration, error) {
+	status, err := GetMigrationStatus(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, s := range status {
+		if !s.Applied {
+			pending = append(pending, s.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// Apply every pending migration, returns the applied ones
+func MigrateUp(db *sql.DB) ([]Migration, error) {
+	pending, err := GetPendingMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, m := range pending {
+		if err := execStatements(db, m.Up); err != nil {
+			return applied, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+
+		_, err := db.Exec(`
+			INSERT INTO schema_migrations (version, name, checksum)
+			VALUES (?, ?, ?)
+		`, m.Version, m.Name, m.Checksum)
+		if err != nil {
+			return applied, fmt.Errorf("recording migration %d (%s): %w", m.Version, m.Name, err)
+		}
+
+		applied = append(applied, m)
+	}
+
+	return applied, nil
+}
+
+// Roll back the latest `steps` applied migrations, returns the reverted ones
+func MigrateDown(db *sql.DB, steps int) ([]Migration, error) {
+	status, err := GetMigrationStatus(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+	for i := len(status) - 1; i >= 0 && len(reverted) < steps; i-- {
+		m := status[i]
+		if !m.Applied {
+			continue
+		}
+
+		if err := execStatements(db, m.Down); err != nil {
+			return reverted, fmt.Errorf("reverting migration %d (%s): %w", m.Version, m.Name, err)
+		}
+
+		_, err := db.Exec(`DELETE FROM schema_migrations WHERE version = ?`, m.Version)
+		if err != nil {
+			return reverted, fmt.Errorf("unrecording migration %d (%s): %w", m.Version, m.Name, err)
+		}
+
+		reverted = append(reverted, m.Migration)
+	}
+
+	if len(reverted) == 0 {
+		return nil, errors.New("no applied migrations to revert")
+	}
+
+	return reverted, nil
+}
+
+// MySQL DDL commits implicitly, so statements run one by one instead of
+// inside a transaction. A migration that fails halfway must be fixed by hand.
+func execStatements(db *sql.DB, script string) error {
+	for _, stmt := range splitStatements(script) {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Split a script on the semicolons ending each statement. Comments are
+// dropped and semicolons inside quotes or identifiers don't count.
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+
+	flush := func() {
+		if stmt := strings.TrimSpace(current.String()); stmt != "" {
+			statements = append(statements, stmt)
+		}
+		current.Reset()
+	}
+
+	for i := 0; i < len(script); i++ {
+		rest := script[i:]
+		switch {
+		case rest[0] == '\'' || rest[0] == '"' || rest[0] == '`':
+			end := i + quotedLen(rest)
+			current.WriteString(script[i:end])
+			i = end - 1
+		case isLineComment(rest):
+			// Keep the newline so the lines around don't run together
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			i += end - 1
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest[2:], "*/")
+			if end < 0 {
+				end = len(rest)
+			} else {
+				end += len("/**/")
+			}
+			// MySQL runs what's inside /*! ... */, so those stay
+			if strings.HasPrefix(rest, "/*!") {
+				current.WriteString(rest[:end])
+			} else {
+				current.WriteByte(' ')
+			}
+			i += end - 1
+		case rest[0] == ';':
+			current.WriteByte(';')
+			flush()
+		default:
+			current.WriteByte(rest[0])
+		}
+	}
+	flush()
+
+	return statements
+}
+
+// Length of the quoted string or identifier s starts with, closing quote
+// included. Quotes are escaped by doubling them or, outside backticks, with
+// a backslash.
+func quotedLen(s string) int {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quote != '`':
+			i++
+		case s[i] == quote && i+1 < len(s) && s[i+1] == quote:
+			i++
+		case s[i] == quote:
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// MySQL comments run from # or from -- followed by whitespace to the end of
+// the line
+func isLineComment(s string) bool {
+	if s[0] == '#' {
+		return true
+	}
+	if !strings.HasPrefix(s, "--") {
+		return false
+	}
+	return len(s) == 2 || strings.ContainsRune(" \t\r\n", rune(s[2]))
+}
@@ -0,0 +1,199 @@
+package db
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{
+			"one per line",
+			"CREATE TABLE a (id INT);\nDROP TABLE b;\n",
+			[]string{"CREATE TABLE a (id INT);", "DROP TABLE b;"},
+		},
+		{
+			"several on a line",
+			"DROP TABLE a; DROP TABLE b;",
+			[]string{"DROP TABLE a;", "DROP TABLE b;"},
+		},
+		{
+			"spanning lines",
+			"CREATE TABLE a (\n\tid INT\n);\n",
+			[]string{"CREATE TABLE a (\n\tid INT\n);"},
+		},
+		{
+			"no final semicolon",
+			"DROP TABLE a",
+			[]string{"DROP TABLE a"},
+		},
+		{
+			"quoted semicolon",
+			"INSERT INTO a VALUES ('x;y');\nDROP TABLE b;",
+			[]string{"INSERT INTO a VALUES ('x;y');", "DROP TABLE b;"},
+		},
+		{
+			"quoted semicolon ending a line",
+			"INSERT INTO a VALUES ('x;\ny');",
+			[]string{"INSERT INTO a VALUES ('x;\ny');"},
+		},
+		{
+			"escaped quotes",
+			`INSERT INTO a VALUES ('it''s;', 'x\';y');`,
+			[]string{`INSERT INTO a VALUES ('it''s;', 'x\';y');`},
+		},
+		{
+			"double quotes and backticks",
+			"SELECT \"x;y\" AS `a;b`;",
+			[]string{"SELECT \"x;y\" AS `a;b`;"},
+		},
+		{
+			"line comments",
+			"-- first; comment\nCREATE TABLE a (id INT); -- trailing; comment\n# hash; comment\nDROP TABLE a;\n",
+			[]string{"CREATE TABLE a (id INT);", "DROP TABLE a;"},
+		},
+		{
+			"comment inside a statement",
+			"CREATE TABLE a (\n\t-- why; it's here\n\tid INT\n);",
+			[]string{"CREATE TABLE a (\n\t\n\tid INT\n);"},
+		},
+		{
+			"comment markers in quotes",
+			"INSERT INTO a VALUES ('-- no', '# nor', '/* this */');",
+			[]string{"INSERT INTO a VALUES ('-- no', '# nor', '/* this */');"},
+		},
+		{
+			"double dash without a space",
+			"SELECT 1--1;",
+			[]string{"SELECT 1--1;"},
+		},
+		{
+			"block comment",
+			"DROP /* a; b */TABLE a;",
+			[]string{"DROP  TABLE a;"},
+		},
+		{
+			"executable comment",
+			"CREATE TABLE a (id INT) /*!50100 ENGINE=InnoDB */;",
+			[]string{"CREATE TABLE a (id INT) /*!50100 ENGINE=InnoDB */;"},
+		},
+		{
+			"only comments",
+			"-- nothing to run;\n/* here; either */\n",
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitStatements(tt.script); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrationsSplit(t *testing.T) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range migrations {
+		for _, script := range []string{m.Up, m.Down} {
+			statements := splitStatements(script)
+			if len(statements) == 0 {
+				t.Errorf("migration %d (%s) has an empty script", m.Version, m.Name)
+			}
+			for _, stmt := range statements {
+				if !strings.HasSuffix(stmt, ";") {
+					t.Errorf("migration %d (%s): statement doesn't end in a semicolon: %q", m.Version, m.Name, stmt)
+				}
+			}
+		}
+	}
+}
+
+func testMigrationFS() fstest.MapFS {
+	return fstest.MapFS{
+		"migrations/0001_users.up.sql":      {Data: []byte("CREATE TABLE users (id INT);\n")},
+		"migrations/0001_users.down.sql":    {Data: []byte("DROP TABLE users;\n")},
+		"migrations/0002_products.up.sql":   {Data: []byte("CREATE TABLE products (id INT);\n")},
+		"migrations/0002_products.down.sql": {Data: []byte("DROP TABLE products;\n")},
+	}
+}
+
+func TestMigrationStatus(t *testing.T) {
+	migrations, err := loadMigrations(testMigrationFS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	appliedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	applied := map[int]appliedMigration{1: {Checksum: migrations[0].Checksum, AppliedAt: appliedAt}}
+
+	status, err := migrationStatus(migrations, applied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 2 || !status[0].Applied || !status[0].AppliedAt.Equal(appliedAt) || status[1].Applied {
+		t.Errorf("status = %+v, want 1 applied and 2 pending", status)
+	}
+}
+
+func TestMigrationModifiedAfterApplied(t *testing.T) {
+	fsys := testMigrationFS()
+	migrations, err := loadMigrations(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied := map[int]appliedMigration{}
+	for _, m := range migrations {
+		applied[m.Version] = appliedMigration{Checksum: m.Checksum}
+	}
+
+	// Even a change that doesn't alter the statements counts
+	fsys["migrations/0001_users.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE users (id INT);  \n")}
+	modified, err := loadMigrations(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modified[0].Checksum == migrations[0].Checksum {
+		t.Fatal("editing the up file kept its checksum")
+	}
+
+	_, err = migrationStatus(modified, applied)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch for migration 1") {
+		t.Errorf("err = %v, want a checksum mismatch for migration 1", err)
+	}
+
+	// Down files aren't checked, fixing a rollback doesn't block migrating
+	fsys = testMigrationFS()
+	fsys["migrations/0001_users.down.sql"] = &fstest.MapFile{Data: []byte("DROP TABLE IF EXISTS users;\n")}
+	fixedDown, err := loadMigrations(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrationStatus(fixedDown, applied); err != nil {
+		t.Errorf("edited down file: %v", err)
+	}
+}
+
+func TestMigrationUnknownApplied(t *testing.T) {
+	migrations, err := loadMigrations(testMigrationFS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied := map[int]appliedMigration{3: {Checksum: "from a newer binary"}}
+
+	_, err = migrationStatus(migrations, applied)
+	if err == nil || !strings.Contains(err.Error(), "migration 3") {
+		t.Errorf("err = %v, want one about migration 3", err)
+	}
+}
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS products;
+DROP TABLE IF EXISTS businesses_collaborators;
+DROP TABLE IF EXISTS businesses;
+DROP TABLE IF EXISTS students;
+DROP TABLE IF EXISTS admins;
+DROP TABLE IF EXISTS users;
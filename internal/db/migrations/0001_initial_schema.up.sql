@@ -0,0 +1,68 @@
+CREATE TABLE IF NOT EXISTS users (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	middle_names VARCHAR(100) NOT NULL,
+	paternal_surname VARCHAR(100) NOT NULL,
+	maternal_surname VARCHAR(100) DEFAULT NULL,
+	personal_id CHAR(100) DEFAULT NULL,
+	email VARCHAR(150) NOT NULL,
+	hash CHAR(255) NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	update_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS admins (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	user_id INT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	update_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS students (
+	id CHAR(10) PRIMARY KEY,
+	grade VARCHAR(20) NOT NULL,
+	class_group VARCHAR(10) NOT NULL,
+	user_id INT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	update_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS businesses (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	name VARCHAR(100) NOT NULL,
+	type VARCHAR(100) DEFAULT NULL,
+	description TEXT DEFAULT NULL,
+	owner_id CHAR(10),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	update_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	FOREIGN KEY (owner_id) REFERENCES students(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS businesses_collaborators (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	business_id INT,
+	collaborator_id INT,
+	FOREIGN KEY (business_id) REFERENCES businesses(id) ON DELETE CASCADE,
+	FOREIGN KEY (collaborator_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS products (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	title VARCHAR(100) NOT NULL,
+	price DECIMAL(10,2) NOT NULL,
+	stock INT NOT NULL DEFAULT 0,
+	business_id INT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	update_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	FOREIGN KEY (business_id) REFERENCES businesses(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	uuid CHAR(255) NOT NULL,
+	user_id INT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	update_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,6 @@
+ALTER TABLE sessions
+	DROP INDEX sessions_last_seen_at,
+	DROP INDEX sessions_expires_at,
+	DROP INDEX sessions_uuid,
+	DROP COLUMN last_seen_at,
+	DROP COLUMN expires_at;
@@ -0,0 +1,15 @@
+ALTER TABLE sessions
+	ADD COLUMN expires_at DATETIME NULL,
+	ADD COLUMN last_seen_at DATETIME NULL;
+
+-- Existing sessions keep the 24 hours their cookie was given
+UPDATE sessions
+SET expires_at = DATE_ADD(created_at, INTERVAL 1 DAY),
+	last_seen_at = update_at;
+
+ALTER TABLE sessions
+	MODIFY expires_at DATETIME NOT NULL,
+	MODIFY last_seen_at DATETIME NOT NULL,
+	ADD UNIQUE INDEX sessions_uuid (uuid),
+	ADD INDEX sessions_expires_at (expires_at),
+	ADD INDEX sessions_last_seen_at (last_seen_at);
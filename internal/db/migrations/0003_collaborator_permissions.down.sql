@@ -0,0 +1,2 @@
+ALTER TABLE businesses_collaborators
+	DROP COLUMN permission;
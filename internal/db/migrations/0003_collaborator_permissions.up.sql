@@ -0,0 +1,3 @@
+-- Collaborators could never act before, so existing ones start read only
+ALTER TABLE businesses_collaborators
+	ADD COLUMN permission VARCHAR(20) NOT NULL DEFAULT 'read_only';
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS invitations (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	business_id INT NOT NULL,
+	email VARCHAR(150) NOT NULL,
+	permission VARCHAR(20) NOT NULL DEFAULT 'read_only',
+	-- Embedded in the signed token, replaced on resend so older links die
+	nonce CHAR(32) NOT NULL,
+	status VARCHAR(10) NOT NULL DEFAULT 'pending',
+	invited_by INT NOT NULL,
+	expires_at DATETIME NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	update_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	FOREIGN KEY (business_id) REFERENCES businesses(id) ON DELETE CASCADE,
+	FOREIGN KEY (invited_by) REFERENCES users(id) ON DELETE CASCADE,
+	INDEX invitations_business_status (business_id, status)
+);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS password_resets (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	user_id INT NOT NULL,
+	-- sha256 of the emailed token, the token itself is never stored
+	token_hash CHAR(64) NOT NULL,
+	expires_at DATETIME NOT NULL,
+	used_at DATETIME NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	UNIQUE INDEX password_resets_token_hash (token_hash)
+);
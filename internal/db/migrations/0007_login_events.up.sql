@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS login_events (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	-- NULL when the identifier didn't match any account
+	user_id INT NULL,
+	identifier VARCHAR(150) NOT NULL,
+	ip VARCHAR(45) NOT NULL,
+	user_agent VARCHAR(255) NOT NULL,
+	success BOOLEAN NOT NULL,
+	created_at DATETIME(6) NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	INDEX login_events_user (user_id, created_at),
+	INDEX login_events_identifier (identifier, created_at),
+	INDEX login_events_ip (ip, created_at)
+);
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS user_totp (
+	user_id INT PRIMARY KEY,
+	secret VARCHAR(64) NOT NULL,
+	-- NULL while enrollment waits for the first code
+	enabled_at DATETIME NULL,
+	-- Last accepted time step, so a code can't be used twice
+	last_step BIGINT NOT NULL DEFAULT 0,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	user_id INT NOT NULL,
+	code_hash CHAR(64) NOT NULL,
+	used_at DATETIME NULL,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	INDEX totp_recovery_codes_user (user_id)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE sessions
+	DROP COLUMN user_agent,
+	DROP COLUMN ip;
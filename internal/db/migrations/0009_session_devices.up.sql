@@ -0,0 +1,4 @@
+-- Where each session was started from, shown on the active sessions page
+ALTER TABLE sessions
+	ADD COLUMN ip VARCHAR(45) NOT NULL DEFAULT '',
+	ADD COLUMN user_agent VARCHAR(255) NOT NULL DEFAULT '';
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	user_id INT NOT NULL,
+	name VARCHAR(100) NOT NULL,
+	-- SHA-256 of the token, the token itself is only shown once
+	token_hash CHAR(64) NOT NULL,
+	-- Comma separated, e.g. products:read,products:write
+	scopes VARCHAR(255) NOT NULL,
+	created_at DATETIME NOT NULL,
+	-- NULL for tokens that don't expire
+	expires_at DATETIME NULL,
+	last_used_at DATETIME NULL,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	UNIQUE INDEX api_tokens_hash (token_hash)
+);
@@ -0,0 +1,10 @@
+-- Accounts at external identity providers linked to a user
+CREATE TABLE IF NOT EXISTS user_identities (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	user_id INT NOT NULL,
+	issuer VARCHAR(255) NOT NULL,
+	subject VARCHAR(255) NOT NULL,
+	created_at DATETIME NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	UNIQUE INDEX user_identities_subject (issuer, subject)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE users
+	DROP INDEX users_email;
@@ -0,0 +1,5 @@
+-- Signup already refuses an email that GetByEmail finds, the index closes
+-- the race between that check and the insert. Duplicates left from before
+-- have to be merged by hand for this to apply.
+ALTER TABLE users
+	ADD UNIQUE INDEX users_email (email);
@@ -0,0 +1,4 @@
+-- Random key the user's photo is stored under, empty when they have none.
+-- Photos used to be named after student IDs, which anyone could guess.
+ALTER TABLE users
+	ADD COLUMN photo_key VARCHAR(64) NOT NULL DEFAULT '';
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS product_images (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	product_id INT NOT NULL,
+	-- Key the image and its thumbnails are stored under
+	image_key VARCHAR(64) NOT NULL,
+	-- Display order, lowest first
+	position INT NOT NULL,
+	-- Shown in product listings, exactly one per product that has images
+	is_cover BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at DATETIME NOT NULL,
+	FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE,
+	INDEX product_images_product (product_id, position)
+);
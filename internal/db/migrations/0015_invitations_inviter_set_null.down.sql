@@ -0,0 +1,6 @@
+ALTER TABLE invitations
+	DROP FOREIGN KEY invitations_invited_by;
+DELETE FROM invitations WHERE invited_by IS NULL;
+ALTER TABLE invitations
+	MODIFY invited_by INT NOT NULL,
+	ADD CONSTRAINT invitations_ibfk_2 FOREIGN KEY (invited_by) REFERENCES users(id) ON DELETE CASCADE;
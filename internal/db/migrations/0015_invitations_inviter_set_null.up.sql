@@ -0,0 +1,9 @@
+-- Deleting the account that sent an invitation used to take it along, even
+-- though it's for someone else and the business may have another owner by
+-- then. The inviter is forgotten instead. 0004 left the constraint unnamed,
+-- invitations_ibfk_2 is the name MySQL gave it.
+ALTER TABLE invitations
+	DROP FOREIGN KEY invitations_ibfk_2;
+ALTER TABLE invitations
+	MODIFY invited_by INT NULL,
+	ADD CONSTRAINT invitations_invited_by FOREIGN KEY (invited_by) REFERENCES users(id) ON DELETE SET NULL;
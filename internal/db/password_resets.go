@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Single use password reset, only the hash of the emailed token is kept
+type PasswordReset struct {
+	ID        int
+	UserID    int
+	TokenHash string
+	ExpiresAt time.Time
+}
+
+type PasswordResetStore interface {
+	Set(ctx context.Context, pr *PasswordReset) error
+	// Unused reset for pr.TokenHash that hasn't expired by now
+	GetValid(ctx context.Context, pr *PasswordReset, now time.Time) error
+	// Returns ErrPasswordResetNotFound when it was used already
+	MarkUsed(ctx context.Context, pr *PasswordReset, now time.Time) error
+	// Use up every reset of the user still outstanding, so older links stop
+	// working once the password changes
+	MarkUsedByUserID(ctx context.Context, userID int, now time.Time) error
+}
+
+type mysqlPasswordResetStore struct {
+	conn
+}
+
+func (s *mysqlPasswordResetStore) Set(ctx context.Context, pr *PasswordReset) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO password_resets(user_id, token_hash, expires_at)
+		VALUES (?, ?, ?)
+	`, pr.UserID, pr.TokenHash, pr.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err == nil {
+		pr.ID = int(id)
+	}
+
+	return nil
+}
+
+func (s *mysqlPasswordResetStore) GetValid(ctx context.Context, pr *PasswordReset, now time.Time) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `
+		SELECT id, user_id, token_hash, expires_at
+		FROM password_resets
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?
+	`
+	row := s.db.QueryRowContext(ctx, stmt, pr.TokenHash, now)
+	err := row.Scan(&pr.ID, &pr.UserID, &pr.TokenHash, &pr.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrPasswordResetNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *mysqlPasswordResetStore) MarkUsed(ctx context.Context, pr *PasswordReset, now time.Time) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE password_resets SET used_at = ? WHERE id = ? AND used_at IS NULL`
+	res, err := s.db.ExecContext(ctx, stmt, now, pr.ID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrPasswordResetNotFound
+	}
+	return nil
+}
+
+func (s *mysqlPasswordResetStore) MarkUsedByUserID(ctx context.Context, userID int, now time.Time) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE password_resets SET used_at = ? WHERE user_id = ? AND used_at IS NULL`
+	_, err := s.db.ExecContext(ctx, stmt, now, userID)
+	return err
+}
@@ -0,0 +1,210 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type ProductImage struct {
+	ID        int
+	ProductID int
+	Key       string
+	Position  int
+	IsCover   bool
+	CreatedAt time.Time
+}
+
+type ProductImageStore interface {
+	Set(ctx context.Context, img *ProductImage) error
+	GetByID(ctx context.Context, img *ProductImage) error
+	// Images of the product in display order
+	GetByProductID(ctx context.Context, productID int) ([]ProductImage, error)
+	// Images of every product of the business, by product then display order
+	GetByBusinessID(ctx context.Context, businessID int) ([]ProductImage, error)
+	// Like GetByBusinessID. Inside WithTx it also locks the business's
+	// products, so no other transaction adds images to them until this one
+	// ends.
+	GetByBusinessIDForUpdate(ctx context.Context, businessID int) ([]ProductImage, error)
+	// Number of images the product has. Inside WithTx it also locks the
+	// product, so no other transaction adds images until this one ends.
+	CountForUpdate(ctx context.Context, productID int) (int, error)
+	// Number the product's images in the order of ids, which must be all of
+	// them. One update per image, so run it inside WithTx.
+	Reorder(ctx context.Context, productID int, ids []int) error
+	// Make img the cover of its product, and no other image
+	SetCover(ctx context.Context, img *ProductImage) error
+	Delete(ctx context.Context, img *ProductImage) error
+}
+
+type mysqlProductImageStore struct {
+	conn
+}
+
+func (s *mysqlProductImageStore) Set(ctx context.Context, img *ProductImage) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO product_images(product_id, image_key, position, is_cover, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	res, err := s.db.ExecContext(ctx, stmt, img.ProductID, img.Key, img.Position, img.IsCover, img.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err == nil {
+		img.ID = int(id)
+	}
+	return nil
+}
+
+func (s *mysqlProductImageStore) GetByID(ctx context.Context, img *ProductImage) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `
+		SELECT id, product_id, image_key, position, is_cover, created_at
+		FROM product_images
+		WHERE id = ?
+	`
+	err := s.db.QueryRowContext(ctx, stmt, img.ID).Scan(&img.ID, &img.ProductID, &img.Key, &img.Position, &img.IsCover, &img.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrProductImageNotFound
+	}
+	return err
+}
+
+func (s *mysqlProductImageStore) GetByProductID(ctx context.Context, productID int) ([]ProductImage, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `
+		SELECT id, product_id, image_key, position, is_cover, created_at
+		FROM product_images
+		WHERE product_id = ?
+		ORDER BY position, id
+	`
+	return s.query(ctx, stmt, productID)
+}
+
+func (s *mysqlProductImageStore) GetByBusinessID(ctx context.Context, businessID int) ([]ProductImage, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `
+		SELECT i.id, i.product_id, i.image_key, i.position, i.is_cover, i.created_at
+		FROM product_images i
+		INNER JOIN products p ON i.product_id = p.id
+		WHERE p.business_id = ?
+		ORDER BY i.product_id, i.position, i.id
+	`
+	return s.query(ctx, stmt, businessID)
+}
+
+func (s *mysqlProductImageStore) GetByBusinessIDForUpdate(ctx context.Context, businessID int) ([]ProductImage, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	// The products are locked the same as CountForUpdate locks one, which
+	// every upload goes through
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM products WHERE business_id = ? FOR UPDATE`, businessID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stmt := `
+		SELECT i.id, i.product_id, i.image_key, i.position, i.is_cover, i.created_at
+		FROM product_images i
+		INNER JOIN products p ON i.product_id = p.id
+		WHERE p.business_id = ?
+		ORDER BY i.product_id, i.position, i.id
+	`
+	return s.query(ctx, stmt, businessID)
+}
+
+func (s *mysqlProductImageStore) query(ctx context.Context, stmt string, args ...any) ([]ProductImage, error) {
+	rows, err := s.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []ProductImage
+	for rows.Next() {
+		var img ProductImage
+		if err := rows.Scan(&img.ID, &img.ProductID, &img.Key, &img.Position, &img.IsCover, &img.CreatedAt); err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+	return images, rows.Err()
+}
+
+func (s *mysqlProductImageStore) CountForUpdate(ctx context.Context, productID int) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	// Locking the product rather than its images also covers products that
+	// have none yet
+	var id int
+	err := s.db.QueryRowContext(ctx, `SELECT id FROM products WHERE id = ? FOR UPDATE`, productID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrProductNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	err = s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM product_images WHERE product_id = ?`, productID).Scan(&count)
+	return count, err
+}
+
+func (s *mysqlProductImageStore) Reorder(ctx context.Context, productID int, ids []int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := s.db.PrepareContext(ctx, `UPDATE product_images SET position = ? WHERE id = ? AND product_id = ?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for position, id := range ids {
+		if _, err := stmt.ExecContext(ctx, position, id, productID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *mysqlProductImageStore) SetCover(ctx context.Context, img *ProductImage) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE product_images SET is_cover = (id = ?) WHERE product_id = ?`
+	_, err := s.db.ExecContext(ctx, stmt, img.ID, img.ProductID)
+	if err == nil {
+		img.IsCover = true
+	}
+	return err
+}
+
+func (s *mysqlProductImageStore) Delete(ctx context.Context, img *ProductImage) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `DELETE FROM product_images WHERE id = ?`
+	_, err := s.db.ExecContext(ctx, stmt, img.ID)
+	return err
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 )
@@ -13,8 +14,22 @@ type Product struct {
 	BusinessID int
 }
 
-func (p *Product) Set(db *sql.DB) error {
-	stmt, err := db.Prepare(`
+type ProductStore interface {
+	Set(ctx context.Context, p *Product) error
+	GetByID(ctx context.Context, p *Product) error
+	Update(ctx context.Context, p *Product) error
+	Delete(ctx context.Context, p *Product) error
+}
+
+type mysqlProductStore struct {
+	conn
+}
+
+func (s *mysqlProductStore) Set(ctx context.Context, p *Product) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := s.db.PrepareContext(ctx, `
 		INSERT INTO products(title, price, stock, business_id)
 		values (?, ?, ?, ?)
 		`)
@@ -23,7 +38,7 @@ func (p *Product) Set(db *sql.DB) error {
 	}
 	defer stmt.Close()
 
-	res, err := stmt.Exec(p.Title, p.Price, p.Stock, p.BusinessID)
+	res, err := stmt.ExecContext(ctx, p.Title, p.Price, p.Stock, p.BusinessID)
 	if err != nil {
 		return err
 	}
@@ -36,18 +51,21 @@ func (p *Product) Set(db *sql.DB) error {
 	return nil
 }
 
-func (p *Product) GetByID(db *sql.DB) error {
+func (s *mysqlProductStore) GetByID(ctx context.Context, p *Product) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	stmt := `
 		SELECT id, title, price, stock, business_id
 		FROM products
 		WHERE id = ?
 	`
 
-	row := db.QueryRow(stmt, p.ID)
+	row := s.db.QueryRowContext(ctx, stmt, p.ID)
 	err := row.Scan(&p.ID, &p.Title, &p.Price, &p.Stock, &p.BusinessID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return errors.New("product not found")
+			return ErrProductNotFound
 		}
 		return err
 	}
@@ -55,20 +73,26 @@ func (p *Product) GetByID(db *sql.DB) error {
 	return nil
 }
 
-func (p *Product) Update(db *sql.DB) error {
+func (s *mysqlProductStore) Update(ctx context.Context, p *Product) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	stmt := `
 		UPDATE products
 		SET title = ?, price = ?, stock = ?
 		WHERE id = ?
 	`
 
-	_, err := db.Exec(stmt, p.Title, p.Price, p.Stock, p.ID)
+	_, err := s.db.ExecContext(ctx, stmt, p.Title, p.Price, p.Stock, p.ID)
 
 	return err
 }
 
-func (p *Product) Delete(db *sql.DB) error {
+func (s *mysqlProductStore) Delete(ctx context.Context, p *Product) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	stmt := `DELETE FROM products WHERE id = ?`
-	_, err := db.Exec(stmt, p.ID)
+	_, err := s.db.ExecContext(ctx, stmt, p.ID)
 	return err
 }
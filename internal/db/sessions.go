@@ -1,32 +1,66 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
+
 	"github.com/google/uuid"
 )
 
 type Session struct {
-	ID     int
-	UUID   string
-	UserID int
+	ID         int
+	UUID       string
+	UserID     int
+	ExpiresAt  time.Time
+	LastSeenAt time.Time
+	CreatedAt  time.Time
+	// Client that signed in, for the active sessions page
+	IP        string
+	UserAgent string
+}
+
+type SessionStore interface {
+	Set(ctx context.Context, s *Session) error
+	Get(ctx context.Context, s *Session) error
+	// Most recently used first
+	ListByUserID(ctx context.Context, userID int) ([]Session, error)
+	// Update LastSeenAt
+	Touch(ctx context.Context, s *Session) error
+	Delete(ctx context.Context, s *Session) error
+	// Delete the session with s.ID only if it belongs to s.UserID
+	DeleteForUser(ctx context.Context, s *Session) error
+	// Sign the user out everywhere but the session keepUUID
+	DeleteOthers(ctx context.Context, userID int, keepUUID string) (int64, error)
+	// Sign the user out everywhere
+	DeleteByUserID(ctx context.Context, userID int) error
+	// Delete sessions past their expiry or not seen since idleSince
+	DeleteExpired(ctx context.Context, now time.Time, idleSince time.Time) (int64, error)
 }
 
-func (s *Session) Set(db *sql.DB) error {
+type mysqlSessionStore struct {
+	conn
+}
+
+func (st *mysqlSessionStore) Set(ctx context.Context, s *Session) error {
+	ctx, cancel := st.withTimeout(ctx)
+	defer cancel()
+
 	if s.UUID == "" {
 		s.UUID = uuid.New().String()
 	}
 
-	stmt, err := db.Prepare(`
-		INSERT INTO sessions(uuid, user_id)
-		VALUES (?, ?)
+	stmt, err := st.db.PrepareContext(ctx, `
+		INSERT INTO sessions(uuid, user_id, expires_at, last_seen_at, created_at, ip, user_agent)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
 	`)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	res, err := stmt.Exec(s.UUID, s.UserID)
+	res, err := stmt.ExecContext(ctx, s.UUID, s.UserID, s.ExpiresAt, s.LastSeenAt, s.CreatedAt, s.IP, s.UserAgent)
 	if err != nil {
 		return err
 	}
@@ -39,25 +73,121 @@ func (s *Session) Set(db *sql.DB) error {
 	return nil
 }
 
-func (s *Session) Get(db *sql.DB) error {
+func (st *mysqlSessionStore) Get(ctx context.Context, s *Session) error {
+	ctx, cancel := st.withTimeout(ctx)
+	defer cancel()
+
 	stmt := `
-		SELECT id, uuid, user_id 
+		SELECT id, uuid, user_id, expires_at, last_seen_at, created_at, ip, user_agent
 		FROM sessions
 		WHERE uuid = ?
 	`
-	row := db.QueryRow(stmt, s.UUID)
-	err := row.Scan(&s.ID, &s.UUID, &s.UserID)
+	row := st.db.QueryRowContext(ctx, stmt, s.UUID)
+	err := row.Scan(&s.ID, &s.UUID, &s.UserID, &s.ExpiresAt, &s.LastSeenAt, &s.CreatedAt, &s.IP, &s.UserAgent)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return errors.New("session not found")
+			return ErrSessionNotFound
 		}
 		return err
 	}
 	return nil
 }
 
-func (s *Session) Delete(db *sql.DB) error {
+func (st *mysqlSessionStore) ListByUserID(ctx context.Context, userID int) ([]Session, error) {
+	ctx, cancel := st.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `
+		SELECT id, uuid, user_id, expires_at, last_seen_at, created_at, ip, user_agent
+		FROM sessions
+		WHERE user_id = ?
+		ORDER BY last_seen_at DESC, id DESC
+	`
+	rows, err := st.db.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.UUID, &s.UserID, &s.ExpiresAt, &s.LastSeenAt, &s.CreatedAt, &s.IP, &s.UserAgent); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+
+	return sessions, rows.Err()
+}
+
+func (st *mysqlSessionStore) Touch(ctx context.Context, s *Session) error {
+	ctx, cancel := st.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE sessions SET last_seen_at = ? WHERE uuid = ?`
+	_, err := st.db.ExecContext(ctx, stmt, s.LastSeenAt, s.UUID)
+	return err
+}
+
+func (st *mysqlSessionStore) Delete(ctx context.Context, s *Session) error {
+	ctx, cancel := st.withTimeout(ctx)
+	defer cancel()
+
 	stmt := `DELETE FROM sessions WHERE uuid = ?`
-	_, err := db.Exec(stmt, s.UUID)
+	_, err := st.db.ExecContext(ctx, stmt, s.UUID)
+	return err
+}
+
+func (st *mysqlSessionStore) DeleteForUser(ctx context.Context, s *Session) error {
+	ctx, cancel := st.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `DELETE FROM sessions WHERE id = ? AND user_id = ?`
+	res, err := st.db.ExecContext(ctx, stmt, s.ID, s.UserID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (st *mysqlSessionStore) DeleteOthers(ctx context.Context, userID int, keepUUID string) (int64, error) {
+	ctx, cancel := st.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `DELETE FROM sessions WHERE user_id = ? AND uuid <> ?`
+	res, err := st.db.ExecContext(ctx, stmt, userID, keepUUID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (st *mysqlSessionStore) DeleteByUserID(ctx context.Context, userID int) error {
+	ctx, cancel := st.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `DELETE FROM sessions WHERE user_id = ?`
+	_, err := st.db.ExecContext(ctx, stmt, userID)
 	return err
 }
+
+func (st *mysqlSessionStore) DeleteExpired(ctx context.Context, now time.Time, idleSince time.Time) (int64, error) {
+	ctx, cancel := st.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `DELETE FROM sessions WHERE expires_at <= ? OR last_seen_at <= ?`
+	res, err := st.db.ExecContext(ctx, stmt, now, idleSince)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
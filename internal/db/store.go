@@ -0,0 +1,150 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/pkg/env"
+	"github.com/go-sql-driver/mysql"
+)
+
+var (
+	ErrUserNotFound          = errors.New("user not found")
+	ErrStudentNotFound       = errors.New("student not found")
+	ErrBusinessNotFound      = errors.New("business not found")
+	ErrProductNotFound       = errors.New("product not found")
+	ErrProductImageNotFound  = errors.New("product image not found")
+	ErrSessionNotFound       = errors.New("session not found")
+	ErrCollaboratorNotFound  = errors.New("business collaborator relationship not found")
+	ErrAdminNotFound         = errors.New("admin not found")
+	ErrInvitationNotFound    = errors.New("invitation not found")
+	ErrPasswordResetNotFound = errors.New("password reset not found")
+	ErrTOTPNotFound          = errors.New("two-factor enrollment not found")
+	ErrAPITokenNotFound      = errors.New("api token not found")
+	ErrIdentityNotFound      = errors.New("identity not found")
+	ErrEmailTaken            = errors.New("email already in use")
+)
+
+// Every aggregate store, handlers only talk to the database through it
+type Store struct {
+	Users                 UserStore
+	Students              StudentStore
+	Businesses            BusinessStore
+	Products              ProductStore
+	ProductImages         ProductImageStore
+	Sessions              SessionStore
+	BusinessCollaborators BusinessCollaboratorStore
+	Admins                AdminStore
+	Invitations           InvitationStore
+	PasswordResets        PasswordResetStore
+	LoginEvents           LoginEventStore
+	TOTP                  TOTPStore
+	APITokens             APITokenStore
+	Identities            IdentityStore
+
+	Transactor
+}
+
+type Transactor interface {
+	// Run fn with a Store bound to one transaction, committing only when fn
+	// returns nil. Calling WithTx on the Store given to fn joins the same
+	// transaction.
+	WithTx(ctx context.Context, fn func(tx *Store) error) error
+}
+
+// Store backed by mysql, every query gets DB_QUERY_TIMEOUT unless the
+// caller's context ends first
+func NewMySQLStore(db *sql.DB) *Store {
+	timeout := env.GetDuration("DB_QUERY_TIMEOUT", 5*time.Second)
+
+	store := newMySQLStore(conn{db: db, timeout: timeout})
+	store.Transactor = &mysqlTransactor{db: db, timeout: timeout}
+	return store
+}
+
+func newMySQLStore(c conn) *Store {
+	return &Store{
+		Users:                 &mysqlUserStore{c},
+		Students:              &mysqlStudentStore{c},
+		Businesses:            &mysqlBusinessStore{c},
+		Products:              &mysqlProductStore{c},
+		ProductImages:         &mysqlProductImageStore{c},
+		Sessions:              &mysqlSessionStore{c},
+		BusinessCollaborators: &mysqlBusinessCollaboratorStore{c},
+		Admins:                &mysqlAdminStore{c},
+		Invitations:           &mysqlInvitationStore{c},
+		PasswordResets:        &mysqlPasswordResetStore{c},
+		LoginEvents:           &mysqlLoginEventStore{c},
+		TOTP:                  &mysqlTOTPStore{c},
+		APITokens:             &mysqlAPITokenStore{c},
+		Identities:            &mysqlIdentityStore{c},
+	}
+}
+
+// Satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type conn struct {
+	db      querier
+	timeout time.Duration
+}
+
+func (c conn) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+type mysqlTransactor struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func (t *mysqlTransactor) WithTx(ctx context.Context, fn func(tx *Store) error) (err error) {
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	txStore := newMySQLStore(conn{db: tx, timeout: t.timeout})
+	txStore.Transactor = joinedTx{txStore}
+
+	if err = fn(txStore); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Transactor of a Store that is already inside a transaction
+type joinedTx struct {
+	store *Store
+}
+
+func (j joinedTx) WithTx(ctx context.Context, fn func(tx *Store) error) error {
+	return fn(j.store)
+}
+
+// Whether err comes from breaking a unique index
+func isDuplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
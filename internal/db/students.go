@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 )
@@ -12,8 +13,25 @@ type Student struct {
 	UserID     int
 }
 
-func (s *Student) Set(db *sql.DB) error {
-	stmt, err := db.Prepare(`
+type StudentStore interface {
+	Set(ctx context.Context, s *Student) error
+	GetByID(ctx context.Context, s *Student) error
+	GetByUserID(ctx context.Context, s *Student) error
+	// Every student ordered by ID
+	List(ctx context.Context) ([]Student, error)
+	Update(ctx context.Context, s *Student) error
+	Delete(ctx context.Context, s *Student) error
+}
+
+type mysqlStudentStore struct {
+	conn
+}
+
+func (st *mysqlStudentStore) Set(ctx context.Context, s *Student) error {
+	ctx, cancel := st.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := st.db.PrepareContext(ctx, `
 		INSERT INTO students(id, grade, class_group, user_id) values (?, ?, ?, ?)
 	`)
 	if err != nil {
@@ -21,7 +39,7 @@ func (s *Student) Set(db *sql.DB) error {
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(s.ID, s.Grade, s.ClassGroup, s.UserID)
+	_, err = stmt.ExecContext(ctx, s.ID, s.Grade, s.ClassGroup, s.UserID)
 	if err != nil {
 		return err
 	}
@@ -29,15 +47,18 @@ func (s *Student) Set(db *sql.DB) error {
 	return nil
 }
 
-func (s *Student) GetByID(db *sql.DB) error {
+func (st *mysqlStudentStore) GetByID(ctx context.Context, s *Student) error {
+	ctx, cancel := st.withTimeout(ctx)
+	defer cancel()
+
 	stmt := `
 		SELECT id, grade, class_group, user_id FROM students WHERE id = ?
 	`
-	row := db.QueryRow(stmt, s.ID)
+	row := st.db.QueryRowContext(ctx, stmt, s.ID)
 	err := row.Scan(&s.ID, &s.Grade, &s.ClassGroup, &s.UserID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return errors.New("user not found")
+			return ErrStudentNotFound
 		}
 		return err
 	}
@@ -45,33 +66,65 @@ func (s *Student) GetByID(db *sql.DB) error {
 	return nil
 }
 
-func (s *Student) Update(db *sql.DB) error {
+func (st *mysqlStudentStore) Update(ctx context.Context, s *Student) error {
+	ctx, cancel := st.withTimeout(ctx)
+	defer cancel()
+
 	stmt := `
-		UPDATE users
+		UPDATE students
 		SET grade = ?, class_group = ?
 		WHERE id = ?
 	`
-	_, err := db.Exec(stmt, s.Grade, s.ClassGroup, s.ID)
+	_, err := st.db.ExecContext(ctx, stmt, s.Grade, s.ClassGroup, s.ID)
 	return err
 }
 
-func (s *Student) GetByUserID(db *sql.DB) error {
+func (st *mysqlStudentStore) GetByUserID(ctx context.Context, s *Student) error {
+	ctx, cancel := st.withTimeout(ctx)
+	defer cancel()
+
 	stmt := `
 		SELECT id, grade, class_group, user_id FROM students WHERE user_id = ?
 	`
-	row := db.QueryRow(stmt, s.UserID)
+	row := st.db.QueryRowContext(ctx, stmt, s.UserID)
 	err := row.Scan(&s.ID, &s.Grade, &s.ClassGroup, &s.UserID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return errors.New("student not found")
+			return ErrStudentNotFound
 		}
 		return err
 	}
 	return nil
 }
 
-func (s *Student) Delete(db *sql.DB) error {
+func (st *mysqlStudentStore) List(ctx context.Context) ([]Student, error) {
+	ctx, cancel := st.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT id, grade, class_group, user_id FROM students ORDER BY id`
+	rows, err := st.db.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var students []Student
+	for rows.Next() {
+		var s Student
+		if err := rows.Scan(&s.ID, &s.Grade, &s.ClassGroup, &s.UserID); err != nil {
+			return nil, err
+		}
+		students = append(students, s)
+	}
+
+	return students, rows.Err()
+}
+
+func (st *mysqlStudentStore) Delete(ctx context.Context, s *Student) error {
+	ctx, cancel := st.withTimeout(ctx)
+	defer cancel()
+
 	stmt := `DELETE FROM students WHERE id = ?`
-	_, err := db.Exec(stmt, s.ID)
+	_, err := st.db.ExecContext(ctx, stmt, s.ID)
 	return err
 }
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Authenticator app enrolled by a user
+type TOTP struct {
+	UserID int
+	Secret string
+	// Nil while enrollment waits for the first code
+	EnabledAt *time.Time
+	LastStep  int64
+}
+
+type TOTPStore interface {
+	// Start enrollment with t.Secret, replacing any earlier one
+	SetPending(ctx context.Context, t *TOTP) error
+	Get(ctx context.Context, t *TOTP) error
+	// Store t.EnabledAt and t.LastStep
+	Enable(ctx context.Context, t *TOTP) error
+	// Record step as used, false when it isn't newer than the last one
+	UseStep(ctx context.Context, t *TOTP, step int64) (bool, error)
+	// Remove the enrollment and its recovery codes
+	Delete(ctx context.Context, userID int) error
+	// Replace the user's recovery codes, only their hashes are kept
+	SetRecoveryCodes(ctx context.Context, userID int, hashes []string) error
+	// Use up a recovery code, false when there's no unused one with hash
+	UseRecoveryCode(ctx context.Context, userID int, hash string, now time.Time) (bool, error)
+	CountRecoveryCodes(ctx context.Context, userID int) (int, error)
+}
+
+type mysqlTOTPStore struct {
+	conn
+}
+
+func (s *mysqlTOTPStore) SetPending(ctx context.Context, t *TOTP) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO user_totp(user_id, secret, enabled_at, last_step)
+		VALUES (?, ?, NULL, 0)
+		ON DUPLICATE KEY UPDATE secret = VALUES(secret), enabled_at = NULL, last_step = 0
+	`
+	_, err := s.db.ExecContext(ctx, stmt, t.UserID, t.Secret)
+	if err != nil {
+		return err
+	}
+
+	t.EnabledAt = nil
+	t.LastStep = 0
+	return nil
+}
+
+func (s *mysqlTOTPStore) Get(ctx context.Context, t *TOTP) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT user_id, secret, enabled_at, last_step FROM user_totp WHERE user_id = ?`
+	err := s.db.QueryRowContext(ctx, stmt, t.UserID).Scan(&t.UserID, &t.Secret, &t.EnabledAt, &t.LastStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrTOTPNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *mysqlTOTPStore) Enable(ctx context.Context, t *TOTP) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE user_totp SET enabled_at = ?, last_step = ? WHERE user_id = ?`
+	_, err := s.db.ExecContext(ctx, stmt, t.EnabledAt, t.LastStep, t.UserID)
+	return err
+}
+
+func (s *mysqlTOTPStore) UseStep(ctx context.Context, t *TOTP, step int64) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE user_totp SET last_step = ? WHERE user_id = ? AND last_step < ?`
+	res, err := s.db.ExecContext(ctx, stmt, step, t.UserID, step)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+
+	t.LastStep = step
+	return true, nil
+}
+
+func (s *mysqlTOTPStore) Delete(ctx context.Context, userID int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = ?`, userID)
+	return err
+}
+
+func (s *mysqlTOTPStore) SetRecoveryCodes(ctx context.Context, userID int, hashes []string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+
+	stmt, err := s.db.PrepareContext(ctx, `INSERT INTO totp_recovery_codes(user_id, code_hash) VALUES (?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, hash := range hashes {
+		if _, err := stmt.ExecContext(ctx, userID, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *mysqlTOTPStore) UseRecoveryCode(ctx context.Context, userID int, hash string, now time.Time) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `
+		UPDATE totp_recovery_codes SET used_at = ?
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+		LIMIT 1
+	`
+	res, err := s.db.ExecContext(ctx, stmt, now, userID, hash)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (s *mysqlTOTPStore) CountRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var n int
+	stmt := `SELECT COUNT(*) FROM totp_recovery_codes WHERE user_id = ? AND used_at IS NULL`
+	err := s.db.QueryRowContext(ctx, stmt, userID).Scan(&n)
+	return n, err
+}
@@ -1,8 +1,10 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
 )
 
 type User struct {
@@ -13,19 +15,47 @@ type User struct {
 	PersonalID      string
 	Email           string
 	Hash            string
+	// Nil until the user follows the verification link
+	EmailVerifiedAt *time.Time
+	// Uploads key of the profile photo, empty when there is none
+	PhotoKey string
 }
 
-func (u *User) Set(db *sql.DB) error {
-	stmt, err := db.Prepare(`
-		INSERT INTO users(middle_names, paternal_surname, maternal_surname, personal_id, email, hash)
-		VALUES (?, ?, ?, ?, ?, ?)
+// Emails are unique, Set and Update return ErrEmailTaken for one that
+// belongs to another user
+type UserStore interface {
+	Set(ctx context.Context, u *User) error
+	GetByID(ctx context.Context, u *User) error
+	GetByEmail(ctx context.Context, u *User) error
+	Update(ctx context.Context, u *User) error
+	// Store u.EmailVerifiedAt
+	SetEmailVerified(ctx context.Context, u *User) error
+	// Store u.PhotoKey
+	SetPhotoKey(ctx context.Context, u *User) error
+	Delete(ctx context.Context, u *User) error
+}
+
+type mysqlUserStore struct {
+	conn
+}
+
+func (s *mysqlUserStore) Set(ctx context.Context, u *User) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := s.db.PrepareContext(ctx, `
+		INSERT INTO users(middle_names, paternal_surname, maternal_surname, personal_id, email, hash, email_verified_at, photo_key)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 	`)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	res, err := stmt.Exec(u.MiddleNames, u.PaternalSurname, u.MaternalSurname, u.PersonalID, u.Email, u.Hash)
+	res, err := stmt.ExecContext(ctx, u.MiddleNames, u.PaternalSurname, u.MaternalSurname, u.PersonalID, u.Email, u.Hash, u.EmailVerifiedAt, u.PhotoKey)
+	if isDuplicateKey(err) {
+		return ErrEmailTaken
+	}
 	if err != nil {
 		return err
 	}
@@ -38,17 +68,20 @@ func (u *User) Set(db *sql.DB) error {
 	return nil
 }
 
-func (u *User) GetByID(db *sql.DB) error {
+func (s *mysqlUserStore) GetByID(ctx context.Context, u *User) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	stmt := `
-		SELECT id, middle_names, paternal_surname, maternal_surname, personal_id, email, hash
+		SELECT id, middle_names, paternal_surname, maternal_surname, personal_id, email, hash, email_verified_at, photo_key
 		FROM users
 		WHERE id = ?
 	`
-	row := db.QueryRow(stmt, u.ID)
-	err := row.Scan(&u.ID, &u.MiddleNames, &u.PaternalSurname, &u.MaternalSurname, &u.PersonalID, &u.Email, &u.Hash)
+	row := s.db.QueryRowContext(ctx, stmt, u.ID)
+	err := row.Scan(&u.ID, &u.MiddleNames, &u.PaternalSurname, &u.MaternalSurname, &u.PersonalID, &u.Email, &u.Hash, &u.EmailVerifiedAt, &u.PhotoKey)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return errors.New("user not found")
+			return ErrUserNotFound
 		}
 		return err
 	}
@@ -56,36 +89,66 @@ func (u *User) GetByID(db *sql.DB) error {
 	return nil
 }
 
-func (u *User) GetByEmail(db *sql.DB) error {
+func (s *mysqlUserStore) GetByEmail(ctx context.Context, u *User) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	stmt := `
-		SELECT id, middle_names, paternal_surname, maternal_surname, personal_id, email, hash
+		SELECT id, middle_names, paternal_surname, maternal_surname, personal_id, email, hash, email_verified_at, photo_key
 		FROM users
 		WHERE email = ?
 	`
 
-	row := db.QueryRow(stmt, u.Email)
-	err := row.Scan(&u.ID, &u.MiddleNames, &u.PaternalSurname, &u.MaternalSurname, &u.PersonalID, &u.Email, &u.Hash)
+	row := s.db.QueryRowContext(ctx, stmt, u.Email)
+	err := row.Scan(&u.ID, &u.MiddleNames, &u.PaternalSurname, &u.MaternalSurname, &u.PersonalID, &u.Email, &u.Hash, &u.EmailVerifiedAt, &u.PhotoKey)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return errors.New("user not found")
+			return ErrUserNotFound
 		}
 		return err
 	}
 	return nil
 }
 
-func (u *User) Update(db *sql.DB) error {
+func (s *mysqlUserStore) Update(ctx context.Context, u *User) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	stmt := `
 		UPDATE users
 		SET middle_names = ?, paternal_surname = ?, maternal_surname = ?, personal_id = ?, email = ?, hash = ?
 		WHERE id = ?
 	`
-	_, err := db.Exec(stmt, u.MiddleNames, u.PaternalSurname, u.MaternalSurname, u.PersonalID, u.Email, u.Hash, u.ID)
+	_, err := s.db.ExecContext(ctx, stmt, u.MiddleNames, u.PaternalSurname, u.MaternalSurname, u.PersonalID, u.Email, u.Hash, u.ID)
+	if isDuplicateKey(err) {
+		return ErrEmailTaken
+	}
 	return err
 }
 
-func (u *User) Delete(db *sql.DB) error {
+func (s *mysqlUserStore) SetEmailVerified(ctx context.Context, u *User) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE users SET email_verified_at = ? WHERE id = ?`
+	_, err := s.db.ExecContext(ctx, stmt, u.EmailVerifiedAt, u.ID)
+	return err
+}
+
+func (s *mysqlUserStore) SetPhotoKey(ctx context.Context, u *User) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE users SET photo_key = ? WHERE id = ?`
+	_, err := s.db.ExecContext(ctx, stmt, u.PhotoKey, u.ID)
+	return err
+}
+
+func (s *mysqlUserStore) Delete(ctx context.Context, u *User) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	stmt := `DELETE FROM users WHERE id = ?`
-	_, err := db.Exec(stmt, u.ID)
+	_, err := s.db.ExecContext(ctx, stmt, u.ID)
 	return err
 }
@@ -1,126 +1,379 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
+	"github.com/calmestend/mercado_lobito/internal/app"
 	"github.com/calmestend/mercado_lobito/internal/auth"
 	"github.com/calmestend/mercado_lobito/internal/components"
 	"github.com/calmestend/mercado_lobito/internal/db"
+	"github.com/calmestend/mercado_lobito/internal/uploads"
 	"github.com/calmestend/mercado_lobito/internal/views"
 )
 
-func Home(w http.ResponseWriter, r *http.Request) {
-	isAuth := auth.IsAuthenticated(r)
+type Handlers struct {
+	*app.App
+	Auth *auth.Auth
+}
+
+func New(a *app.App, au *auth.Auth) *Handlers {
+	return &Handlers{App: a, Auth: au}
+}
+
+func (h *Handlers) Home(w http.ResponseWriter, r *http.Request) {
+	isAuth := h.Auth.IsAuthenticated(r)
 
 	homeComponent := views.Home()
-	page := views.Index(homeComponent, isAuth)
+	page := views.Index(homeComponent, isAuth, auth.CSRFToken(r.Context()))
 	page.Render(r.Context(), w)
 }
 
-func Profile(w http.ResponseWriter, r *http.Request) {
-	dbConn := db.Init()
-	defer dbConn.Close()
+func (h *Handlers) Profile(w http.ResponseWriter, r *http.Request) {
+	isAuth := h.Auth.IsAuthenticated(r)
 
-	isAuth := auth.IsAuthenticated(r)
-
-	sess, err := auth.GetSessionFromRequest(r)
+	sess, err := h.Auth.GetSessionFromRequest(r)
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
 	user := db.User{ID: sess.UserID}
-	if err := user.GetByID(dbConn); err != nil {
+	if err := h.Store.Users.GetByID(r.Context(), &user); err != nil {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
 
-	student := db.Student{UserID: user.ID}
-	if err := student.GetByUserID(dbConn); err != nil {
-		http.Error(w, "Student not found", http.StatusNotFound)
+	imgSrc := h.Uploads.URL(user.PhotoKey, uploads.ThumbnailLarge)
+
+	fullName := fmt.Sprintf("%s %s %s", user.MiddleNames, user.PaternalSurname, user.MaternalSurname)
+
+	profileComponent := views.Profile(fullName, imgSrc, user.EmailVerifiedAt != nil)
+	page := views.Index(profileComponent, isAuth, auth.CSRFToken(r.Context()))
+	page.Render(r.Context(), w)
+}
+
+func (h *Handlers) Sessions(w http.ResponseWriter, r *http.Request) {
+	isAuth := h.Auth.IsAuthenticated(r)
+
+	p, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		http.Redirect(w, r, "/", http.StatusFound)
 		return
 	}
 
-	fullName := fmt.Sprintf("%s %s %s", user.MiddleNames, user.PaternalSurname, user.MaternalSurname)
-	imgSrc := fmt.Sprintf("/uploads/%s.jpg", student.ID)
+	sessions, err := h.Auth.ListSessions(r.Context(), p.UserID)
+	if err != nil {
+		http.Error(w, "Error retrieving sessions", http.StatusInternalServerError)
+		return
+	}
 
-	profileComponent := views.Profile(fullName, imgSrc)
-	page := views.Index(profileComponent, isAuth)
+	sessionsComponent := views.Sessions(sessions, p.Session.ID)
+	page := views.Index(sessionsComponent, isAuth, auth.CSRFToken(r.Context()))
 	page.Render(r.Context(), w)
 }
 
-func Organization(w http.ResponseWriter, r *http.Request) {
-	isAuth := auth.IsAuthenticated(r)
+func (h *Handlers) Tokens(w http.ResponseWriter, r *http.Request) {
+	isAuth := h.Auth.IsAuthenticated(r)
+
+	p, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
 
-	organizationComponent := views.Organization()
-	page := views.Index(organizationComponent, isAuth)
+	tokens, err := h.Store.APITokens.ListByUserID(r.Context(), p.UserID)
+	if err != nil {
+		http.Error(w, "Error retrieving tokens", http.StatusInternalServerError)
+		return
+	}
+
+	tokensComponent := views.Tokens(tokens)
+	page := views.Index(tokensComponent, isAuth, auth.CSRFToken(r.Context()))
 	page.Render(r.Context(), w)
 }
 
-func OrganizationPassport(w http.ResponseWriter, r *http.Request) {
-	isAuth := auth.IsAuthenticated(r)
+// Businesses the principal belongs to plus the one the request is about
+func (h *Handlers) businesses(w http.ResponseWriter, r *http.Request) ([]db.Business, *auth.Membership, bool) {
+	m, err := h.Auth.ResolveBusiness(r, db.PermissionReadOnly)
+	if err != nil {
+		auth.BusinessError(w, err)
+		return nil, nil, false
+	}
 
-	organizationPassportComponent := views.OrganizationPassport()
-	page := views.Index(organizationPassportComponent, isAuth)
+	p, _ := auth.PrincipalFromContext(r.Context())
+	memberships, err := h.Auth.Memberships(r.Context(), p)
+	if err != nil {
+		http.Error(w, "Error retrieving businesses", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	businesses := make([]db.Business, 0, len(memberships))
+	for _, membership := range memberships {
+		businesses = append(businesses, membership.Business)
+	}
+
+	return businesses, m, true
+}
+
+func (h *Handlers) Organization(w http.ResponseWriter, r *http.Request) {
+	isAuth := h.Auth.IsAuthenticated(r)
+
+	businesses, m, ok := h.businesses(w, r)
+	if !ok {
+		return
+	}
+
+	organizationComponent := views.Organization(businesses, m.Business.ID, m.Permission.Allows(db.PermissionManageTeam))
+	page := views.Index(organizationComponent, isAuth, auth.CSRFToken(r.Context()))
 	page.Render(r.Context(), w)
 }
 
-func OrganizationProducts(w http.ResponseWriter, r *http.Request) {
-	isAuth := auth.IsAuthenticated(r)
+func (h *Handlers) OrganizationPassport(w http.ResponseWriter, r *http.Request) {
+	isAuth := h.Auth.IsAuthenticated(r)
 
-	productsComponent := views.Products()
-	page := views.Index(productsComponent, isAuth)
+	businesses, m, ok := h.businesses(w, r)
+	if !ok {
+		return
+	}
+
+	members, current, err := h.passportMembers(r, &m.Business)
+	if err != nil {
+		http.Error(w, "Error retrieving members", http.StatusInternalServerError)
+		return
+	}
+
+	organizationPassportComponent := views.OrganizationPassport(businesses, m.Business, members, current)
+	page := views.Index(organizationPassportComponent, isAuth, auth.CSRFToken(r.Context()))
 	page.Render(r.Context(), w)
 }
 
-func Settings(w http.ResponseWriter, r *http.Request) {
-	dbConn := db.Init()
-	isAuth := auth.IsAuthenticated(r)
+// Owner and collaborators of the business, plus the signed in one among them
+func (h *Handlers) passportMembers(r *http.Request, b *db.Business) ([]views.PassportMember, views.PassportMember, error) {
+	p, _ := auth.PrincipalFromContext(r.Context())
+
+	owner := db.Student{ID: b.OwnerID}
+	if err := h.Store.Students.GetByID(r.Context(), &owner); err != nil {
+		return nil, views.PassportMember{}, err
+	}
+	ownerUser := db.User{ID: owner.UserID}
+	if err := h.Store.Users.GetByID(r.Context(), &ownerUser); err != nil {
+		return nil, views.PassportMember{}, err
+	}
 
-	sess, err := auth.GetSessionFromRequest(r)
+	collabs, err := h.Store.Businesses.GetCollaboratorsByBusinessID(r.Context(), b)
 	if err != nil {
-		http.Redirect(w, r, "/", http.StatusFound)
+		return nil, views.PassportMember{}, err
+	}
+
+	users := []db.User{ownerUser}
+	for _, c := range collabs {
+		users = append(users, c.User)
+	}
+
+	var members []views.PassportMember
+	var current views.PassportMember
+	for i, u := range users {
+		m := views.PassportMember{
+			Name:     fmt.Sprintf("%s %s %s", u.MiddleNames, u.PaternalSurname, u.MaternalSurname),
+			Position: "Colaborador",
+			ImgSrc:   h.Uploads.URL(u.PhotoKey, uploads.ThumbnailLarge),
+		}
+		if i == 0 {
+			m.Position = "Fundador"
+		}
+		if u.ID == p.UserID {
+			current = m
+		}
+		members = append(members, m)
+	}
+	return members, current, nil
+}
+
+func (h *Handlers) OrganizationProducts(w http.ResponseWriter, r *http.Request) {
+	isAuth := h.Auth.IsAuthenticated(r)
+
+	businesses, m, ok := h.businesses(w, r)
+	if !ok {
 		return
 	}
 
-	student := db.Student{UserID: sess.UserID}
-	if err := student.GetByUserID(dbConn); err != nil {
+	productsComponent := views.Products(businesses, m.Business.ID, m.Permission.Allows(db.PermissionManageProducts))
+	page := views.Index(productsComponent, isAuth, auth.CSRFToken(r.Context()))
+	page.Render(r.Context(), w)
+}
+
+func (h *Handlers) Settings(w http.ResponseWriter, r *http.Request) {
+	isAuth := h.Auth.IsAuthenticated(r)
+
+	p, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
 	}
 
-	business := db.Business{OwnerID: student.ID}
-	err = business.GetByOwnerID(dbConn)
+	// Admins and external collaborators only get the security settings
+	business := db.Business{OwnerID: p.StudentID}
+	if p.StudentID != "" {
+		err := h.Store.Businesses.GetByOwnerID(r.Context(), &business)
+		if err != nil && !errors.Is(err, db.ErrBusinessNotFound) {
+			http.Error(w, "Error retrieving business", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var candidates []db.Collaborator
+	if business.ID != 0 {
+		var err error
+		candidates, err = h.Auth.TransferCandidates(r.Context(), &business)
+		if err != nil {
+			http.Error(w, "Error retrieving collaborators", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	u := db.User{ID: p.UserID}
+	if err := h.Store.Users.GetByID(r.Context(), &u); err != nil {
+		http.Error(w, "Error retrieving account", http.StatusInternalServerError)
+		return
+	}
+
+	enabled, recoveryCodesLeft, err := h.Auth.TwoFactorStatus(r.Context(), p.UserID)
+	if err != nil {
+		http.Error(w, "Error retrieving two-factor status", http.StatusInternalServerError)
+		return
+	}
 
 	settingsComponent := views.Settings(
-		fmt.Sprintf("/uploads/%s.jpg", student.ID),
+		p.StudentID != "",
+		h.Uploads.URL(u.PhotoKey, uploads.ThumbnailLarge),
 		business.Name,
 		business.Type,
 		business.Description,
+		components.TwoFactorSettings(enabled, recoveryCodesLeft, p.NeedsTwoFactor(), u.Hash != ""),
+		views.AccountSettings(business.ID != 0, candidates, u.Hash != "", enabled),
 	)
-	page := views.Index(settingsComponent, isAuth)
+	page := views.Index(settingsComponent, isAuth, auth.CSRFToken(r.Context()))
 	page.Render(r.Context(), w)
 }
 
-func Login(w http.ResponseWriter, r *http.Request) {
-	if auth.IsAuthenticated(r) {
+func (h *Handlers) Login(w http.ResponseWriter, r *http.Request) {
+	if h.Auth.IsAuthenticated(r) {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
 	}
 
-	loginComponent := components.Login()
-	page := views.Index(loginComponent, false)
+	message := ""
+	if r.URL.Query().Get("error") == "oidc" {
+		message = "No pudimos iniciar sesión con tu cuenta institucional"
+	}
+
+	loginComponent := components.Login(h.Auth.OIDCEnabled(), message)
+	page := views.Index(loginComponent, false, auth.CSRFToken(r.Context()))
+	page.Render(r.Context(), w)
+}
+
+// Code prompt for sign ins that finished the first step elsewhere, like the
+// OIDC callback
+func (h *Handlers) TwoFactor(w http.ResponseWriter, r *http.Request) {
+	if _, err := r.Cookie("2fa_pending"); err != nil {
+		http.Redirect(w, r, "/auth/login", http.StatusFound)
+		return
+	}
+
+	page := views.Index(components.TwoFactorPrompt(), false, auth.CSRFToken(r.Context()))
 	page.Render(r.Context(), w)
 }
 
-func Register(w http.ResponseWriter, r *http.Request) {
-	if auth.IsAuthenticated(r) {
+func (h *Handlers) Register(w http.ResponseWriter, r *http.Request) {
+	if h.Auth.IsAuthenticated(r) {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
 	}
 
 	signupComponent := components.Signup()
-	page := views.Index(signupComponent, false)
+	page := views.Index(signupComponent, false, auth.CSRFToken(r.Context()))
+	page.Render(r.Context(), w)
+}
+
+func (h *Handlers) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	if h.Auth.IsAuthenticated(r) {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	page := views.Index(components.ForgotPassword(), false, auth.CSRFToken(r.Context()))
+	page.Render(r.Context(), w)
+}
+
+// Landing page of an emailed password reset link
+func (h *Handlers) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	isAuth := h.Auth.IsAuthenticated(r)
+	csrfToken := auth.CSRFToken(r.Context())
+
+	token := r.URL.Query().Get("token")
+	if _, err := h.Auth.PasswordResetFromToken(r.Context(), token); err != nil {
+		views.Index(components.PasswordResetInvalid(), isAuth, csrfToken).Render(r.Context(), w)
+		return
+	}
+
+	page := views.Index(components.ResetPassword(token), isAuth, csrfToken)
+	page.Render(r.Context(), w)
+}
+
+// Landing page of an emailed verification link
+func (h *Handlers) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	isAuth := h.Auth.IsAuthenticated(r)
+
+	_, err := h.Auth.VerifyEmail(r.Context(), r.URL.Query().Get("token"))
+	if err != nil && !errors.Is(err, auth.ErrInvalidEmailVerification) {
+		http.Error(w, "Error verifying email", http.StatusInternalServerError)
+		return
+	}
+
+	page := views.Index(components.EmailVerified(err == nil), isAuth, auth.CSRFToken(r.Context()))
+	page.Render(r.Context(), w)
+}
+
+// Landing page of an emailed invitation link
+func (h *Handlers) Invitation(w http.ResponseWriter, r *http.Request) {
+	isAuth := h.Auth.IsAuthenticated(r)
+	csrfToken := auth.CSRFToken(r.Context())
+
+	token := r.URL.Query().Get("token")
+	inv, err := h.Auth.InvitationFromToken(r.Context(), token)
+	if err != nil {
+		views.Index(components.InvitationInvalid(), isAuth, csrfToken).Render(r.Context(), w)
+		return
+	}
+
+	business := db.Business{ID: inv.BusinessID}
+	if err := h.Store.Businesses.Get(r.Context(), &business); err != nil {
+		http.Error(w, "Business not found", http.StatusNotFound)
+		return
+	}
+
+	u := db.User{Email: inv.Email}
+	err = h.Store.Users.GetByEmail(r.Context(), &u)
+	if err != nil && !errors.Is(err, db.ErrUserNotFound) {
+		http.Error(w, "Error retrieving user", http.StatusInternalServerError)
+		return
+	}
+
+	invitationComponent := components.Invitation(token, business.Name, inv.Email, err == nil, u.Hash != "")
+	page := views.Index(invitationComponent, isAuth, csrfToken)
+	page.Render(r.Context(), w)
+}
+
+func (h *Handlers) Admin(w http.ResponseWriter, r *http.Request) {
+	businesses, err := h.Store.Businesses.List(r.Context())
+	if err != nil {
+		http.Error(w, "Error retrieving businesses", http.StatusInternalServerError)
+		return
+	}
+
+	adminComponent := views.Admin(businesses)
+	page := views.Index(adminComponent, true, auth.CSRFToken(r.Context()))
 	page.Render(r.Context(), w)
 }
@@ -0,0 +1,140 @@
+// Package mail sends transactional email over SMTP
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/pkg/env"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	// Plain text
+	Body string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// Mailer talking to an SMTP server. Without credentials it sends
+// unauthenticated, which is what local stand-ins like MailHog expect.
+type SMTPMailer struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+	// Used for STARTTLS, nil verifies the server against the system roots
+	TLSConfig *tls.Config
+}
+
+func FromEnv() *SMTPMailer {
+	return &SMTPMailer{
+		Addr:     env.GetEnvDefault("SMTP_ADDR", "localhost:1025"),
+		From:     env.GetEnvDefault("SMTP_FROM", "Mercado Lobito <no-reply@mercadolobito.local>"),
+		Username: env.GetEnvDefault("SMTP_USERNAME", ""),
+		Password: env.GetEnvDefault("SMTP_PASSWORD", ""),
+	}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", m.Addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	host, _, err := net.SplitHostPort(m.Addr)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		config := &tls.Config{}
+		if m.TLSConfig != nil {
+			config = m.TLSConfig.Clone()
+		}
+		// The certificate is checked against the host of SMTP_ADDR
+		config.ServerName = host
+		if err := c.StartTLS(config); err != nil {
+			return err
+		}
+	}
+
+	if m.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.Username, m.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(envelopeAddress(m.From)); err != nil {
+		return err
+	}
+	if err := c.Rcpt(msg.To); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(m.format(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+func (m *SMTPMailer) format(msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.From)
+	fmt.Fprintf(&b, "To: %s\r\n", headerValue(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", headerValue(msg.Subject)))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// Keep user provided values from adding headers of their own
+func headerValue(v string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(v)
+}
+
+// Bare address out of "Name <address>"
+func envelopeAddress(from string) string {
+	if i := strings.LastIndexByte(from, '<'); i >= 0 {
+		return strings.TrimSuffix(from[i+1:], ">")
+	}
+	return from
+}
@@ -0,0 +1,172 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// Minimal SMTP server for one session, offering STARTTLS when cert is set
+type standIn struct {
+	addr string
+	// Message data and whether it came over TLS, sent once the session ends
+	done chan received
+}
+
+type received struct {
+	data string
+	tls  bool
+	err  error
+}
+
+func newStandIn(t *testing.T, cert *tls.Certificate) *standIn {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	s := &standIn{addr: l.Addr().String(), done: make(chan received, 1)}
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			s.done <- received{err: err}
+			return
+		}
+		defer conn.Close()
+		s.done <- serve(conn, cert)
+	}()
+	return s
+}
+
+func serve(conn net.Conn, cert *tls.Certificate) received {
+	var r received
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 stand-in ready")
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			r.err = err
+			return r
+		}
+		verb := strings.ToUpper(strings.Fields(line + " ")[0])
+
+		switch verb {
+		case "EHLO", "HELO":
+			if cert != nil && !r.tls {
+				tp.PrintfLine("250-stand-in")
+				tp.PrintfLine("250 STARTTLS")
+			} else {
+				tp.PrintfLine("250 stand-in")
+			}
+		case "STARTTLS":
+			tp.PrintfLine("220 go ahead")
+			tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{*cert}})
+			if err := tlsConn.Handshake(); err != nil {
+				r.err = err
+				return r
+			}
+			conn = tlsConn
+			tp = textproto.NewConn(tlsConn)
+			r.tls = true
+		case "MAIL", "RCPT", "RSET", "NOOP":
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 end with .")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				r.err = err
+				return r
+			}
+			r.data = string(data)
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return r
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+// Certificate for 127.0.0.1 and a pool trusting it
+func testCertificate(t *testing.T) (*tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(srv.Close)
+	pool := srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	return &srv.TLS.Certificates[0], pool
+}
+
+func TestSend(t *testing.T) {
+	cert, pool := testCertificate(t)
+
+	tests := []struct {
+		name    string
+		cert    *tls.Certificate
+		config  *tls.Config
+		wantTLS bool
+		wantErr bool
+	}{
+		{name: "plain", cert: nil},
+		{name: "starttls", cert: cert, config: &tls.Config{RootCAs: pool}, wantTLS: true},
+		// System roots don't know the stand-in's certificate
+		{name: "starttls untrusted", cert: cert, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStandIn(t, tt.cert)
+			m := &SMTPMailer{Addr: s.addr, From: "Mercado Lobito <no-reply@example.com>", TLSConfig: tt.config}
+
+			err := m.Send(context.Background(), Message{To: "ana@example.com", Subject: "Hola", Body: "line one\nline two"})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Send succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+
+			r := <-s.done
+			if r.err != nil {
+				t.Fatalf("stand-in: %v", r.err)
+			}
+			if r.tls != tt.wantTLS {
+				t.Errorf("tls = %v, want %v", r.tls, tt.wantTLS)
+			}
+			for _, want := range []string{"To: ana@example.com\n", "Subject: Hola\n", "line one\nline two"} {
+				if !strings.Contains(r.data, want) {
+					t.Errorf("message missing %q:\n%s", want, r.data)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatStripsHeaderInjection(t *testing.T) {
+	m := &SMTPMailer{From: "no-reply@example.com"}
+	data := string(m.format(Message{To: "ana@example.com\r\nBcc: eve@example.com", Subject: "x", Body: "y"}))
+
+	tp := textproto.NewReader(bufio.NewReader(strings.NewReader(data)))
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bcc := header.Get("Bcc"); bcc != "" {
+		t.Errorf("Bcc header injected: %q", bcc)
+	}
+}
@@ -1,44 +1,94 @@
 package router
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/calmestend/mercado_lobito/internal/api"
+	"github.com/calmestend/mercado_lobito/internal/app"
 	"github.com/calmestend/mercado_lobito/internal/auth"
+	"github.com/calmestend/mercado_lobito/internal/db"
 	"github.com/calmestend/mercado_lobito/internal/handlers"
 )
 
-func Init() {
+func Init(a *app.App) {
+	authentication := auth.New(a)
+	pages := handlers.New(a, authentication)
+	endpoints := api.New(a, authentication)
+
+	go authentication.SweepSessions(context.Background())
+
+	mux := http.NewServeMux()
+
+	requireMember := func(next http.HandlerFunc) http.HandlerFunc {
+		return authentication.AuthMiddleware(authentication.RequireBusinessMember(next))
+	}
+	// Same as requireMember but API tokens with the scopes get in too
+	requireMemberOrToken := func(read, write db.Scope, next http.HandlerFunc) http.HandlerFunc {
+		return authentication.AllowTokens(read, write)(requireMember(next))
+	}
+	requireAdmin := func(next http.HandlerFunc) http.HandlerFunc {
+		return authentication.AuthMiddleware(authentication.RequireRole(auth.RoleAdmin)(authentication.RequireTwoFactor(next)))
+	}
+
 	// Render HTML
-	http.HandleFunc("/", handlers.Home)
-	http.HandleFunc("/profile", auth.AuthMiddleware(handlers.Profile))
-	http.HandleFunc("/profile/config", auth.AuthMiddleware(handlers.Settings))
-	http.HandleFunc("/organization", auth.AuthMiddleware(handlers.Organization))
+	mux.HandleFunc("/", pages.Home)
+	mux.HandleFunc("/profile", authentication.AuthMiddleware(pages.Profile))
+	mux.HandleFunc("/profile/config", authentication.AuthMiddleware(pages.Settings))
+	mux.HandleFunc("/profile/sessions", authentication.AuthMiddleware(pages.Sessions))
+	mux.HandleFunc("/profile/tokens", authentication.AuthMiddleware(pages.Tokens))
 
 	// Only Available if you have an organization
-	http.HandleFunc("/organization/products", auth.AuthMiddleware(handlers.OrganizationProducts))
-	http.HandleFunc("/organization/passport", auth.AuthMiddleware(handlers.OrganizationPassport)) // @TODO: Add download pdfs support via multipart-form
+	mux.HandleFunc("/organization", requireMember(pages.Organization))
+	mux.HandleFunc("/organization/products", requireMember(pages.OrganizationProducts))
+	mux.HandleFunc("/organization/passport", requireMember(pages.OrganizationPassport)) // @TODO: Add download pdfs support via multipart-form
+
+	// Admin
+	mux.HandleFunc("/admin", requireAdmin(pages.Admin))
 
 	// Auth
-	http.HandleFunc("/auth/login", handlers.Login)
-	http.HandleFunc("/auth/register", handlers.Register)
+	mux.HandleFunc("/auth/login", pages.Login)
+	mux.HandleFunc("/auth/register", pages.Register)
+	mux.HandleFunc("/invitations", pages.Invitation)
+	mux.HandleFunc("/auth/forgot", pages.ForgotPassword)
+	mux.HandleFunc("/auth/reset", pages.ResetPassword)
+	mux.HandleFunc("/auth/verify", pages.VerifyEmail)
+	mux.HandleFunc("/auth/2fa", pages.TwoFactor)
 
 	// Expose img directory
 	fs := http.FileServer(http.Dir("./internal/img"))
-	http.Handle("/img/", http.StripPrefix("/img/", fs))
+	mux.Handle("/img/", http.StripPrefix("/img/", fs))
 
-	// Expose Upload directory
-	http.Handle("/uploads/", http.StripPrefix("/uploads/", http.FileServer(http.Dir("uploads"))))
+	// Uploads, only through the signed URLs pages hand out
+	mux.Handle("/uploads/", http.StripPrefix("/uploads/", a.Uploads.Handler()))
 
 	// API
-	http.HandleFunc("/auth/signin", auth.Signin)
-	http.HandleFunc("/auth/signup", auth.Signup)
-	http.HandleFunc("/auth/logout", auth.Logout)
-	http.HandleFunc("/api/profile/config", auth.AuthMiddleware(api.ProfileConfig))
-	http.HandleFunc("/api/business/collaborators", auth.AuthMiddleware(api.BusinessCollaborators))
-	http.HandleFunc("/api/products/edit/", auth.AuthMiddleware(api.Products))
-	http.HandleFunc("/api/products/cancel/", auth.AuthMiddleware(api.Products))
-	http.HandleFunc("/api/products", auth.AuthMiddleware(api.Products))
-
-	http.ListenAndServe(":3030", nil)
+	mux.HandleFunc("/auth/signin", authentication.Signin)
+	mux.HandleFunc("/auth/signup", authentication.Signup)
+	mux.HandleFunc("/auth/logout", authentication.Logout)
+	mux.HandleFunc("/auth/password/forgot", authentication.RequestPasswordReset)
+	mux.HandleFunc("/auth/password/reset", authentication.ResetPassword)
+	mux.HandleFunc("/auth/verify/resend", authentication.AuthMiddleware(authentication.ResendEmailVerification))
+	mux.HandleFunc("/auth/2fa/verify", authentication.VerifyTwoFactor)
+	mux.HandleFunc("/auth/oidc/login", authentication.OIDCLogin)
+	mux.HandleFunc("/auth/oidc/callback", authentication.OIDCCallback)
+	mux.HandleFunc("/auth/2fa/setup", authentication.AuthMiddleware(authentication.SetupTwoFactor))
+	mux.HandleFunc("/auth/2fa/enable", authentication.AuthMiddleware(authentication.EnableTwoFactor))
+	mux.HandleFunc("/auth/2fa/disable", authentication.AuthMiddleware(authentication.DisableTwoFactor))
+	mux.HandleFunc("/invitations/accept", authentication.AcceptInvitation)
+	mux.HandleFunc("/invitations/decline", authentication.DeclineInvitation)
+	mux.HandleFunc("/api/profile/config", authentication.AuthMiddleware(endpoints.ProfileConfig))
+	mux.HandleFunc("/api/profile/sessions", authentication.AuthMiddleware(endpoints.ProfileSessions))
+	mux.HandleFunc("/api/profile/tokens", authentication.AuthMiddleware(endpoints.ProfileTokens))
+	mux.HandleFunc("/api/profile/photo", authentication.AuthMiddleware(endpoints.ProfilePhoto))
+	mux.HandleFunc("/api/profile/export", authentication.AuthMiddleware(endpoints.ProfileExport))
+	mux.HandleFunc("/api/profile/delete", authentication.AuthMiddleware(endpoints.ProfileDelete))
+	mux.HandleFunc("/api/business/collaborators", requireMemberOrToken(db.ScopeTeamRead, db.ScopeTeamWrite, endpoints.BusinessCollaborators))
+	mux.HandleFunc("/api/business/invitations", requireMemberOrToken(db.ScopeTeamRead, db.ScopeTeamWrite, endpoints.BusinessInvitations))
+	mux.HandleFunc("/api/products/edit/", requireMember(endpoints.Products))
+	mux.HandleFunc("/api/products/cancel/", requireMember(endpoints.Products))
+	mux.HandleFunc("/api/products", requireMemberOrToken(db.ScopeProductsRead, db.ScopeProductsWrite, endpoints.Products))
+	mux.HandleFunc("/api/products/", endpoints.LimitProductImageUploads(requireMember(endpoints.ProductImages)))
+
+	http.ListenAndServe(":3030", authentication.CSRFMiddleware(mux))
 }
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocal(t *testing.T) {
+	testDriver(t, NewLocal(t.TempDir()), "")
+}
+
+func TestLocalTraversal(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	dir := filepath.Join(root, "uploads")
+	l := NewLocal(dir)
+
+	secret := filepath.Join(root, "secret.jpg")
+	if err := os.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"../secret.jpg", "photos/../../secret.jpg", "/" + secret} {
+		if _, err := l.Get(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Get(%q): err = %v, want ErrInvalidKey", key, err)
+		}
+		if err := l.Put(ctx, key, strings.NewReader("overwritten"), 11, "image/jpeg"); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q): err = %v, want ErrInvalidKey", key, err)
+		}
+		if err := l.Delete(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Delete(%q): err = %v, want ErrInvalidKey", key, err)
+		}
+	}
+
+	data, err := os.ReadFile(secret)
+	if err != nil || string(data) != "secret" {
+		t.Errorf("file outside the directory touched: %q, %v", data, err)
+	}
+}
+
+func TestLocalDirectoryIsNotAFile(t *testing.T) {
+	ctx := context.Background()
+	l := NewLocal(t.TempDir())
+
+	if err := l.Put(ctx, "photos/1.jpg", strings.NewReader("x"), 1, "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Get(ctx, "photos"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestLocalPutLeavesNoTemporaryFiles(t *testing.T) {
+	dir := t.TempDir()
+	l := NewLocal(dir)
+
+	if err := l.Put(context.Background(), "1.jpg", strings.NewReader("x"), 1, "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "1.jpg" {
+		t.Errorf("directory holds %v, want only 1.jpg", entries)
+	}
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/calmestend/mercado_lobito/pkg/env"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Bucket on AWS S3 or anything speaking its API, like a local MinIO
+type S3Config struct {
+	// host:port without scheme
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+	// Address buckets as endpoint/bucket instead of bucket.endpoint, which
+	// MinIO and most stand-ins need
+	PathStyle bool
+}
+
+func S3ConfigFromEnv() S3Config {
+	return S3Config{
+		Endpoint:  env.GetEnvDefault("S3_ENDPOINT", "localhost:9000"),
+		Bucket:    env.GetEnvDefault("S3_BUCKET", "mercado-lobito"),
+		Region:    env.GetEnvDefault("S3_REGION", "us-east-1"),
+		AccessKey: env.GetEnvDefault("S3_ACCESS_KEY", ""),
+		SecretKey: env.GetEnvDefault("S3_SECRET_KEY", ""),
+		UseSSL:    env.GetBool("S3_USE_SSL", true),
+		PathStyle: env.GetBool("S3_PATH_STYLE", false),
+	}
+}
+
+type S3 struct {
+	client *minio.Client
+	config S3Config
+}
+
+func NewS3(config S3Config) (*S3, error) {
+	lookup := minio.BucketLookupAuto
+	if config.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+		Secure:       config.UseSSL,
+		Region:       config.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3{client: client, config: config}, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	_, err := s.client.PutObject(ctx, s.config.Bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	obj, err := s.client.GetObject(ctx, s.config.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	// GetObject is lazy, Stat makes the request so a missing key shows up here
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, s3Error(err)
+	}
+	return obj, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	err := s.client.RemoveObject(ctx, s.config.Bucket, key, minio.RemoveObjectOptions{})
+	if errors.Is(s3Error(err), ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// The bucket stays private, objects go through the app like local files
+func (s *S3) URL(key string) string {
+	return servePrefix + key
+}
+
+func s3Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Runs against a real bucket only when S3_TEST_ENDPOINT is set, the rest of
+// the S3_* variables configure it as they do the app. For a local MinIO:
+//
+//	S3_TEST_ENDPOINT=localhost:9000 S3_USE_SSL=false S3_PATH_STYLE=true \
+//	S3_ACCESS_KEY=minioadmin S3_SECRET_KEY=minioadmin go test ./internal/storage
+func TestS3(t *testing.T) {
+	endpoint := os.Getenv("S3_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_TEST_ENDPOINT not set")
+	}
+
+	config := S3ConfigFromEnv()
+	config.Endpoint = endpoint
+	s, err := NewS3(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	exists, err := s.client.BucketExists(ctx, config.Bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		if err := s.client.MakeBucket(ctx, config.Bucket, minio.MakeBucketOptions{Region: config.Region}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Own prefix so runs sharing a bucket don't collide
+	b := make([]byte, 8)
+	rand.Read(b)
+	prefix := "test-" + hex.EncodeToString(b) + "/"
+
+	testDriver(t, s, prefix)
+}
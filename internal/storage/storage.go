@@ -0,0 +1,59 @@
+// Package storage keeps uploaded files, on local disk or in an
+// S3-compatible bucket that several app instances can share
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/calmestend/mercado_lobito/pkg/env"
+)
+
+// Uploads are private, every driver's files are served by the app under this
+// path after it checks the request may see them
+const servePrefix = "/uploads/"
+
+var (
+	ErrNotFound   = errors.New("file not found")
+	ErrInvalidKey = errors.New("invalid file key")
+)
+
+// Files are addressed by keys like "1234.jpg" or "products/7.jpg"
+type Storage interface {
+	// Store r under key, replacing what was there. Readers of the key see
+	// either the old file or the whole new one.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// ErrNotFound when there is nothing under key
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Deleting a missing key is not an error
+	Delete(ctx context.Context, key string) error
+	// Path the app serves key under, without any signature
+	URL(key string) string
+}
+
+// Driver picked with STORAGE_DRIVER, "local" (default) or "s3"
+func FromEnv() Storage {
+	switch driver := env.GetEnvDefault("STORAGE_DRIVER", "local"); driver {
+	case "local":
+		return NewLocal(env.GetEnvDefault("UPLOAD_DIR", "./uploads"))
+	case "s3":
+		s, err := NewS3(S3ConfigFromEnv())
+		if err != nil {
+			log.Fatalf("Error %s when configuring S3 storage\n", err)
+		}
+		return s
+	default:
+		log.Fatalf("Unknown STORAGE_DRIVER %q, use local or s3\n", driver)
+		return nil
+	}
+}
+
+// Keys are relative slash separated paths that stay inside the storage
+func validKey(key string) bool {
+	return key != "" && key != "." && !strings.HasPrefix(key, "/") && path.Clean(key) == key &&
+		key != ".." && !strings.HasPrefix(key, "../")
+}
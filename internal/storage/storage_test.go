@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestValidKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"1234.jpg", true},
+		{"products/7.jpg", true},
+		{"photos/ab/cd.jpg", true},
+		{"..jpg", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../secret", false},
+		{"photos/../../secret", false},
+		{"photos/../7.jpg", false},
+		{"/etc/passwd", false},
+		{"photos//7.jpg", false},
+		{"photos/./7.jpg", false},
+		{"photos/", false},
+	}
+
+	for _, tt := range tests {
+		if got := validKey(tt.key); got != tt.want {
+			t.Errorf("validKey(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
+
+// Behaviour every driver shares, keys are created under prefix
+func testDriver(t *testing.T, s Storage, prefix string) {
+	ctx := context.Background()
+	key := prefix + "photos/1.jpg"
+
+	put := func(t *testing.T, data string) {
+		t.Helper()
+		if err := s.Put(ctx, key, strings.NewReader(data), int64(len(data)), "image/jpeg"); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	read := func(t *testing.T) string {
+		t.Helper()
+		f, err := s.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		defer f.Close()
+		data, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	t.Run("get missing", func(t *testing.T) {
+		if _, err := s.Get(ctx, prefix+"missing.jpg"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("err = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("put and get", func(t *testing.T) {
+		put(t, "first")
+		if got := read(t); got != "first" {
+			t.Errorf("got %q, want %q", got, "first")
+		}
+	})
+
+	t.Run("put replaces", func(t *testing.T) {
+		put(t, "second")
+		if got := read(t); got != "second" {
+			t.Errorf("got %q, want %q", got, "second")
+		}
+	})
+
+	t.Run("url", func(t *testing.T) {
+		if got, want := s.URL(key), "/uploads/"+key; got != want {
+			t.Errorf("URL = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if err := s.Delete(ctx, key); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, err := s.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get after Delete: err = %v, want ErrNotFound", err)
+		}
+		if err := s.Delete(ctx, key); err != nil {
+			t.Errorf("Delete of a missing key: %v", err)
+		}
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		for _, bad := range []string{"", "../escape.jpg", "/abs.jpg", prefix + "a/../../escape.jpg"} {
+			if err := s.Put(ctx, bad, strings.NewReader("x"), 1, "image/jpeg"); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("Put(%q): err = %v, want ErrInvalidKey", bad, err)
+			}
+			if _, err := s.Get(ctx, bad); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("Get(%q): err = %v, want ErrInvalidKey", bad, err)
+			}
+			if err := s.Delete(ctx, bad); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("Delete(%q): err = %v, want ErrInvalidKey", bad, err)
+			}
+		}
+	})
+}
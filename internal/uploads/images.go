@@ -0,0 +1,133 @@
+package uploads
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+const jpegQuality = 85
+
+var (
+	ErrNotImage   = errors.New("file must be a PNG, JPEG or WebP image")
+	ErrTooLarge   = errors.New("file is too large")
+	ErrDimensions = errors.New("image dimensions are out of bounds")
+)
+
+// Limits checked on every upload before it is decoded
+type Limits struct {
+	MaxBytes int64
+	// Both sides must be at least MinDimension and at most MaxDimension
+	MinDimension int
+	MaxDimension int
+	// Longest side of the stored image, bigger ones are scaled down
+	StoredDimension int
+}
+
+type decodeFunc func(io.Reader) (image.Image, error)
+
+type decodeConfigFunc func(io.Reader) (image.Config, error)
+
+// Formats accepted, by sniffed content type
+var formats = map[string]struct {
+	decode       decodeFunc
+	decodeConfig decodeConfigFunc
+}{
+	"image/jpeg": {jpeg.Decode, jpeg.DecodeConfig},
+	"image/png":  {png.Decode, png.DecodeConfig},
+	"image/webp": {webp.Decode, webp.DecodeConfig},
+}
+
+// Read, validate and decode an uploaded image, along with its EXIF
+// orientation. Nothing else from the original's metadata survives.
+func decode(r io.Reader, limits Limits) (image.Image, int, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limits.MaxBytes+1))
+	if err != nil {
+		return nil, 0, err
+	}
+	if int64(len(data)) > limits.MaxBytes {
+		return nil, 0, ErrTooLarge
+	}
+
+	format, ok := formats[http.DetectContentType(data)]
+	if !ok {
+		return nil, 0, ErrNotImage
+	}
+
+	// Check the header first so a tiny file claiming huge dimensions is
+	// refused before any pixels are allocated
+	config, err := format.decodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, ErrNotImage
+	}
+	if !withinLimits(config.Width, config.Height, limits) {
+		return nil, 0, fmt.Errorf("%w: %dx%d, each side must be between %d and %d pixels",
+			ErrDimensions, config.Width, config.Height, limits.MinDimension, limits.MaxDimension)
+	}
+
+	img, err := format.decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, ErrNotImage
+	}
+
+	return img, exifOrientation(data), nil
+}
+
+func withinLimits(width, height int, limits Limits) bool {
+	return width >= limits.MinDimension && height >= limits.MinDimension &&
+		width <= limits.MaxDimension && height <= limits.MaxDimension
+}
+
+// Scale img down so its longest side is at most size, never up
+func fit(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+
+	if w >= h {
+		h = max(h*size/w, 1)
+		w = size
+	} else {
+		w = max(w*size/h, 1)
+		h = size
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// Square of size pixels cut from the middle of img, the way avatars and
+// product cards show it
+func thumbnail(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	x := b.Min.X + (b.Dx()-side)/2
+	y := b.Min.Y + (b.Dy()-side)/2
+	crop := image.Rect(x, y, x+side, y+side)
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, crop, draw.Src, nil)
+	return dst
+}
+
+// JPEG has no alpha, transparent areas are put on white instead of black
+func encodeJPEG(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	flat := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, b.Min, draw.Over)
+
+	return jpeg.Encode(w, flat, &jpeg.Options{Quality: jpegQuality})
+}
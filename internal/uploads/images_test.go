@@ -0,0 +1,328 @@
+package uploads
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+var (
+	red   = color.RGBA{255, 0, 0, 255}
+	green = color.RGBA{0, 255, 0, 255}
+	blue  = color.RGBA{0, 0, 255, 255}
+	white = color.RGBA{255, 255, 255, 255}
+)
+
+// w by h image with red, green, blue and white quarters, clockwise from the
+// top left, so where each lands shows how it was turned
+func quarters(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			c := red
+			switch {
+			case x >= w/2 && y < h/2:
+				c = green
+			case x >= w/2 && y >= h/2:
+				c = white
+			case x < w/2 && y >= h/2:
+				c = blue
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeTestJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeGIF(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Camera make stored next to the orientation, it must not survive
+const testCameraMake = "SecretCam"
+
+// JPEG data with an EXIF block right after its start marker, holding the
+// orientation and a camera make
+func withEXIF(data []byte, orientation int, order binary.ByteOrder) []byte {
+	tiff := []byte("MM\x00\x00\x00\x00\x00\x00")
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+
+	// Two entries sorted by tag, then the make's text
+	ifd := make([]byte, 2+2*12+4)
+	cameraMake := []byte(testCameraMake + "\x00")
+	order.PutUint16(ifd, 2)
+	entry := ifd[2:]
+	order.PutUint16(entry, 0x010F)
+	order.PutUint16(entry[2:], 2)
+	order.PutUint32(entry[4:], uint32(len(cameraMake)))
+	order.PutUint32(entry[8:], uint32(len(tiff)+len(ifd)))
+	entry = ifd[14:]
+	order.PutUint16(entry, exifOrientationTag)
+	order.PutUint16(entry[2:], 3)
+	order.PutUint32(entry[4:], 1)
+	order.PutUint16(entry[8:], uint16(orientation))
+
+	segment := append([]byte("Exif\x00\x00"), append(append(tiff, ifd...), cameraMake...)...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app1...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+// Which of the test colours is closest to the pixel at the fractions fx, fy
+// of img's width and height
+func colorAt(img image.Image, fx, fy float64) color.RGBA {
+	b := img.Bounds()
+	r, g, bl, _ := img.At(b.Min.X+int(fx*float64(b.Dx())), b.Min.Y+int(fy*float64(b.Dy()))).RGBA()
+
+	best, bestDist := red, -1
+	for _, c := range []color.RGBA{red, green, blue, white} {
+		dr, dg, db := int(r>>8)-int(c.R), int(g>>8)-int(c.G), int(bl>>8)-int(c.B)
+		if d := dr*dr + dg*dg + db*db; bestDist < 0 || d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// Colours of the top left, top right, bottom right and bottom left quarters
+func corners(img image.Image) [4]color.RGBA {
+	return [4]color.RGBA{colorAt(img, .25, .25), colorAt(img, .75, .25), colorAt(img, .75, .75), colorAt(img, .25, .75)}
+}
+
+func TestStage(t *testing.T) {
+	upright := [4]color.RGBA{red, green, white, blue}
+
+	tests := []struct {
+		name          string
+		data          []byte
+		wantErr       error
+		width, height int
+		corners       [4]color.RGBA
+	}{
+		{name: "png", data: encodePNG(t, quarters(200, 100)), width: 200, height: 100, corners: upright},
+		{name: "jpeg", data: encodeTestJPEG(t, quarters(200, 100)), width: 200, height: 100, corners: upright},
+		{name: "scaled to the stored dimension", data: encodePNG(t, quarters(800, 200)), width: 400, height: 100, corners: upright},
+		{name: "smallest allowed", data: encodePNG(t, quarters(64, 64)), width: 64, height: 64, corners: upright},
+		{
+			name: "exif turned left", data: withEXIF(encodeTestJPEG(t, quarters(200, 100)), 6, binary.BigEndian),
+			width: 100, height: 200, corners: [4]color.RGBA{blue, red, green, white},
+		},
+		{
+			name: "exif turned right little endian", data: withEXIF(encodeTestJPEG(t, quarters(200, 100)), 8, binary.LittleEndian),
+			width: 100, height: 200, corners: [4]color.RGBA{green, white, blue, red},
+		},
+		{
+			name: "exif upside down", data: withEXIF(encodeTestJPEG(t, quarters(200, 100)), 3, binary.BigEndian),
+			width: 200, height: 100, corners: [4]color.RGBA{white, blue, red, green},
+		},
+		{
+			name: "exif upright", data: withEXIF(encodeTestJPEG(t, quarters(200, 100)), 1, binary.BigEndian),
+			width: 200, height: 100, corners: upright,
+		},
+		{name: "too small", data: encodePNG(t, quarters(32, 32)), wantErr: ErrDimensions},
+		{name: "one side too small", data: encodePNG(t, quarters(300, 40)), wantErr: ErrDimensions},
+		{name: "too big", data: encodePNG(t, quarters(2100, 64)), wantErr: ErrDimensions},
+		{name: "too many bytes", data: append(encodePNG(t, quarters(64, 64)), make([]byte, testLimits.MaxBytes)...), wantErr: ErrTooLarge},
+		{name: "text", data: []byte("definitely not an image"), wantErr: ErrNotImage},
+		{name: "gif", data: encodeGIF(t, quarters(100, 100)), wantErr: ErrNotImage},
+		{name: "truncated jpeg", data: encodeTestJPEG(t, quarters(200, 100))[:200], wantErr: ErrNotImage},
+		{name: "empty", data: nil, wantErr: ErrNotImage},
+	}
+
+	s := newTestService(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			staged, err := s.Stage(bytes.NewReader(tt.data))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) || !IsInvalid(err) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(staged.files) != len(suffixes()) {
+				t.Errorf("%d files staged, want %d", len(staged.files), len(suffixes()))
+			}
+			for suffix, data := range staged.files {
+				// Re-encoded as JPEG with nothing of the original's metadata
+				if !bytes.HasPrefix(data, []byte{0xFF, 0xD8}) {
+					t.Errorf("%q isn't a JPEG", suffix)
+				}
+				if bytes.Contains(data, []byte("Exif\x00\x00")) || bytes.Contains(data, []byte(testCameraMake)) {
+					t.Errorf("%q kept the EXIF block", suffix)
+				}
+			}
+
+			full, err := jpeg.Decode(bytes.NewReader(staged.files[""]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b := full.Bounds(); b.Dx() != tt.width || b.Dy() != tt.height {
+				t.Errorf("stored %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.width, tt.height)
+			}
+			if got := corners(full); got != tt.corners {
+				t.Errorf("quarters %v, want %v", got, tt.corners)
+			}
+
+			for _, size := range ThumbnailSizes {
+				thumb, err := jpeg.Decode(bytes.NewReader(staged.files[thumbnailSuffix(size)]))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if b := thumb.Bounds(); b.Dx() != size || b.Dy() != size {
+					t.Errorf("thumbnail %dx%d, want %dx%d", b.Dx(), b.Dy(), size, size)
+				}
+			}
+		})
+	}
+}
+
+func TestExifOrientation(t *testing.T) {
+	plain := encodeTestJPEG(t, quarters(64, 64))
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"no exif", plain, 1},
+		{"big endian", withEXIF(plain, 6, binary.BigEndian), 6},
+		{"little endian", withEXIF(plain, 8, binary.LittleEndian), 8},
+		{"out of range", withEXIF(plain, 9, binary.BigEndian), 1},
+		{"zero", withEXIF(plain, 0, binary.BigEndian), 1},
+		{"truncated", withEXIF(plain, 6, binary.BigEndian)[:30], 1},
+		{"png", encodePNG(t, quarters(64, 64)), 1},
+		{"empty", nil, 1},
+	}
+
+	for _, tt := range tests {
+		if got := exifOrientation(tt.data); got != tt.want {
+			t.Errorf("%s: orientation = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	src := quarters(40, 20)
+
+	tests := []struct {
+		orientation   int
+		width, height int
+		corners       [4]color.RGBA
+	}{
+		{1, 40, 20, [4]color.RGBA{red, green, white, blue}},
+		{2, 40, 20, [4]color.RGBA{green, red, blue, white}},
+		{3, 40, 20, [4]color.RGBA{white, blue, red, green}},
+		{4, 40, 20, [4]color.RGBA{blue, white, green, red}},
+		{5, 20, 40, [4]color.RGBA{red, blue, white, green}},
+		{6, 20, 40, [4]color.RGBA{blue, red, green, white}},
+		{7, 20, 40, [4]color.RGBA{white, green, red, blue}},
+		{8, 20, 40, [4]color.RGBA{green, white, blue, red}},
+	}
+
+	for _, tt := range tests {
+		img := orient(src, tt.orientation)
+		if b := img.Bounds(); b.Dx() != tt.width || b.Dy() != tt.height {
+			t.Errorf("orientation %d: %dx%d, want %dx%d", tt.orientation, b.Dx(), b.Dy(), tt.width, tt.height)
+		}
+		if got := corners(img); got != tt.corners {
+			t.Errorf("orientation %d: quarters %v, want %v", tt.orientation, got, tt.corners)
+		}
+	}
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		size          int
+		wantW, wantH  int
+	}{
+		{"landscape", 800, 400, 400, 400, 200},
+		{"portrait", 300, 900, 300, 100, 300},
+		{"already small", 200, 100, 400, 200, 100},
+		{"never up", 10, 10, 400, 10, 10},
+		{"thin keeps a pixel", 4000, 1, 100, 100, 1},
+	}
+
+	for _, tt := range tests {
+		b := fit(image.NewRGBA(image.Rect(0, 0, tt.width, tt.height)), tt.size).Bounds()
+		if b.Dx() != tt.wantW || b.Dy() != tt.wantH {
+			t.Errorf("%s: %dx%d, want %dx%d", tt.name, b.Dx(), b.Dy(), tt.wantW, tt.wantH)
+		}
+	}
+}
+
+func TestThumbnailCropsTheMiddle(t *testing.T) {
+	// Red, green and blue thirds side by side, the middle square is green
+	img := image.NewRGBA(image.Rect(0, 0, 300, 100))
+	for y := range 100 {
+		for x := range 300 {
+			img.Set(x, y, []color.RGBA{red, green, blue}[x/100])
+		}
+	}
+
+	thumb := thumbnail(img, ThumbnailSmall)
+	if b := thumb.Bounds(); b.Dx() != ThumbnailSmall || b.Dy() != ThumbnailSmall {
+		t.Fatalf("%dx%d, want %dx%d", b.Dx(), b.Dy(), ThumbnailSmall, ThumbnailSmall)
+	}
+	for _, f := range []float64{.1, .5, .9} {
+		if c := colorAt(thumb, f, .5); c != green {
+			t.Errorf("pixel at %.0f%% is %v, want green", f*100, c)
+		}
+	}
+}
+
+func TestEncodeJPEGFlattensOnWhite(t *testing.T) {
+	var buf bytes.Buffer
+	if err := encodeJPEG(&buf, image.NewNRGBA(image.Rect(0, 0, 64, 64))); err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := colorAt(img, .5, .5); c != white {
+		t.Errorf("transparent pixel became %v, want white", c)
+	}
+}
@@ -0,0 +1,86 @@
+package uploads
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/internal/storage"
+)
+
+const signPurpose = "upload"
+
+// Fresh random key for a new image of the given kind, like "photos". Nothing
+// about the owner can be read from it or guessed.
+func NewKey(kind string) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return kind + "/" + hex.EncodeToString(b)
+}
+
+// Signed URL of the image under key, size 0 for the full one and one of
+// ThumbnailSizes for a thumbnail. Empty when key is. URLs only change once
+// per URLTTL so browsers can cache the image, and each lasts between one
+// and two URLTTL.
+func (s *Service) URL(key string, size int) string {
+	if key == "" {
+		return ""
+	}
+
+	file := fileKey(key, "")
+	if size != 0 {
+		file = fileKey(key, thumbnailSuffix(size))
+	}
+
+	expiresAt := time.Now().Truncate(s.URLTTL).Add(2 * s.URLTTL)
+	return s.Storage.URL(file) + "?sig=" + s.Signer.Sign(signPurpose, file, expiresAt)
+}
+
+// Serves files under /uploads/ only to requests signed for them by URL, so
+// being signed in or guessing a key isn't enough. Mount it with
+// http.StripPrefix.
+func (s *Service) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		file := r.URL.Path
+		signed, err := s.Signer.Verify(signPurpose, r.URL.Query().Get("sig"), time.Now())
+		// Not found rather than forbidden, so nobody learns which keys exist
+		if err != nil || signed != file {
+			http.NotFound(w, r)
+			return
+		}
+
+		f, err := s.Storage.Get(r.Context(), file)
+		if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidKey) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			log.Printf("Error reading upload %s: %v", file, err)
+			http.Error(w, "Error reading file", http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+
+		if contentType := mime.TypeByExtension(path.Ext(file)); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(s.URLTTL.Seconds())))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if r.Method == http.MethodHead {
+			return
+		}
+		io.Copy(w, f)
+	})
+}
@@ -0,0 +1,70 @@
+package uploads
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/pkg/signing"
+)
+
+func TestHandler(t *testing.T) {
+	s := newTestService(t)
+	s.Signer = signing.NewRandom()
+	s.URLTTL = time.Hour
+
+	put(t, s.Storage, fileKey("photos/abc", ""), testJPEG(t, 100, 100))
+	put(t, s.Storage, fileKey("photos/other", ""), testJPEG(t, 100, 100))
+	// Legacy photo under a guessable student ID
+	put(t, s.Storage, fileKey("1001", ""), testJPEG(t, 100, 100))
+
+	signed := s.URL("photos/abc", 0)
+	otherSig := strings.SplitN(s.URL("photos/other", 0), "?", 2)[1]
+
+	tests := []struct {
+		name   string
+		target string
+		method string
+		want   int
+	}{
+		{"signed", signed, http.MethodGet, http.StatusOK},
+		{"signed head", signed, http.MethodHead, http.StatusOK},
+		{"unsigned", "/uploads/photos/abc.jpg", http.MethodGet, http.StatusNotFound},
+		{"bad signature", "/uploads/photos/abc.jpg?sig=forged", http.MethodGet, http.StatusNotFound},
+		{"signature of another file", "/uploads/photos/abc.jpg?" + otherSig, http.MethodGet, http.StatusNotFound},
+		{"guessed legacy key", "/uploads/1001.jpg", http.MethodGet, http.StatusNotFound},
+		{"post", signed, http.MethodPost, http.StatusMethodNotAllowed},
+	}
+
+	h := http.StripPrefix("/uploads/", s.Handler())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			// Being signed in doesn't matter any more
+			req.AddCookie(&http.Cookie{Name: "session", Value: "whatever"})
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+
+	t.Run("expired", func(t *testing.T) {
+		u, err := url.Parse(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := strings.TrimPrefix(u.Path, "/uploads/")
+		sig := s.Signer.Sign(signPurpose, file, time.Now().Add(-time.Minute))
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, u.Path+"?sig="+url.QueryEscape(sig), nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+		}
+	})
+}
@@ -0,0 +1,217 @@
+// Package uploads validates user images and stores them re-encoded as JPEG,
+// along with square thumbnails
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/calmestend/mercado_lobito/internal/storage"
+	"github.com/calmestend/mercado_lobito/pkg/env"
+	"github.com/calmestend/mercado_lobito/pkg/signing"
+)
+
+// Side in pixels of the square thumbnails stored next to every image
+const (
+	ThumbnailSmall = 96
+	ThumbnailLarge = 320
+)
+
+var ThumbnailSizes = []int{ThumbnailSmall, ThumbnailLarge}
+
+type Service struct {
+	Storage storage.Storage
+	Limits  Limits
+	// Signs the URLs handed to browsers
+	Signer *signing.Signer
+	URLTTL time.Duration
+}
+
+func FromEnv(signer *signing.Signer) *Service {
+	return &Service{
+		Storage: storage.FromEnv(),
+		Signer:  signer,
+		URLTTL:  env.GetDuration("UPLOAD_URL_TTL", time.Hour),
+		Limits: Limits{
+			MaxBytes:        int64(env.GetInt("UPLOAD_MAX_BYTES", 5<<20)),
+			MinDimension:    env.GetInt("IMAGE_MIN_DIMENSION", 64),
+			MaxDimension:    env.GetInt("IMAGE_MAX_DIMENSION", 6000),
+			StoredDimension: env.GetInt("IMAGE_STORED_DIMENSION", 1600),
+		},
+	}
+}
+
+// Whether err means the upload itself was refused, as opposed to a failure
+// storing it. Its text is fine to show to the user.
+func IsInvalid(err error) bool {
+	return errors.Is(err, ErrNotImage) || errors.Is(err, ErrTooLarge) || errors.Is(err, ErrDimensions)
+}
+
+// Room left in a request body for the form fields and multipart headers
+// around its files
+const formOverhead = 1 << 20
+
+// Cap the body of r at what a form with up to files images can take, so an
+// endless upload is cut off instead of spooled to disk. Call before parsing
+// the form.
+func (s *Service) LimitBody(w http.ResponseWriter, r *http.Request, files int) {
+	r.Body = http.MaxBytesReader(w, r.Body, int64(files)*s.Limits.MaxBytes+formOverhead)
+}
+
+// Whether err from parsing the form means the body went over LimitBody's cap
+func BodyTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
+
+// Processed image held in memory. Nothing is stored until Commit.
+type Staged struct {
+	// Encoded JPEG by the suffix its key gets
+	files map[string][]byte
+}
+
+// Validate and re-encode the image read from r, with its thumbnails
+func (s *Service) Stage(r io.Reader) (*Staged, error) {
+	img, orientation, err := decode(r, s.Limits)
+	if err != nil {
+		return nil, err
+	}
+
+	// Scaling first keeps the rotation cheap, fit keeps the aspect ratio
+	full := orient(fit(img, s.Limits.StoredDimension), orientation)
+
+	variants := map[string]image.Image{"": full}
+	for _, size := range ThumbnailSizes {
+		variants[thumbnailSuffix(size)] = thumbnail(full, size)
+	}
+
+	staged := &Staged{files: map[string][]byte{}}
+	for suffix, variant := range variants {
+		var buf bytes.Buffer
+		if err := encodeJPEG(&buf, variant); err != nil {
+			return nil, err
+		}
+		staged.files[suffix] = buf.Bytes()
+	}
+
+	return staged, nil
+}
+
+// Store a staged image under key, replacing whatever was there
+func (s *Service) Commit(ctx context.Context, staged *Staged, key string) error {
+	for suffix, data := range staged.files {
+		err := s.Storage.Put(ctx, fileKey(key, suffix), bytes.NewReader(data), int64(len(data)), "image/jpeg")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The full size image stored under key, storage.ErrNotFound when there is
+// none
+func (s *Service) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.Storage.Get(ctx, fileKey(key, ""))
+}
+
+// Move the image under from, thumbnails included, to the key to. The copies
+// are byte for byte, nothing is re-encoded. Images stored without thumbnails,
+// from before they existed, are re-staged from the full size one instead. A
+// missing full size image is storage.ErrNotFound.
+func (s *Service) Move(ctx context.Context, from, to string) error {
+	complete, err := s.hasThumbnails(ctx, from)
+	if err != nil {
+		return err
+	}
+	if !complete {
+		return s.restage(ctx, from, to)
+	}
+
+	for _, suffix := range suffixes() {
+		if err := s.copy(ctx, fileKey(from, suffix), fileKey(to, suffix)); err != nil {
+			return err
+		}
+	}
+	return s.Remove(ctx, from)
+}
+
+func (s *Service) hasThumbnails(ctx context.Context, key string) (bool, error) {
+	for _, size := range ThumbnailSizes {
+		f, err := s.Storage.Get(ctx, fileKey(key, thumbnailSuffix(size)))
+		if errors.Is(err, storage.ErrNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		f.Close()
+	}
+	return true, nil
+}
+
+// Run the full size image under from through Stage, as if it were uploaded
+// again, and commit it under to. It has to pass the current limits.
+func (s *Service) restage(ctx context.Context, from, to string) error {
+	f, err := s.Open(ctx, from)
+	if err != nil {
+		return err
+	}
+	staged, err := s.Stage(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	if err := s.Commit(ctx, staged, to); err != nil {
+		return err
+	}
+	return s.Remove(ctx, from)
+}
+
+func (s *Service) copy(ctx context.Context, from, to string) error {
+	f, err := s.Storage.Get(ctx, from)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	return s.Storage.Put(ctx, to, bytes.NewReader(data), int64(len(data)), "image/jpeg")
+}
+
+// Delete the image under key with all of its thumbnails
+func (s *Service) Remove(ctx context.Context, key string) error {
+	var errs []error
+	for _, suffix := range suffixes() {
+		if err := s.Storage.Delete(ctx, fileKey(key, suffix)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func fileKey(key, suffix string) string {
+	return key + suffix + ".jpg"
+}
+
+// Suffix of every file stored for an image, the full size one first
+func suffixes() []string {
+	all := []string{""}
+	for _, size := range ThumbnailSizes {
+		all = append(all, thumbnailSuffix(size))
+	}
+	return all
+}
+
+func thumbnailSuffix(size int) string {
+	return "_" + strconv.Itoa(size)
+}
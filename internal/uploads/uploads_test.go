@@ -0,0 +1,138 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"testing"
+
+	"github.com/calmestend/mercado_lobito/internal/storage"
+)
+
+var testLimits = Limits{MaxBytes: 1 << 20, MinDimension: 64, MaxDimension: 2000, StoredDimension: 400}
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	return &Service{Storage: storage.NewLocal(t.TempDir()), Limits: testLimits}
+}
+
+// Solid w by h JPEG
+func testJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, color.RGBA{200, 40, 40, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func put(t *testing.T, s storage.Storage, key string, data []byte) {
+	t.Helper()
+	if err := s.Put(context.Background(), key, bytes.NewReader(data), int64(len(data)), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func get(t *testing.T, s storage.Storage, key string) []byte {
+	t.Helper()
+
+	f, err := s.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("get %s: %v", key, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestMove(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("complete", func(t *testing.T) {
+		s := newTestService(t)
+		staged, err := s.Stage(bytes.NewReader(testJPEG(t, 300, 200)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Commit(ctx, staged, "old"); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string][]byte{}
+		for _, suffix := range suffixes() {
+			want[suffix] = get(t, s.Storage, fileKey("old", suffix))
+		}
+
+		if err := s.Move(ctx, "old", "photos/new"); err != nil {
+			t.Fatal(err)
+		}
+		for _, suffix := range suffixes() {
+			if !bytes.Equal(get(t, s.Storage, fileKey("photos/new", suffix)), want[suffix]) {
+				t.Errorf("%q wasn't copied as is", suffix)
+			}
+			if _, err := s.Storage.Get(ctx, fileKey("old", suffix)); !errors.Is(err, storage.ErrNotFound) {
+				t.Errorf("%q left behind: %v", suffix, err)
+			}
+		}
+	})
+
+	t.Run("legacy without thumbnails", func(t *testing.T) {
+		s := newTestService(t)
+		put(t, s.Storage, fileKey("1001", ""), testJPEG(t, 800, 600))
+
+		if err := s.Move(ctx, "1001", "photos/new"); err != nil {
+			t.Fatal(err)
+		}
+
+		full, _, err := image.DecodeConfig(bytes.NewReader(get(t, s.Storage, fileKey("photos/new", ""))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if full.Width != testLimits.StoredDimension {
+			t.Errorf("full size width = %d, want it scaled to %d", full.Width, testLimits.StoredDimension)
+		}
+		for _, size := range ThumbnailSizes {
+			thumb, _, err := image.DecodeConfig(bytes.NewReader(get(t, s.Storage, fileKey("photos/new", thumbnailSuffix(size)))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if thumb.Width != size || thumb.Height != size {
+				t.Errorf("thumbnail %dx%d, want %dx%d", thumb.Width, thumb.Height, size, size)
+			}
+		}
+		if _, err := s.Open(ctx, "1001"); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("original left behind: %v", err)
+		}
+	})
+
+	t.Run("legacy too small", func(t *testing.T) {
+		s := newTestService(t)
+		put(t, s.Storage, fileKey("1001", ""), testJPEG(t, 10, 10))
+
+		if err := s.Move(ctx, "1001", "photos/new"); !IsInvalid(err) {
+			t.Fatalf("err = %v, want an invalid image", err)
+		}
+		// Left where it was
+		get(t, s.Storage, fileKey("1001", ""))
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		s := newTestService(t)
+		if err := s.Move(ctx, "1001", "photos/new"); !errors.Is(err, storage.ErrNotFound) {
+			t.Fatalf("err = %v, want storage.ErrNotFound", err)
+		}
+	})
+}
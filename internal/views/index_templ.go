@@ -8,9 +8,19 @@ package views
 import "github.com/a-h/templ"
 import templruntime "github.com/a-h/templ/runtime"
 
-import "github.com/calmestend/mercado_lobito/internal/components"
+import (
+	"encoding/json"
 
-func Index(children templ.Component, isAuth bool) templ.Component {
+	"github.com/calmestend/mercado_lobito/internal/components"
+)
+
+// Sent by htmx on every request so CSRFMiddleware accepts it
+func csrfHeaders(token string) string {
+	headers, _ := json.Marshal(map[string]string{"X-CSRF-Token": token})
+	return string(headers)
+}
+
+func Index(children templ.Component, isAuth bool, csrfToken string) templ.Component {
 	return templruntime.GeneratedTemplate(func(templ_7745c5c3_Input templruntime.GeneratedComponentInput) (templ_7745c5c3_Err error) {
 		templ_7745c5c3_W, ctx := templ_7745c5c3_Input.Writer, templ_7745c5c3_Input.Context
 		if templ_7745c5c3_CtxErr := ctx.Err(); templ_7745c5c3_CtxErr != nil {
@@ -31,7 +41,20 @@ func Index(children templ.Component, isAuth bool) templ.Component {
 			templ_7745c5c3_Var1 = templ.NopComponent
 		}
 		ctx = templ.ClearChildren(ctx)
-		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 1, "<!doctype html><html lang=\"en\"><head><meta charset=\"UTF-8\"><meta name=\"viewport\" content=\"width=device-width, initial-scale=1.0\"><title>Mercado Lobito</title><script src=\"https://cdn.jsdelivr.net/npm/htmx.org@2.0.6/dist/htmx.min.js\" integrity=\"sha384-Akqfrbj/HpNVo8k11SXBb6TlBWmXXlYQrCSqEWmyKJe+hDm3Z/B2WVG4smwBkRVm\" crossorigin=\"anonymous\"></script><style>\n  @import url('https://fonts.googleapis.com/css2?family=Poppins:ital,wght@0,100;0,200;0,300;0,400;0,500;0,600;0,700;0,800;0,900;1,100;1,200;1,300;1,400;1,500;1,600;1,700;1,800;1,900&display=swap');\n\n* {\n  box-sizing: border-box;\n  margin: 0;\n  padding: 0;\n  font-family: \"Poppins\", sans-serif;\n  font-size: 20px;\n}\n\nbody {\n  background-color: #D8C7F3;\n}\n\n.header {\n  background-color: #251538;\n  display: flex;\n  justify-content: space-between;\n  align-items: center;\n  height: 135px;\n  padding: 5px 10%;\n}\n\n.header .logo {\n  display: flex;\n  align-items: center;\n  gap: 15px;\n  cursor: pointer;\n}\n\n.header .logo img {\n  height: 90px;\n  width: auto;\n  transition: all 0.3s;\n}\n\n.header .logo img:hover {\n  transform: scale(1.2);\n}\n\n.header .logo .logo-text {\n  display: flex;\n  flex-direction: column;\n  color: #D8C7F3;\n}\n\n.header .logo .logo-text h2 {\n  font-size: 20px;\n  font-weight: 700;\n  margin: 0;\n  line-height: 1.2;\n}\n\n.header .logo .logo-text p {\n  font-size: 12px;\n  font-weight: 400;\n  margin: 0;\n  line-height: 1.2;\n  font-style: italic;\n}\n\n.header .nav-links {\n  list-style: none;\n  display: flex;\n  align-items: center;\n}\n\n.header .nav-links li {\n  display: inline-block;\n  padding: 0 40px;\n}\n\n.header .nav-links li:hover {\n  transform: scale(1.1);\n}\n\n.header .nav-links a {\n  font-size: 700;\n  color: #D8C7F3;\n  text-decoration: none;\n}\n\n.header .nav-links li a:hover {\n  color: #A385DF;\n}\n\n/* Contenedor de los botones con texto arriba */\n.header .btn {\n  display: flex;\n  flex-direction: column;\n  align-items: center;\n  gap: 5px;\n}\n\n/* Texto arriba de los botones */\n.header .btn .btn-text {\n  font-size: 17px;\n  font-weight: 500;\n  color: #D8C7F3;\n  text-align: center;\n  line-height: 1.2;\n}\n\n/* Contenedor de los botones*/\n.header .btn .buttons-container {\n  display: flex;\n  background: #D8C7F3;\n  border-radius: 50px;\n  overflow: hidden;\n}\n\n/* Estilo base para los botones */\n.header .btn button {\n  font-weight: 700;\n  padding: 12px 30px;\n  border: none;\n  cursor: pointer;\n  transition: transform 0.3s ease 0s;\n  font-size: 16px;\n}\n\n/* Botón de iniciar sesión*/\n.header .btn .btn-login {\n  display: inline-block;\n  padding: 8px 16px;\n  background-color: #A385DF;\n  color: #FFFFFF;\n  text-decoration: none;\n  cursor: pointer;\n  background: #A385DF;\n  border-radius: 50px 0 0 50px;\n}\n\n.header .btn .btn-login:hover {\n  transform: scale(1.05);\n}\n\n/* Botón de registro*/\n.header .btn .btn-register {\n  display: inline-block;\n  padding: 8px 16px;\n  background-color: #D8C7F3;\n  border: none;\n  text-decoration: none;\n  color: #9B82D7;\n  cursor: pointer;\n  background: #D8C7F3;\n  border-radius: 0 50px 50px 0;\n}\n\n.header .btn .btn-register:hover {\n  transform: scale(1.05);\n}\n\n/* Contendor del Carrusel */\n.carousel-container {\n  position: relative;\n  max-width: 1000px;\n  margin: 100px auto;\n  overflow: hidden;\n  border-radius: 10px;\n  box-shadow: 0 4px 15px rgba(0, 0, 0, 0.2);\n}\n\n.carousel {\n  display: flex;\n  transition: transform 0.5s ease-in-out;\n  width: 100%;\n}\n\n.carousel-item {\n  min-width: 100%;\n  flex: 0 0 100%;\n  position: relative;\n}\n\n.carousel-item img {\n  width: 100%;\n  height: 600px;\n  object-fit: cover;\n  display: block;\n}\n\n.carousel-button {\n  position: absolute;\n  top: 50%;\n  transform: translateY(-50%);\n  background: rgba(0, 0, 0, 0.6);\n  color: white;\n  padding: 12px;\n  border: none;\n  cursor: pointer;\n  font-size: 18px;\n  transition: all 0.3s ease;\n  z-index: 10;\n  border-radius: 50%;\n  width: 50px;\n  height: 50px;\n  display: flex;\n  align-items: center;\n  justify-content: center;\n}\n\n.carousel-button:hover {\n  background: rgba(0, 0, 0, 0.8);\n  transform: translateY(-50%) scale(1.1);\n}\n\n.carousel-button-prev {\n  left: 15px;\n}\n\n.carousel-button-next {\n  right: 15px;\n}\n\n.indicators {\n  position: absolute;\n  bottom: 20px;\n  left: 50%;\n  transform: translateX(-50%);\n  display: flex;\n  gap: 10px;\n  z-index: 10;\n}\n\n.indicator {\n  width: 12px;\n  height: 12px;\n  border-radius: 50%;\n  background: rgba(255, 255, 255, 0.5);\n  cursor: pointer;\n  transition: all 0.3s ease;\n}\n\n.indicator.active {\n  background: rgba(255, 255, 255, 1);\n  transform: scale(1.2);\n}\n\n.indicator:hover {\n  background: rgba(255, 255, 255, 0.8);\n}\n\n.info-section {\n  background-color: #e5d4f6;\n  /* Color lila claro como en la imagen */\n  padding: 2rem 1rem;\n  text-align: center;\n}\n\n.info-container {\n  display: flex;\n  align-items: center;\n  justify-content: center;\n  flex-wrap: wrap;\n  gap: 1rem;\n}\n\ninfo-section {\n  background-color: #644984;\n  /* Lila claro */\n  padding: 2rem 1rem;\n}\n\n.info-container {\n  max-width: 1000px;\n  margin: 0 auto;\n  display: flex;\n  justify-content: space-between;\n  align-items: center;\n  flex-wrap: wrap;\n}\n\n.info-text {\n  flex: 1;\n  text-align: left;\n}\n\n.info-text p {\n  font-size: 1.2rem;\n  font-weight: bold;\n  margin: 0;\n  color: #333;\n}\n\n.info-button {\n  text-align: right;\n}\n\n.download-btn {\n  display: inline-flex;\n  align-items: center;\n  background-color: #d9c2f2;\n  padding: 0.8rem 1.2rem;\n  border-radius: 8px;\n  text-decoration: none;\n  color: #000;\n  font-weight: bold;\n  box-shadow: 2px 2px 6px rgba(0, 0, 0, 0.2);\n  transition: background-color 0.3s ease;\n}\n\n.download-btn img {\n  height: 70px;\n  margin-right: 0.5rem;\n}\n\n.download-btn:hover {\n  background-color: #c6aef0;\n}\n\n.footer {\n  position: fixed;\n  bottom: 0;\n  left: 0;\n  width: 100%;\n  background-color: #251538;\n  color: #ffffff;\n  padding: 15px 20px;\n  font-family: sans-serif;\n  z-index: 1000;\n  box-shadow: 0 -2px 8px rgba(0, 0, 0, 0.2);\n}\n\n.footer-content {\n  display: flex;\n  justify-content: space-between;\n  align-items: center;\n  flex-wrap: wrap;\n}\n\n.footer-left {\n  display: flex;\n  align-items: center;\n  gap: 15px;\n  max-width: 60%;\n}\n\n.logo {\n  height: 60px;\n  width: auto;\n}\n\n.contact-info p {\n  margin: 2px 0;\n  font-size: 0.9em;\n  color: #ccc;\n}\n\n.footer-right {\n  text-align: right;\n  font-size: 0.9em;\n}\n\n.footer-right a {\n  color: #ccc;\n  text-decoration: none;\n  margin-left: 5px;\n}\n\n.footer-right a:hover {\n  color: #fff;\n}\n  </style></head><body>")
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 1, "<!doctype html><html lang=\"en\"><head><meta charset=\"UTF-8\"><meta name=\"viewport\" content=\"width=device-width, initial-scale=1.0\"><title>Mercado Lobito</title><meta name=\"htmx-config\" content='{\"responseHandling\":[{\"code\":\"204\",\"swap\":false},{\"code\":\"[23]..\",\"swap\":true},{\"code\":\"403\",\"swap\":true,\"error\":true},{\"code\":\"429\",\"swap\":true,\"error\":true},{\"code\":\"[45]..\",\"swap\":false,\"error\":true},{\"code\":\"...\",\"swap\":false}]}'><script src=\"https://cdn.jsdelivr.net/npm/htmx.org@2.0.6/dist/htmx.min.js\" integrity=\"sha384-Akqfrbj/HpNVo8k11SXBb6TlBWmXXlYQrCSqEWmyKJe+hDm3Z/B2WVG4smwBkRVm\" crossorigin=\"anonymous\"></script><style>\n  @import url('https://fonts.googleapis.com/css2?family=Poppins:ital,wght@0,100;0,200;0,300;0,400;0,500;0,600;0,700;0,800;0,900;1,100;1,200;1,300;1,400;1,500;1,600;1,700;1,800;1,900&display=swap');\n\n* {\n  box-sizing: border-box;\n  margin: 0;\n  padding: 0;\n  font-family: \"Poppins\", sans-serif;\n  font-size: 20px;\n}\n\nbody {\n  background-color: #D8C7F3;\n}\n\n.header {\n  background-color: #251538;\n  display: flex;\n  justify-content: space-between;\n  align-items: center;\n  height: 135px;\n  padding: 5px 10%;\n}\n\n.header .logo {\n  display: flex;\n  align-items: center;\n  gap: 15px;\n  cursor: pointer;\n}\n\n.header .logo img {\n  height: 90px;\n  width: auto;\n  transition: all 0.3s;\n}\n\n.header .logo img:hover {\n  transform: scale(1.2);\n}\n\n.header .logo .logo-text {\n  display: flex;\n  flex-direction: column;\n  color: #D8C7F3;\n}\n\n.header .logo .logo-text h2 {\n  font-size: 20px;\n  font-weight: 700;\n  margin: 0;\n  line-height: 1.2;\n}\n\n.header .logo .logo-text p {\n  font-size: 12px;\n  font-weight: 400;\n  margin: 0;\n  line-height: 1.2;\n  font-style: italic;\n}\n\n.header .nav-links {\n  list-style: none;\n  display: flex;\n  align-items: center;\n}\n\n.header .nav-links li {\n  display: inline-block;\n  padding: 0 40px;\n}\n\n.header .nav-links li:hover {\n  transform: scale(1.1);\n}\n\n.header .nav-links a {\n  font-size: 700;\n  color: #D8C7F3;\n  text-decoration: none;\n}\n\n.header .nav-links li a:hover {\n  color: #A385DF;\n}\n\n/* Contenedor de los botones con texto arriba */\n.header .btn {\n  display: flex;\n  flex-direction: column;\n  align-items: center;\n  gap: 5px;\n}\n\n/* Texto arriba de los botones */\n.header .btn .btn-text {\n  font-size: 17px;\n  font-weight: 500;\n  color: #D8C7F3;\n  text-align: center;\n  line-height: 1.2;\n}\n\n/* Contenedor de los botones*/\n.header .btn .buttons-container {\n  display: flex;\n  background: #D8C7F3;\n  border-radius: 50px;\n  overflow: hidden;\n}\n\n/* Estilo base para los botones */\n.header .btn button {\n  font-weight: 700;\n  padding: 12px 30px;\n  border: none;\n  cursor: pointer;\n  transition: transform 0.3s ease 0s;\n  font-size: 16px;\n}\n\n/* Botón de iniciar sesión*/\n.header .btn .btn-login {\n  display: inline-block;\n  padding: 8px 16px;\n  background-color: #A385DF;\n  color: #FFFFFF;\n  text-decoration: none;\n  cursor: pointer;\n  background: #A385DF;\n  border-radius: 50px 0 0 50px;\n}\n\n.header .btn .btn-login:hover {\n  transform: scale(1.05);\n}\n\n/* Botón de registro*/\n.header .btn .btn-register {\n  display: inline-block;\n  padding: 8px 16px;\n  background-color: #D8C7F3;\n  border: none;\n  text-decoration: none;\n  color: #9B82D7;\n  cursor: pointer;\n  background: #D8C7F3;\n  border-radius: 0 50px 50px 0;\n}\n\n.header .btn .btn-register:hover {\n  transform: scale(1.05);\n}\n\n/* Contendor del Carrusel */\n.carousel-container {\n  position: relative;\n  max-width: 1000px;\n  margin: 100px auto;\n  overflow: hidden;\n  border-radius: 10px;\n  box-shadow: 0 4px 15px rgba(0, 0, 0, 0.2);\n}\n\n.carousel {\n  display: flex;\n  transition: transform 0.5s ease-in-out;\n  width: 100%;\n}\n\n.carousel-item {\n  min-width: 100%;\n  flex: 0 0 100%;\n  position: relative;\n}\n\n.carousel-item img {\n  width: 100%;\n  height: 600px;\n  object-fit: cover;\n  display: block;\n}\n\n.carousel-button {\n  position: absolute;\n  top: 50%;\n  transform: translateY(-50%);\n  background: rgba(0, 0, 0, 0.6);\n  color: white;\n  padding: 12px;\n  border: none;\n  cursor: pointer;\n  font-size: 18px;\n  transition: all 0.3s ease;\n  z-index: 10;\n  border-radius: 50%;\n  width: 50px;\n  height: 50px;\n  display: flex;\n  align-items: center;\n  justify-content: center;\n}\n\n.carousel-button:hover {\n  background: rgba(0, 0, 0, 0.8);\n  transform: translateY(-50%) scale(1.1);\n}\n\n.carousel-button-prev {\n  left: 15px;\n}\n\n.carousel-button-next {\n  right: 15px;\n}\n\n.indicators {\n  position: absolute;\n  bottom: 20px;\n  left: 50%;\n  transform: translateX(-50%);\n  display: flex;\n  gap: 10px;\n  z-index: 10;\n}\n\n.indicator {\n  width: 12px;\n  height: 12px;\n  border-radius: 50%;\n  background: rgba(255, 255, 255, 0.5);\n  cursor: pointer;\n  transition: all 0.3s ease;\n}\n\n.indicator.active {\n  background: rgba(255, 255, 255, 1);\n  transform: scale(1.2);\n}\n\n.indicator:hover {\n  background: rgba(255, 255, 255, 0.8);\n}\n\n.info-section {\n  background-color: #e5d4f6;\n  /* Color lila claro como en la imagen */\n  padding: 2rem 1rem;\n  text-align: center;\n}\n\n.info-container {\n  display: flex;\n  align-items: center;\n  justify-content: center;\n  flex-wrap: wrap;\n  gap: 1rem;\n}\n\ninfo-section {\n  background-color: #644984;\n  /* Lila claro */\n  padding: 2rem 1rem;\n}\n\n.info-container {\n  max-width: 1000px;\n  margin: 0 auto;\n  display: flex;\n  justify-content: space-between;\n  align-items: center;\n  flex-wrap: wrap;\n}\n\n.info-text {\n  flex: 1;\n  text-align: left;\n}\n\n.info-text p {\n  font-size: 1.2rem;\n  font-weight: bold;\n  margin: 0;\n  color: #333;\n}\n\n.info-button {\n  text-align: right;\n}\n\n.download-btn {\n  display: inline-flex;\n  align-items: center;\n  background-color: #d9c2f2;\n  padding: 0.8rem 1.2rem;\n  border-radius: 8px;\n  text-decoration: none;\n  color: #000;\n  font-weight: bold;\n  box-shadow: 2px 2px 6px rgba(0, 0, 0, 0.2);\n  transition: background-color 0.3s ease;\n}\n\n.download-btn img {\n  height: 70px;\n  margin-right: 0.5rem;\n}\n\n.download-btn:hover {\n  background-color: #c6aef0;\n}\n\n.footer {\n  position: fixed;\n  bottom: 0;\n  left: 0;\n  width: 100%;\n  background-color: #251538;\n  color: #ffffff;\n  padding: 15px 20px;\n  font-family: sans-serif;\n  z-index: 1000;\n  box-shadow: 0 -2px 8px rgba(0, 0, 0, 0.2);\n}\n\n.footer-content {\n  display: flex;\n  justify-content: space-between;\n  align-items: center;\n  flex-wrap: wrap;\n}\n\n.footer-left {\n  display: flex;\n  align-items: center;\n  gap: 15px;\n  max-width: 60%;\n}\n\n.logo {\n  height: 60px;\n  width: auto;\n}\n\n.contact-info p {\n  margin: 2px 0;\n  font-size: 0.9em;\n  color: #ccc;\n}\n\n.footer-right {\n  text-align: right;\n  font-size: 0.9em;\n}\n\n.footer-right a {\n  color: #ccc;\n  text-decoration: none;\n  margin-left: 5px;\n}\n\n.footer-right a:hover {\n  color: #fff;\n}\n  </style></head><body hx-headers=\"")
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		var templ_7745c5c3_Var2 string
+		templ_7745c5c3_Var2, templ_7745c5c3_Err = templ.JoinStringErrs(csrfHeaders(csrfToken))
+		if templ_7745c5c3_Err != nil {
+			return templ.Error{Err: templ_7745c5c3_Err, FileName: `internal/views/index.templ`, Line: 391, Col: 43}
+		}
+		_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var2))
+		if templ_7745c5c3_Err != nil {
+			return templ_7745c5c3_Err
+		}
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 2, "\">")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
@@ -49,7 +72,7 @@ func Index(children templ.Component, isAuth bool) templ.Component {
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
-		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 2, "</body></html>")
+		templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 3, "</body></html>")
 		if templ_7745c5c3_Err != nil {
 			return templ_7745c5c3_Err
 		}
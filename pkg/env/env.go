@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -22,3 +24,57 @@ func GetEnv(variable string) (string, error) {
 	}
 	return value, nil
 }
+
+// Get optional variable, fallback is returned when it is not set
+func GetEnvDefault(variable string, fallback string) string {
+	value, err := GetEnv(variable)
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// Get optional boolean variable ("true", "1", "false", "0"...)
+func GetBool(variable string, fallback bool) bool {
+	value, err := GetEnv(variable)
+	if err != nil {
+		return fallback
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid boolean '%s' for '%s', using %t", value, variable, fallback)
+		return fallback
+	}
+	return b
+}
+
+// Get optional integer variable
+func GetInt(variable string, fallback int) int {
+	value, err := GetEnv(variable)
+	if err != nil {
+		return fallback
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid integer '%s' for '%s', using %d", value, variable, fallback)
+		return fallback
+	}
+	return i
+}
+
+// Get optional duration variable ("30s", "5m", "24h"...)
+func GetDuration(variable string, fallback time.Duration) time.Duration {
+	value, err := GetEnv(variable)
+	if err != nil {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration '%s' for '%s', using %s", value, variable, fallback)
+		return fallback
+	}
+	return d
+}
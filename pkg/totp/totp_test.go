@@ -0,0 +1,76 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// SHA-1 test vectors from RFC 6238 Appendix B. The RFC lists 8 digit codes,
+// the 6 digit ones are their last six.
+func TestCodeRFC6238(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},          // 94287082
+		{1111111109, "081804"},  // 07081804
+		{1111111111, "050471"},  // 14050471
+		{1234567890, "005924"},  // 89005924
+		{2000000000, "279037"},  // 69279037
+		{20000000000, "353130"}, // 65353130
+	}
+
+	for _, tt := range tests {
+		now := time.Unix(tt.unix, 0)
+		got, err := Code(secret, Step(now))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+
+		step, ok := Validate(secret, tt.want, now)
+		if !ok || step != Step(now) {
+			t.Errorf("Validate at %d = %d, %v, want %d, true", tt.unix, step, ok, Step(now))
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := NewSecret()
+	now := time.Unix(1700000000, 0)
+	code := func(offset int64) string {
+		c, err := Code(secret, Step(now)+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		code   string
+		wantOK bool
+	}{
+		{"current", code(0), true},
+		{"previous step", code(-1), true},
+		{"next step", code(1), true},
+		{"with spaces", " " + code(0)[:3] + " " + code(0)[3:] + " ", true},
+		{"too far behind", code(-Skew - 1), false},
+		{"too far ahead", code(Skew + 1), false},
+		{"too short", code(0)[:Digits-1], false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := Validate(secret, tt.code, now); ok != tt.wantOK {
+				t.Errorf("Validate(%q) = %v, want %v", tt.code, ok, tt.wantOK)
+			}
+		})
+	}
+}